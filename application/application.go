@@ -1,5 +1,7 @@
 package application
 
+import "time"
+
 type Flags struct {
 	Quiet             bool
 	KeepAlive         bool
@@ -17,6 +19,43 @@ type Flags struct {
 	TlsKey            string
 	Output            string
 	Reversed          bool
+	TokenBits         int
+	RateLimit         int
+	Allow             []string
+	Deny              []string
+	LanOnly           bool
+	AuditLog          string
+	Confirm           bool
+	IPVersion         int
+	DualStack         bool
+	MDNS              bool
+	MDNSURL           bool
+	LocalHostname     bool
+	Resolvers         []string
+	BasePath          string
+	PublicURL         string
+	TrustedProxies    []string
+	ProxyProtocol     bool
+	Listen            string
+	Relay             string
+	Timeout           time.Duration
+	Fetch             bool
+	Parallel          int
+	Code              bool
+	ReceiveCode       string
+	E2E               bool
+	Key               string
+	ArchivePassword   bool
+	EncryptTo         string
+	Identity          string
+	Signature         string
+	Tokens            []string
+	RotateQR          time.Duration
+	ShareReceive      string
+	ShareSend         []string
+	Users             string
+	Scan              string
+	Quarantine        string
 }
 
 type App struct {
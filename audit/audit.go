@@ -0,0 +1,252 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Redacted replaces the secret path token in recorded routes
+const Redacted = "<redacted>"
+
+// File is a file sent or received during a request
+type File struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256,omitempty"`
+	// Stored is the name the file was saved under, when it was encrypted
+	// on receipt or quarantined
+	Stored string `json:"stored,omitempty"`
+	// Verdict is the result of the scan of the file, when files are
+	// scanned
+	Verdict string `json:"verdict,omitempty"`
+}
+
+// String returns the name of the file, followed by the name it was stored
+// under if different, and by the verdict of its scan unless it is clean
+func (f File) String() string {
+	s := f.Name
+	if f.Stored != "" {
+		s += " → " + f.Stored
+	}
+	if f.Verdict != "" && f.Verdict != "clean" {
+		s += " (" + f.Verdict + ")"
+	}
+	return s
+}
+
+// Entry is a single audited request
+type Entry struct {
+	Time       time.Time `json:"time"`
+	RemoteIP   string    `json:"remote_ip"`
+	UserAgent  string    `json:"user_agent"`
+	Method     string    `json:"method"`
+	Route      string    `json:"route"`
+	User       string    `json:"user,omitempty"`
+	Status     int       `json:"status"`
+	BytesIn    int64     `json:"bytes_in"`
+	BytesOut   int64     `json:"bytes_out"`
+	DurationMs int64     `json:"duration_ms"`
+	Files      []File    `json:"files,omitempty"`
+}
+
+// files collects the files attached to a request while it runs, and the
+// user who made it
+type files struct {
+	mu    sync.Mutex
+	files []File
+	user  string
+}
+
+// Client summarizes the requests made by a single client
+type Client struct {
+	RemoteIP  string
+	UserAgent string
+	Requests  int
+	BytesIn   int64
+	BytesOut  int64
+	Files     []string
+}
+
+// Log writes audit entries as JSON lines and keeps a per-client summary
+type Log struct {
+	clientIP func(*http.Request) string
+	mu       sync.Mutex
+	out      io.WriteCloser
+	clients  map[string]*Client
+}
+
+// New creates an audit log. If filename is empty, entries are only kept in
+// the in-memory summary. clientIP extracts the client address of a request
+func New(filename string, clientIP func(*http.Request) string) (*Log, error) {
+	l := &Log{
+		clientIP: clientIP,
+		clients:  make(map[string]*Client),
+	}
+	if filename != "" {
+		f, err := os.OpenFile(filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, err
+		}
+		l.out = f
+	}
+	return l, nil
+}
+
+type contextKey struct{}
+
+// AddFile attaches a file to the entry of the request running with ctx.
+// It does nothing if the request is not being audited
+func AddFile(ctx context.Context, file File) {
+	f, ok := ctx.Value(contextKey{}).(*files)
+	if !ok {
+		return
+	}
+	f.mu.Lock()
+	f.files = append(f.files, file)
+	f.mu.Unlock()
+}
+
+// SetUser records the account the request running with ctx was made with.
+// It does nothing if the request is not being audited
+func SetUser(ctx context.Context, name string) {
+	f, ok := ctx.Value(contextKey{}).(*files)
+	if !ok {
+		return
+	}
+	f.mu.Lock()
+	f.user = name
+	f.mu.Unlock()
+}
+
+// Handler wraps next so that each request is recorded. Occurrences of token
+// in the request path are redacted, unless it is empty
+func (l *Log) Handler(token string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entry := &Entry{
+			Time:      time.Now(),
+			RemoteIP:  l.clientIP(r),
+			UserAgent: r.UserAgent(),
+			Method:    r.Method,
+			Route:     r.URL.Path,
+		}
+		if token != "" {
+			entry.Route = strings.ReplaceAll(r.URL.Path, token, Redacted)
+		}
+		body := &countingReader{ReadCloser: r.Body}
+		r.Body = body
+		rw := &responseWriter{ResponseWriter: w, status: http.StatusOK}
+		attached := &files{}
+		next(rw, r.WithContext(context.WithValue(r.Context(), contextKey{}, attached)))
+		entry.Files = attached.files
+		entry.User = attached.user
+		entry.Status = rw.status
+		entry.BytesIn = body.n
+		entry.BytesOut = rw.n
+		entry.DurationMs = time.Since(entry.Time).Milliseconds()
+		l.record(entry)
+	}
+}
+
+// record writes the entry and adds it to the summary
+func (l *Log) record(entry *Entry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.out != nil {
+		encoder := json.NewEncoder(l.out)
+		encoder.SetEscapeHTML(false)
+		encoder.Encode(entry)
+	}
+	key := entry.RemoteIP + "\x00" + entry.UserAgent
+	client, ok := l.clients[key]
+	if !ok {
+		client = &Client{RemoteIP: entry.RemoteIP, UserAgent: entry.UserAgent}
+		l.clients[key] = client
+	}
+	client.Requests++
+	client.BytesIn += entry.BytesIn
+	client.BytesOut += entry.BytesOut
+	for _, f := range entry.Files {
+		client.Files = append(client.Files, f.String())
+	}
+}
+
+// Summary returns the per-client summary, sorted by client address
+func (l *Log) Summary() []Client {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	clients := make([]Client, 0, len(l.clients))
+	for _, c := range l.clients {
+		clients = append(clients, *c)
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		if clients[i].RemoteIP != clients[j].RemoteIP {
+			return clients[i].RemoteIP < clients[j].RemoteIP
+		}
+		return clients[i].UserAgent < clients[j].UserAgent
+	})
+	return clients
+}
+
+// Close the underlying file, if any
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.out == nil {
+		return nil
+	}
+	return l.out.Close()
+}
+
+// countingReader counts the bytes read from the request body
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// responseWriter records the status code and the bytes written
+type responseWriter struct {
+	http.ResponseWriter
+	status      int
+	n           int64
+	wroteHeader bool
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// Flush implements http.Flusher
+func (w *responseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,57 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "audit.jsonl")
+	l, err := New(filename, func(r *http.Request) string { return "10.0.0.7" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := l.Handler("s3cr3t", func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		AddFile(r.Context(), File{Name: "a.txt", Size: 3})
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("done"))
+	})
+	req := httptest.NewRequest("POST", "/receive/s3cr3t", strings.NewReader("abc"))
+	req.Header.Set("User-Agent", "test")
+	handler(httptest.NewRecorder(), req)
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		t.Fatal("no entry was written")
+	}
+	if strings.Contains(scanner.Text(), "s3cr3t") {
+		t.Errorf("entry %s contains the path token", scanner.Text())
+	}
+	var got Entry
+	if err := json.Unmarshal(scanner.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Route != "/receive/"+Redacted || got.Status != http.StatusCreated ||
+		got.BytesIn != 3 || got.BytesOut != 4 || len(got.Files) != 1 {
+		t.Errorf("unexpected entry %+v", got)
+	}
+	summary := l.Summary()
+	if len(summary) != 1 || summary[0].Requests != 1 || summary[0].Files[0] != "a.txt" {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+}
@@ -53,3 +53,22 @@ func FromArgs(args []string, zipFlag bool) (Body, error) {
 		DeleteAfterTransfer: shouldzip,
 	}, nil
 }
+
+// FromArgsEncrypted returns a payload from args, always zipped and encrypted
+// with password, so that the downloaded file stays protected at rest
+func FromArgsEncrypted(args []string, password string) (Body, error) {
+	for _, arg := range args {
+		if _, err := os.Stat(arg); err != nil {
+			return Body{}, err
+		}
+	}
+	content, err := util.EncryptedZipFiles(args, password)
+	if err != nil {
+		return Body{}, err
+	}
+	return Body{
+		Path:                content,
+		Filename:            filepath.Base(content),
+		DeleteAfterTransfer: true,
+	}, nil
+}
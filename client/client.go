@@ -0,0 +1,437 @@
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/claudiodangelis/qrcp/style"
+)
+
+// DefaultParallel is the number of parallel connections of a download
+const DefaultParallel = 4
+
+// userAgent identifies the client to the server, which keeps the browser
+// only checks, like the session cookie, out of the way
+const userAgent = "mocp"
+
+// retries is the number of attempts for each range before giving up
+const retries = 3
+
+// approvalNotice is how long to wait for the first response before telling
+// the user that the sender has to approve the device
+const approvalNotice = 2 * time.Second
+
+// ErrRejected is returned when the other side rejects the device
+var ErrRejected = errors.New("this device was rejected")
+
+// filename returns the name of the file served by resp, taken from the
+// Content-Disposition header or from the URL
+func filename(resp *http.Response) string {
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		if name := filepath.Base(params["filename"]); name != "." && name != "/" && name != "" {
+			return name
+		}
+	}
+	return path.Base(resp.Request.URL.Path)
+}
+
+// parseDigest returns the SHA-256 advertised in a Repr-Digest header
+func parseDigest(header string) []byte {
+	for _, field := range strings.Split(header, ",") {
+		algorithm, value, found := strings.Cut(strings.TrimSpace(field), "=")
+		if !found || !strings.EqualFold(algorithm, "sha-256") {
+			continue
+		}
+		sum, err := base64.StdEncoding.DecodeString(strings.Trim(value, ":"))
+		if err == nil && len(sum) == sha256.Size {
+			return sum
+		}
+	}
+	return nil
+}
+
+// chunk is a range of the file downloaded by a connection
+type chunk struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+	Done  int64 `json:"done"`
+}
+
+// state is saved next to the partial file so that an interrupted download
+// can be resumed
+type state struct {
+	URL    string  `json:"url"`
+	Size   int64   `json:"size"`
+	Digest []byte  `json:"digest,omitempty"`
+	Chunks []chunk `json:"chunks"`
+}
+
+// matches reports whether the saved state belongs to the same file
+func (s *state) matches(rawurl string, size int64, digest []byte) bool {
+	if s.Size != size {
+		return false
+	}
+	if digest != nil {
+		return string(s.Digest) == string(digest)
+	}
+	return s.URL == rawurl
+}
+
+// downloader fetches one file
+type downloader struct {
+	client   *http.Client
+	url      string
+	state    *state
+	mutex    sync.Mutex
+	progress *progressWriter
+}
+
+// Download fetches the file shared at rawurl into dir with up to parallel
+// connections, rendering a progress bar, and returns the path of the
+// downloaded file. Interrupted downloads are resumed from the `.part` file
+func Download(rawurl string, dir string, parallel int) (string, error) {
+	if _, err := url.Parse(rawurl); err != nil {
+		return "", err
+	}
+	httpClient, err := newHTTPClient()
+	if err != nil {
+		return "", err
+	}
+	d := &downloader{client: httpClient, url: rawurl}
+	// Ask for the size, the name and the digest of the file first
+	resp, err := d.request(http.MethodHead, "")
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+	if strings.Contains(resp.Request.URL.Path, "/receive/") {
+		return "", errors.New("this share receives files, open its URL to upload")
+	}
+	name := filename(resp)
+	size := resp.ContentLength
+	digest := parseDigest(resp.Header.Get("Repr-Digest"))
+	ranges := resp.Header.Get("Accept-Ranges") == "bytes" && size > 0
+	target := filepath.Join(dir, name)
+	if _, err := os.Stat(target); err == nil {
+		return "", fmt.Errorf("%s already exists", target)
+	}
+	partPath := target + ".part"
+	statePath := partPath + ".json"
+	// Resume from the saved state if it belongs to the same file
+	if b, err := os.ReadFile(statePath); err == nil && ranges {
+		saved := &state{}
+		if json.Unmarshal(b, saved) == nil && saved.matches(rawurl, size, digest) {
+			d.state = saved
+			d.state.URL = rawurl
+		}
+	}
+	if d.state == nil {
+		d.state = &state{URL: rawurl, Size: size, Digest: digest}
+		if !ranges {
+			parallel = 1
+		}
+		d.state.Chunks = split(size, max(parallel, 1))
+	}
+	flags := os.O_RDWR | os.O_CREATE
+	if d.state.done() == 0 {
+		flags |= os.O_TRUNC
+	}
+	out, err := os.OpenFile(partPath, flags, 0644)
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+	d.progress = &progressWriter{name: name, total: size, current: d.state.done(), resumed: d.state.done(), start: time.Now()}
+	// Render the progress and save the state periodically
+	stop := make(chan struct{})
+	rendered := make(chan struct{})
+	go func() {
+		defer close(rendered)
+		ticker := time.NewTicker(200 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				d.progress.render()
+				if ranges {
+					d.save(statePath)
+				}
+			case <-stop:
+				d.progress.render()
+				fmt.Println()
+				return
+			}
+		}
+	}()
+	var wg sync.WaitGroup
+	errs := make(chan error, len(d.state.Chunks))
+	for i := range d.state.Chunks {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- d.fetch(out, i, ranges)
+		}(i)
+	}
+	wg.Wait()
+	close(stop)
+	<-rendered
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			if ranges {
+				d.save(statePath)
+				return "", fmt.Errorf("%w (run the command again to resume)", err)
+			}
+			return "", err
+		}
+	}
+	if err := out.Close(); err != nil {
+		return "", err
+	}
+	if digest != nil {
+		if err := verify(partPath, digest); err != nil {
+			os.Remove(partPath)
+			os.Remove(statePath)
+			return "", err
+		}
+	}
+	os.Remove(statePath)
+	if err := os.Rename(partPath, target); err != nil {
+		return "", err
+	}
+	return target, nil
+}
+
+// newHTTPClient returns a client that keeps the cookies of the session
+func newHTTPClient() (*http.Client, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Jar: jar}, nil
+}
+
+// do sends req. With notice set, it tells the user when the other side has
+// to approve the device first
+func do(c *http.Client, req *http.Request, notice bool) (*http.Response, error) {
+	req.Header.Set("User-Agent", userAgent)
+	if notice {
+		timer := time.AfterFunc(approvalNotice, func() {
+			fmt.Println("Waiting for approval of this device...")
+		})
+		defer timer.Stop()
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusForbidden {
+		resp.Body.Close()
+		return nil, ErrRejected
+	}
+	return resp, nil
+}
+
+// request sends a request for the file
+func (d *downloader) request(method string, byteRange string) (*http.Response, error) {
+	req, err := http.NewRequest(method, d.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if byteRange != "" {
+		req.Header.Set("Range", byteRange)
+	}
+	return do(d.client, req, method == http.MethodHead)
+}
+
+// fetch downloads the i-th chunk, retrying on errors
+func (d *downloader) fetch(out *os.File, i int, ranges bool) error {
+	var err error
+	for attempt := 0; attempt < retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+		if err = d.fetchOnce(out, i, ranges); err == nil || errors.Is(err, ErrRejected) {
+			return err
+		}
+		// Without ranges, the download starts over
+		if !ranges {
+			d.progress.add(-d.chunk(i).Done)
+			d.mutex.Lock()
+			d.state.Chunks[i].Done = 0
+			d.mutex.Unlock()
+		}
+	}
+	return err
+}
+
+// fetchOnce downloads what is left of the i-th chunk
+func (d *downloader) fetchOnce(out *os.File, i int, ranges bool) error {
+	c := d.chunk(i)
+	if c.Start+c.Done > c.End && c.End >= 0 {
+		return nil
+	}
+	byteRange := ""
+	if ranges {
+		byteRange = fmt.Sprintf("bytes=%d-%d", c.Start+c.Done, c.End)
+	}
+	resp, err := d.request(http.MethodGet, byteRange)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	want := http.StatusOK
+	if ranges {
+		want = http.StatusPartialContent
+	}
+	if resp.StatusCode != want {
+		return fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+	buf := make([]byte, 32*1024)
+	offset := c.Start + c.Done
+	for {
+		n, rerr := resp.Body.Read(buf)
+		if n > 0 {
+			if _, err := out.WriteAt(buf[:n], offset); err != nil {
+				return err
+			}
+			offset += int64(n)
+			d.mutex.Lock()
+			d.state.Chunks[i].Done += int64(n)
+			d.mutex.Unlock()
+			d.progress.add(int64(n))
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			return rerr
+		}
+	}
+	if c = d.chunk(i); c.End >= 0 && c.Start+c.Done <= c.End {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+// chunk returns a copy of the i-th chunk
+func (d *downloader) chunk(i int) chunk {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.state.Chunks[i]
+}
+
+// save writes the state of the download next to the partial file
+func (d *downloader) save(statePath string) {
+	d.mutex.Lock()
+	b, err := json.Marshal(d.state)
+	d.mutex.Unlock()
+	if err != nil {
+		return
+	}
+	os.WriteFile(statePath, b, 0644)
+}
+
+// done returns the bytes already downloaded
+func (s *state) done() int64 {
+	var done int64
+	for _, c := range s.Chunks {
+		done += c.Done
+	}
+	return done
+}
+
+// split divides size bytes in n chunks. An unknown size makes one chunk
+// without end
+func split(size int64, n int) []chunk {
+	if size <= 0 {
+		return []chunk{{Start: 0, End: -1}}
+	}
+	n = int(min(int64(n), size))
+	chunks := make([]chunk, 0, n)
+	length := size / int64(n)
+	for i := 0; i < n; i++ {
+		start := int64(i) * length
+		end := start + length - 1
+		if i == n-1 {
+			end = size - 1
+		}
+		chunks = append(chunks, chunk{Start: start, End: end})
+	}
+	return chunks
+}
+
+// verify compares the SHA-256 of the file at path with digest
+func verify(path string, digest []byte) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return err
+	}
+	if string(hash.Sum(nil)) != string(digest) {
+		return errors.New("checksum mismatch, the downloaded file is corrupted")
+	}
+	return nil
+}
+
+// progressWriter renders a progress bar of the bytes transferred
+type progressWriter struct {
+	mutex   sync.Mutex
+	name    string
+	total   int64
+	current int64
+	// resumed is what was downloaded before, left out of the rate
+	resumed  int64
+	start    time.Time
+	rendered time.Time
+}
+
+// Write records the bytes written to it, rendering the progress bar at
+// most every 200ms
+func (p *progressWriter) Write(b []byte) (int, error) {
+	p.add(int64(len(b)))
+	if time.Since(p.rendered) > 200*time.Millisecond {
+		p.render()
+	}
+	return len(b), nil
+}
+
+// add records n bytes downloaded
+func (p *progressWriter) add(n int64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.current += n
+}
+
+func (p *progressWriter) render() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	elapsed := time.Since(p.start)
+	var rate float64
+	if elapsed > 0 {
+		rate = float64(p.current-p.resumed) / elapsed.Seconds()
+	}
+	fmt.Printf("\r\033[2K%s", style.AnimatedProgressBarWithStats(p.current, p.total, p.name, rate, elapsed))
+	p.rendered = time.Now()
+}
@@ -0,0 +1,64 @@
+package client
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func share(content []byte, digest []byte) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Disposition", `attachment; filename="file.bin"`)
+		w.Header().Set("Repr-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(digest)+":")
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+}
+
+func TestDownloadResume(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 4096)
+	sum := sha256.Sum256(content)
+	srv := share(content, sum[:])
+	defer srv.Close()
+	dir := t.TempDir()
+	// Leave a partial download behind, with the first half of the first
+	// chunk done
+	chunks := split(int64(len(content)), 2)
+	chunks[0].Done = chunks[0].End / 2
+	b, _ := json.Marshal(state{URL: srv.URL, Size: int64(len(content)), Digest: sum[:], Chunks: chunks})
+	partPath := filepath.Join(dir, "file.bin.part")
+	os.WriteFile(partPath+".json", b, 0644)
+	os.WriteFile(partPath, content[:chunks[0].Done], 0644)
+
+	path, err := Download(srv.URL, dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := os.ReadFile(path)
+	if !bytes.Equal(got, content) {
+		t.Errorf("downloaded %d bytes, not matching the content", len(got))
+	}
+	if _, err := os.Stat(partPath + ".json"); !os.IsNotExist(err) {
+		t.Errorf("state file left behind: %v", err)
+	}
+}
+
+func TestDownloadChecksumMismatch(t *testing.T) {
+	content := []byte("hello")
+	sum := sha256.Sum256([]byte("something else"))
+	srv := share(content, sum[:])
+	defer srv.Close()
+	dir := t.TempDir()
+	if _, err := Download(srv.URL, dir, DefaultParallel); err == nil {
+		t.Fatal("expected a checksum error")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "file.bin")); !os.IsNotExist(err) {
+		t.Errorf("corrupted file kept: %v", err)
+	}
+}
@@ -0,0 +1,248 @@
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/claudiodangelis/qrcp/audit"
+)
+
+// Receipt lists the files stored by a receive session
+type Receipt struct {
+	Files []audit.File `json:"files"`
+}
+
+// upload is a file to push
+type upload struct {
+	path string
+	// name is the path of the file in the receiving folder, with slashes
+	name string
+	size int64
+	hash hash.Hash
+}
+
+// collect lists the files to push. Folders are walked, and their files keep
+// their path below the folder
+func collect(paths []string) ([]*upload, error) {
+	uploads := []*upload{}
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			uploads = append(uploads, &upload{path: p, name: filepath.Base(p), size: info.Size()})
+			continue
+		}
+		root := filepath.Dir(filepath.Clean(p))
+		err = filepath.WalkDir(p, func(file string, entry fs.DirEntry, err error) error {
+			if err != nil || !entry.Type().IsRegular() {
+				return err
+			}
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(root, file)
+			if err != nil {
+				return err
+			}
+			uploads = append(uploads, &upload{path: file, name: filepath.ToSlash(rel), size: info.Size()})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(uploads) == 0 {
+		return nil, errors.New("no files to push")
+	}
+	return uploads, nil
+}
+
+// countingWriter counts the bytes written to it
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	c.n += int64(len(b))
+	return len(b), nil
+}
+
+// contentLength returns the size of the multipart body of the uploads
+func contentLength(boundary string, uploads []*upload) (int64, error) {
+	counter := &countingWriter{}
+	mw := multipart.NewWriter(counter)
+	if err := mw.SetBoundary(boundary); err != nil {
+		return 0, err
+	}
+	for _, u := range uploads {
+		if _, err := mw.CreateFormFile("files", u.name); err != nil {
+			return 0, err
+		}
+		counter.n += u.size
+	}
+	if err := mw.Close(); err != nil {
+		return 0, err
+	}
+	return counter.n, nil
+}
+
+// writeUploads streams the uploads as a multipart body, rendering the
+// progress of each file
+func writeUploads(mw *multipart.Writer, uploads []*upload) error {
+	for _, u := range uploads {
+		part, err := mw.CreateFormFile("files", u.name)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(u.path)
+		if err != nil {
+			return err
+		}
+		u.hash = sha256.New()
+		progress := &progressWriter{name: u.name, total: u.size, start: time.Now()}
+		_, err = io.Copy(part, io.TeeReader(f, io.MultiWriter(u.hash, progress)))
+		f.Close()
+		progress.render()
+		fmt.Println()
+		if err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+// Push uploads the files and folders at paths to the receive session at
+// rawurl, retrying on transient failures, and returns the receipt of the
+// server
+func Push(rawurl string, paths []string) (*Receipt, error) {
+	uploads, err := collect(paths)
+	if err != nil {
+		return nil, err
+	}
+	httpClient, err := newHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	// Open the upload page first, which waits for the approval of the
+	// device when needed
+	req, err := http.NewRequest(http.MethodGet, rawurl, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := do(httpClient, req, true)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+	if !strings.Contains(resp.Request.URL.Path, "/receive/") {
+		return nil, errors.New("this share sends files, use mocp get to download them")
+	}
+	// Post to the page reached, as the URL may redirect, like the ones of
+	// rotating QR codes
+	rawurl = resp.Request.URL.String()
+	for attempt := 0; ; attempt++ {
+		receipt, err := pushOnce(httpClient, rawurl, uploads)
+		var transient transientError
+		if err == nil || !errors.As(err, &transient) || attempt == retries-1 {
+			if err == nil {
+				err = verifyReceipt(receipt, uploads)
+			}
+			return receipt, err
+		}
+		fmt.Printf("Upload failed: %v, retrying\n", err)
+		time.Sleep(time.Duration(attempt+1) * time.Second)
+	}
+}
+
+// transientError is a failure of the connection or of the server, after
+// which the upload is retried
+type transientError struct {
+	err error
+}
+
+func (e transientError) Error() string {
+	return e.err.Error()
+}
+
+func (e transientError) Unwrap() error {
+	return e.err
+}
+
+// pushOnce sends the uploads in one request
+func pushOnce(httpClient *http.Client, rawurl string, uploads []*upload) (*Receipt, error) {
+	reader, writer := io.Pipe()
+	mw := multipart.NewWriter(writer)
+	length, err := contentLength(mw.Boundary(), uploads)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, rawurl, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = length
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Accept", "application/json")
+	written := make(chan error, 1)
+	go func() {
+		err := writeUploads(mw, uploads)
+		writer.CloseWithError(err)
+		written <- err
+	}()
+	resp, err := do(httpClient, req, false)
+	reader.Close()
+	if werr := <-written; werr != nil && !errors.Is(werr, io.ErrClosedPipe) {
+		// Failing to read the local files is not transient
+		return nil, werr
+	}
+	if err != nil {
+		if errors.Is(err, ErrRejected) {
+			return nil, err
+		}
+		return nil, transientError{err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode >= http.StatusInternalServerError {
+		return nil, transientError{fmt.Errorf("unexpected response: %s", resp.Status)}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+	receipt := &Receipt{}
+	if err := json.NewDecoder(resp.Body).Decode(receipt); err != nil {
+		return nil, fmt.Errorf("invalid receipt: %w", err)
+	}
+	return receipt, nil
+}
+
+// verifyReceipt compares the receipt with the files that were sent
+func verifyReceipt(receipt *Receipt, uploads []*upload) error {
+	if len(receipt.Files) != len(uploads) {
+		return fmt.Errorf("the server stored %d files out of %d", len(receipt.Files), len(uploads))
+	}
+	for i, u := range uploads {
+		file := receipt.Files[i]
+		if file.Size != u.size || file.SHA256 != hex.EncodeToString(u.hash.Sum(nil)) {
+			return fmt.Errorf("checksum mismatch for %s", u.name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/claudiodangelis/qrcp/audit"
+)
+
+// receiver answers like a receive session storing the files in dir. If
+// truncate is set, it stores and reports one byte less of each file
+func receiver(dir string, truncate bool) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/receive/abc", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			return
+		}
+		reader, err := r.MultipartReader()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		receipt := Receipt{}
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			content, _ := io.ReadAll(part)
+			if truncate {
+				content = content[:len(content)-1]
+			}
+			// The path below the folder is sent in the file name, which
+			// part.FileName() strips
+			_, params, _ := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+			name := params["filename"]
+			path := filepath.Join(dir, filepath.FromSlash(name))
+			os.MkdirAll(filepath.Dir(path), 0755)
+			os.WriteFile(path, content, 0644)
+			sum := sha256.Sum256(content)
+			receipt.Files = append(receipt.Files, audit.File{Name: name, Size: int64(len(content)), SHA256: hex.EncodeToString(sum[:])})
+		}
+		json.NewEncoder(w).Encode(receipt)
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestPush(t *testing.T) {
+	src := t.TempDir()
+	os.WriteFile(filepath.Join(src, "single.txt"), []byte("a single file"), 0644)
+	os.MkdirAll(filepath.Join(src, "folder", "sub"), 0755)
+	os.WriteFile(filepath.Join(src, "folder", "first.txt"), []byte("the first file"), 0644)
+	os.WriteFile(filepath.Join(src, "folder", "sub", "second.txt"), []byte("the second file"), 0644)
+	dst := t.TempDir()
+	srv := receiver(dst, false)
+	defer srv.Close()
+	receipt, err := Push(srv.URL+"/receive/abc", []string{filepath.Join(src, "single.txt"), filepath.Join(src, "folder")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(receipt.Files) != 3 {
+		t.Fatalf("%d files in the receipt, want 3", len(receipt.Files))
+	}
+	// Files keep their path below the folder pushed
+	for name, want := range map[string]string{
+		"single.txt":            "a single file",
+		"folder/first.txt":      "the first file",
+		"folder/sub/second.txt": "the second file",
+	} {
+		got, err := os.ReadFile(filepath.Join(dst, filepath.FromSlash(name)))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if string(got) != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestPushChecksumMismatch(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "file.txt")
+	os.WriteFile(src, []byte("hello"), 0644)
+	srv := receiver(t.TempDir(), true)
+	defer srv.Close()
+	if _, err := Push(srv.URL+"/receive/abc", []string{src}); err == nil {
+		t.Error("expected a checksum error")
+	}
+	// Shares sending files are refused
+	if _, err := Push(srv.URL+"/send/abc", []string{src}); err == nil {
+		t.Error("pushed to a send share")
+	}
+}
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/claudiodangelis/qrcp/body"
+	"github.com/claudiodangelis/qrcp/server"
+	"github.com/claudiodangelis/qrcp/style"
+	"github.com/claudiodangelis/qrcp/wormhole"
+)
+
+// codeTimeout bounds the time the receiver looks for the sender of a code
+const codeTimeout = 30 * time.Second
+
+// codeProgress returns a callback rendering the progress of a transfer
+// with a code
+func codeProgress() func(string, int64, int64) {
+	start := time.Now()
+	var rendered time.Time
+	return func(name string, current, total int64) {
+		if current < total && time.Since(rendered) < 200*time.Millisecond {
+			return
+		}
+		rendered = time.Now()
+		elapsed := time.Since(start)
+		var rate float64
+		if elapsed > 0 {
+			rate = float64(current) / elapsed.Seconds()
+		}
+		fmt.Printf("\r\033[2K%s", style.AnimatedProgressBarWithStats(current, total, name, rate, elapsed))
+	}
+}
+
+// sendWithCode sends the payload to the receiver knowing a code, instead
+// of showing a QR code
+func sendWithCode(payload body.Body) error {
+	code, err := wormhole.NewCode()
+	if err != nil {
+		server.ShowError(err)
+		return err
+	}
+	fmt.Printf("On the other computer, run:\n\n    %smocp receive --code %s%s\n\n", style.BrightYellow, code, style.Reset)
+	err = wormhole.Send(code, payload.Path, payload.Filename, wormhole.Options{
+		Relay:    app.Flags.Relay,
+		Port:     app.Flags.Port,
+		Progress: codeProgress(),
+	})
+	fmt.Println()
+	if payload.DeleteAfterTransfer {
+		if err := payload.Delete(); err != nil {
+			server.ShowError(err)
+		}
+	}
+	if err != nil {
+		server.ShowError(err)
+		return err
+	}
+	fmt.Println(style.SuccessMessage("Sent " + payload.Filename))
+	return nil
+}
+
+// receiveWithCode receives the file of the sender that printed code
+func receiveWithCode(code string) error {
+	output := app.Flags.Output
+	if output == "" {
+		output = "."
+	}
+	if app.Flags.Relay == "" {
+		fmt.Println("Looking for the sender on the local network...")
+	}
+	path, err := wormhole.Receive(code, output, wormhole.Options{
+		Relay:    app.Flags.Relay,
+		Timeout:  codeTimeout,
+		Progress: codeProgress(),
+	})
+	if err != nil {
+		server.ShowError(err)
+		return err
+	}
+	fmt.Println()
+	fmt.Println(style.SuccessMessage("Received " + path))
+	return nil
+}
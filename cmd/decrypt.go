@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/claudiodangelis/qrcp/e2e"
+	"github.com/claudiodangelis/qrcp/server"
+	"github.com/claudiodangelis/qrcp/style"
+	"github.com/claudiodangelis/qrcp/util"
+	"github.com/spf13/cobra"
+)
+
+func decryptCmdFunc(command *cobra.Command, args []string) error {
+	if (app.Flags.Key == "") == (app.Flags.Identity == "") {
+		err := errors.New("either --key or --identity is required")
+		server.ShowError(err)
+		return err
+	}
+	// Files received with --e2e are decrypted with the key of the session,
+	// files received with --encrypt-to with the age identity
+	var decrypt func(path string, dir string) (string, error)
+	if app.Flags.Identity != "" {
+		identities, err := e2e.ReadIdentities(util.Expand(app.Flags.Identity))
+		if err != nil {
+			server.ShowError(err)
+			return err
+		}
+		decrypt = func(path string, dir string) (string, error) {
+			return e2e.DecryptAgeFile(path, identities, dir)
+		}
+	} else {
+		key, err := e2e.ParseKey(app.Flags.Key)
+		if err != nil {
+			server.ShowError(err)
+			return err
+		}
+		decrypt = func(path string, dir string) (string, error) {
+			return e2e.DecryptFile(path, key, dir)
+		}
+	}
+	for _, path := range args {
+		output := app.Flags.Output
+		if output == "" {
+			output = filepath.Dir(path)
+		}
+		decrypted, err := decrypt(path, output)
+		if err != nil {
+			err = fmt.Errorf("%s: %w", path, err)
+			server.ShowError(err)
+			return err
+		}
+		fmt.Println(style.SuccessMessage("Decrypted " + decrypted))
+	}
+	return nil
+}
+
+var decryptCmd = &cobra.Command{
+	Use:   "decrypt <file>...",
+	Short: "Decrypt files received with end-to-end encryption or --encrypt-to",
+	Long:  "Decrypt the files received with --e2e, which are stored encrypted, with the key printed at the end of the transfer, or the .age files received with --encrypt-to, with the age identity matching the public key. Each file gets back its original name, next to the encrypted file unless --output is set.",
+	Example: `# Decrypt the received files
+mocp decrypt --key <key> 3f2a9c1b7d5e8f60.mocp 0b1c2d3e4f5a6b7c.mocp
+# Decrypt a file downloaded from a share, with the URL of the share
+mocp decrypt --key 'https://192.168.1.10:8080/send/abcdef#key=<key>' download.mocp
+# Decrypt the files received with --encrypt-to, with the key made by age-keygen
+mocp decrypt --identity ~/.config/age/key.txt report.pdf.age
+`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: decryptCmdFunc,
+}
@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/claudiodangelis/qrcp/client"
+	"github.com/claudiodangelis/qrcp/discovery"
+	"github.com/claudiodangelis/qrcp/server"
+	"github.com/claudiodangelis/qrcp/style"
+	"github.com/manifoldco/promptui"
+	"github.com/spf13/cobra"
+)
+
+func discoverCmdFunc(command *cobra.Command, args []string) error {
+	fmt.Printf("Looking for shares on the local network for %s...\n", app.Flags.Timeout)
+	shares, err := discovery.Browse(app.Flags.Timeout)
+	if err != nil {
+		server.ShowError(err)
+		return err
+	}
+	if len(shares) == 0 {
+		fmt.Println("No shares found")
+		return nil
+	}
+	items := []string{}
+	for _, share := range shares {
+		address := share.URL
+		if address == "" {
+			address = net.JoinHostPort(share.Host, strconv.Itoa(share.Port))
+		}
+		item := fmt.Sprintf("%s%-8s%s %s", style.BrightYellow, share.Mode, style.Reset, address)
+		if share.Filename != "" {
+			item += fmt.Sprintf(" %s(%s, %s)%s", style.BrightBlack, share.Filename, style.FormatSize(share.Size), style.Reset)
+		}
+		if share.Mode == "code" {
+			item += fmt.Sprintf(" %s(code %s-...)%s", style.BrightBlack, share.Nameplate, style.Reset)
+		}
+		if share.PIN {
+			item += " [approval required]"
+		}
+		items = append(items, item)
+		fmt.Println(item)
+	}
+	if !app.Flags.Fetch {
+		return nil
+	}
+	prompt := promptui.Select{
+		Items: items,
+		Label: "Choose a share to download from",
+	}
+	index, _, err := prompt.Run()
+	if err != nil {
+		return err
+	}
+	share := shares[index]
+	switch share.Mode {
+	case "receive":
+		err := errors.New("this share receives files, open its URL to upload")
+		server.ShowError(err)
+		return err
+	case "code":
+		err := errors.New("this share needs its code, use mocp receive --code")
+		server.ShowError(err)
+		return err
+	}
+	if share.URL == "" {
+		err := errors.New("this share doesn't advertise its URL, scan its QR code")
+		server.ShowError(err)
+		return err
+	}
+	output := app.Flags.Output
+	if output == "" {
+		output = "."
+	}
+	path, err := client.Download(share.URL, output, client.DefaultParallel)
+	if err != nil {
+		server.ShowError(err)
+		return err
+	}
+	fmt.Println(style.SuccessMessage("Downloaded " + path))
+	return nil
+}
+
+var discoverCmd = &cobra.Command{
+	Use:   "discover",
+	Short: "Find shares on the local network",
+	Long:  "List the shares advertised on the local network with --mdns, and optionally download from one of those advertising their URL with --mdns-url.",
+	Example: `# List the shares on the local network
+mocp discover
+# Choose a share and download its file to /tmp
+mocp discover --fetch --output /tmp
+`,
+	RunE: discoverCmdFunc,
+}
@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/claudiodangelis/qrcp/qr"
+	"github.com/claudiodangelis/qrcp/server"
+	"github.com/claudiodangelis/qrcp/style"
+)
+
+// qrCycler renders the QR code of the URL to scan. When the server listens
+// on several interfaces, it shows one QR code at a time and can cycle
+// between them
+type qrCycler struct {
+	srv       *server.Server
+	url       string
+	endpoints []server.Endpoint
+	index     int
+	reversed  bool
+}
+
+func newQRCycler(srv *server.Server, url string, reversed bool) *qrCycler {
+	return &qrCycler{srv: srv, url: url, endpoints: srv.Endpoints, reversed: reversed}
+}
+
+// URL returns the URL currently displayed
+func (c *qrCycler) URL() string {
+	if c.srv.Rotates() {
+		url, _ := c.srv.JoinURL()
+		return url
+	}
+	if len(c.endpoints) == 0 {
+		return c.url
+	}
+	return c.endpoints[c.index].URL
+}
+
+// Render prints the QR code of the current URL, followed by a blank line
+// reserved for progress updates
+func (c *qrCycler) Render() {
+	if c.srv.Rotates() {
+		_, next := c.srv.JoinURL()
+		qr.RenderStringWithSideOverwrite(c.URL(), c.reversed, []string{
+			fmt.Sprintf("%sThis QR code rotates%s", style.Bold, style.Reset),
+			fmt.Sprintf("next at %s", next.Format(time.TimeOnly)),
+			"",
+			fmt.Sprintf("%sDevices that joined stay connected%s", style.BrightBlack, style.Reset),
+		})
+		fmt.Println()
+		return
+	}
+	if len(c.endpoints) == 0 {
+		qr.RenderStringWithSideOverwrite(c.url, c.reversed, nil)
+		fmt.Println()
+		return
+	}
+	fmt.Println(style.BrightCyan + "Listening on every interface:" + style.Reset)
+	for i, e := range c.endpoints {
+		marker := " "
+		if i == c.index {
+			marker = "→"
+		}
+		fmt.Printf("  %s %s%-12s%s %s\n", marker, style.ColorForInterface(e.Interface), e.Interface, style.Reset, e.URL)
+	}
+	e := c.endpoints[c.index]
+	side := []string{
+		fmt.Sprintf("%sInterface:%s %s", style.Bold, style.Reset, e.Interface),
+		fmt.Sprintf("%sAddress:%s %s", style.Bold, style.Reset, e.Address),
+		"",
+		fmt.Sprintf("%s←/→ to switch interface%s", style.BrightBlack, style.Reset),
+	}
+	qr.RenderStringWithSideOverwrite(e.URL, c.reversed, side)
+	fmt.Println()
+}
+
+// Cycles reports whether there is more than one QR code to cycle through
+func (c *qrCycler) Cycles() bool {
+	return !c.srv.Rotates() && len(c.endpoints) > 1
+}
+
+// Next clears the screen and shows the QR code of the next interface, or of
+// the previous one if step is negative
+func (c *qrCycler) Next(step int) {
+	if !c.Cycles() {
+		return
+	}
+	c.index = (c.index + step + len(c.endpoints)) % len(c.endpoints)
+	c.redraw()
+}
+
+// redraw clears the screen and shows the QR code again, followed by the QR
+// codes of the links that weren't revoked
+func (c *qrCycler) redraw() {
+	// Clear the screen and move the cursor to the top
+	fmt.Print("\033[2J\033[H")
+	server.ShowQRCode()
+	c.Render()
+	for _, token := range c.srv.Tokens() {
+		if !token.Revoked {
+			renderToken(token, c.reversed)
+		}
+	}
+}
+
+// Rotate redraws the QR code each time it rotates, until the server stops
+func (c *qrCycler) Rotate() {
+	if !c.srv.Rotates() {
+		return
+	}
+	go func() {
+		for {
+			_, next := c.srv.JoinURL()
+			time.Sleep(time.Until(next))
+			c.redraw()
+		}
+	}()
+}
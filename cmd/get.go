@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/claudiodangelis/qrcp/client"
+	"github.com/claudiodangelis/qrcp/e2e"
+	"github.com/claudiodangelis/qrcp/server"
+	"github.com/claudiodangelis/qrcp/style"
+	"github.com/spf13/cobra"
+)
+
+func getCmdFunc(command *cobra.Command, args []string) error {
+	output := app.Flags.Output
+	if output == "" {
+		output = "."
+	}
+	path, err := client.Download(args[0], output, app.Flags.Parallel)
+	if err != nil {
+		server.ShowError(err)
+		return err
+	}
+	// Shares encrypted end to end carry the key in the fragment of the URL
+	if strings.Contains(args[0], "#") {
+		key, err := e2e.ParseKey(args[0])
+		if err != nil {
+			server.ShowError(err)
+			return err
+		}
+		decrypted, err := e2e.DecryptFile(path, key, output)
+		if err != nil {
+			server.ShowError(err)
+			return err
+		}
+		os.Remove(path)
+		path = decrypted
+	}
+	fmt.Println(style.SuccessMessage("Downloaded " + path))
+	return nil
+}
+
+var getCmd = &cobra.Command{
+	Use:   "get <url>",
+	Short: "Download a file from a mocp share",
+	Long:  "Download the file shared at the URL of a mocp share, with parallel connections. Interrupted downloads are resumed when the command is run again, and the file is verified against the checksum advertised by the sender.",
+	Example: `# Download a file to the current directory
+mocp get http://192.168.1.10:8080/send/abcdef
+# Download a file to /tmp with 8 connections
+mocp get --output /tmp --parallel 8 http://192.168.1.10:8080/send/abcdef
+# Download and decrypt a file shared with --e2e
+mocp get 'https://192.168.1.10:8080/send/abcdef#key=<key>'
+`,
+	Args: cobra.ExactArgs(1),
+	RunE: getCmdFunc,
+}
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/claudiodangelis/qrcp/identity"
+	"github.com/claudiodangelis/qrcp/server"
+	"github.com/claudiodangelis/qrcp/style"
+	"github.com/spf13/cobra"
+)
+
+// showIdentity prints the public key and the fingerprint of id
+func showIdentity(id *identity.Identity) {
+	fmt.Printf("Public key:  %s\n", identity.EncodePublicKey(id.PublicKey()))
+	fmt.Printf("Fingerprint: %s\n", identity.Fingerprint(id.PublicKey()))
+}
+
+var identityCmd = &cobra.Command{
+	Use:   "identity",
+	Short: "Manage the identity signing the files you send",
+	Long:  "Manage the ed25519 identity signing the files you send. Once created, every send is signed, and the recipients can check with mocp verify that the files come from you and are intact.",
+}
+
+var identityInitCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Create your identity",
+	Long:  "Create a new ed25519 identity in the configuration folder. Share the public key or the fingerprint with your recipients, through a channel they trust.",
+	Args:  cobra.NoArgs,
+	RunE: func(command *cobra.Command, args []string) error {
+		id, err := identity.Generate()
+		if err != nil {
+			server.ShowError(err)
+			return err
+		}
+		path := identity.Path(app.Name)
+		if err := id.Save(path); err != nil {
+			server.ShowError(err)
+			return err
+		}
+		fmt.Println(style.SuccessMessage("Identity saved to " + path))
+		showIdentity(id)
+		return nil
+	},
+}
+
+var identityShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Print your public key and fingerprint",
+	Args:  cobra.NoArgs,
+	RunE: func(command *cobra.Command, args []string) error {
+		id, err := identity.Load(identity.Path(app.Name))
+		if err != nil {
+			server.ShowError(err)
+			return err
+		}
+		showIdentity(id)
+		return nil
+	},
+}
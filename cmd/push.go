@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/claudiodangelis/qrcp/client"
+	"github.com/claudiodangelis/qrcp/server"
+	"github.com/claudiodangelis/qrcp/style"
+	"github.com/spf13/cobra"
+)
+
+func pushCmdFunc(command *cobra.Command, args []string) error {
+	receipt, err := client.Push(args[0], args[1:])
+	if err != nil {
+		server.ShowError(err)
+		return err
+	}
+	fmt.Println(style.SuccessMessage(fmt.Sprintf("Pushed %d files", len(receipt.Files))))
+	for _, file := range receipt.Files {
+		fmt.Printf("  %s%s%s %s%s  sha256:%s%s\n", style.BrightWhite, file.Name, style.Reset, style.BrightBlack, style.FormatSize(file.Size), file.SHA256, style.Reset)
+		// Files refused by the scanner of the receiver are quarantined
+		if file.Verdict != "" && file.Verdict != "clean" {
+			server.ShowWarning(fmt.Sprintf("%s was quarantined by the receiver (%s)", file.Name, file.Verdict))
+		}
+	}
+	return nil
+}
+
+var pushCmd = &cobra.Command{
+	Use:   "push <url> <file|folder>...",
+	Short: "Upload files to a mocp receive session",
+	Long:  "Upload files and folders to the URL of a running `mocp receive`, without a browser. Folders keep their structure, and the receipt of the server is printed once the upload is done.",
+	Example: `# Upload a file and a folder
+mocp push http://10.0.0.5:8080/receive/abcdef file1 dir/
+`,
+	Args: cobra.MinimumNArgs(2),
+	RunE: pushCmdFunc,
+}
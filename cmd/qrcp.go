@@ -1,7 +1,10 @@
 package cmd
 
 import (
+	"time"
+
 	"github.com/claudiodangelis/qrcp/application"
+	"github.com/claudiodangelis/qrcp/client"
 	"github.com/spf13/cobra"
 )
 
@@ -14,17 +17,59 @@ func init() {
 	rootCmd.AddCommand(configCmd)
 	rootCmd.AddCommand(versionCmd)
 	rootCmd.AddCommand(completionCmd)
+	rootCmd.AddCommand(discoverCmd)
+	rootCmd.AddCommand(relayCmd)
+	rootCmd.AddCommand(getCmd)
+	rootCmd.AddCommand(pushCmd)
+	rootCmd.AddCommand(decryptCmd)
+	rootCmd.AddCommand(identityCmd)
+	rootCmd.AddCommand(verifyCmd)
+	rootCmd.AddCommand(shareCmd)
+	rootCmd.AddCommand(serverCmd)
+	rootCmd.AddCommand(userCmd)
 	configCmd.AddCommand(migrateCmd)
+	identityCmd.AddCommand(identityInitCmd)
+	identityCmd.AddCommand(identityShowCmd)
+	shareCmd.AddCommand(shareCreateCmd)
+	shareCmd.AddCommand(shareStartCmd)
+	shareCmd.AddCommand(shareListCmd)
+	shareCmd.AddCommand(shareDeleteCmd)
+	userCmd.AddCommand(userAddCmd)
+	userCmd.AddCommand(userDelCmd)
+	userCmd.AddCommand(userPasswdCmd)
 	// Global command flags
 	rootCmd.PersistentFlags().BoolVarP(&app.Flags.Quiet, "quiet", "q", false, "only print errors")
 	rootCmd.PersistentFlags().BoolVarP(&app.Flags.KeepAlive, "keep-alive", "k", false, "keep server alive after transferring")
 	rootCmd.PersistentFlags().BoolVarP(&app.Flags.ListAllInterfaces, "list-all-interfaces", "l", false, "list all available interfaces when choosing the one to use")
 	rootCmd.PersistentFlags().IntVarP(&app.Flags.Port, "port", "p", 0, "port to use for the server")
 	rootCmd.PersistentFlags().StringVar(&app.Flags.Path, "path", "", "path to use. Defaults to a random string")
+	rootCmd.PersistentFlags().IntVar(&app.Flags.TokenBits, "token-bits", 0, "entropy in bits of the random path. Defaults to 128")
+	rootCmd.PersistentFlags().IntVar(&app.Flags.RateLimit, "rate-limit", 0, "requests per second allowed for each client IP, -1 disables the limit")
+	rootCmd.PersistentFlags().StringSliceVar(&app.Flags.Allow, "allow", nil, "only accept connections from these IPs or CIDR blocks")
+	rootCmd.PersistentFlags().StringSliceVar(&app.Flags.Deny, "deny", nil, "refuse connections from these IPs or CIDR blocks")
+	rootCmd.PersistentFlags().BoolVar(&app.Flags.LanOnly, "lan-only", false, "only accept connections from the subnets of the chosen interface")
+	rootCmd.PersistentFlags().StringVar(&app.Flags.AuditLog, "audit-log", "", "append a JSON line for every request to this file")
+	rootCmd.PersistentFlags().BoolVar(&app.Flags.Confirm, "confirm", false, "ask for confirmation before accepting each new device")
+	rootCmd.PersistentFlags().IntVar(&app.Flags.IPVersion, "ip-version", 0, "preferred IP version of the interface address, 4 or 6")
+	rootCmd.PersistentFlags().BoolVar(&app.Flags.DualStack, "dual-stack", false, "listen on both the IPv4 and the IPv6 address of the interface")
+	rootCmd.PersistentFlags().BoolVar(&app.Flags.MDNS, "mdns", false, "advertise the share on the local network via multicast DNS")
+	rootCmd.PersistentFlags().BoolVar(&app.Flags.MDNSURL, "mdns-url", false, "with --mdns, advertise the URL of the share too, letting anyone on the network use it without the QR code")
+	rootCmd.PersistentFlags().BoolVar(&app.Flags.LocalHostname, "local-hostname", false, "use <hostname>.local instead of the IP address in the resulting URLs")
+	rootCmd.PersistentFlags().StringSliceVar(&app.Flags.Resolvers, "resolver", nil, "resolvers of the external IP with interface any, tried in order (consensus, stun:<server>, http:<url>, static:<ip>, default-route)")
+	rootCmd.PersistentFlags().StringVar(&app.Flags.BasePath, "base-path", "", "prefix for all routes, e.g. when served behind a reverse proxy")
+	rootCmd.PersistentFlags().StringVar(&app.Flags.PublicURL, "public-url", "", "public URL of the server, e.g. https://files.lab/mocp, used in the QR code")
+	rootCmd.PersistentFlags().StringSliceVar(&app.Flags.TrustedProxies, "trusted-proxy", nil, "IPs or CIDR blocks of reverse proxies whose forwarded headers are trusted")
+	rootCmd.PersistentFlags().BoolVar(&app.Flags.ProxyProtocol, "proxy-protocol", false, "expect a PROXY protocol v1 or v2 header on every connection")
+	rootCmd.PersistentFlags().StringVar(&app.Flags.Listen, "listen", "", "listen on a Unix socket (unix:/path) or on sockets passed by systemd (systemd)")
+	rootCmd.PersistentFlags().StringVar(&app.Flags.Relay, "relay", "", "serve through the relay at this URL, for clients on other networks")
+	rootCmd.PersistentFlags().BoolVar(&app.Flags.E2E, "e2e", false, "encrypt files end to end with a key kept in the URL fragment, needs HTTPS")
+	rootCmd.PersistentFlags().DurationVar(&app.Flags.RotateQR, "rotate-qr", 0, "change the QR code every period, e.g. 30s, so that a photo of it soon stops working")
+	rootCmd.PersistentFlags().StringArrayVar(&app.Flags.Tokens, "token", nil, "add a link with its own QR code and rights, as scopes[:ttl[:uses]], e.g. read-only:1h or upload-only::3")
 	rootCmd.PersistentFlags().StringVarP(&app.Flags.Interface, "interface", "i", "", "network interface to use for the server")
 	rootCmd.PersistentFlags().StringVar(&app.Flags.Bind, "bind", "", "address to bind the web server to")
 	rootCmd.PersistentFlags().StringVarP(&app.Flags.FQDN, "fqdn", "d", "", "fully-qualified domain name to use for the resulting URLs")
 	rootCmd.PersistentFlags().BoolVarP(&app.Flags.Zip, "zip", "z", false, "zip content before transferring")
+	rootCmd.PersistentFlags().BoolVar(&app.Flags.ArchivePassword, "archive-password", false, "zip content in an AES-256 encrypted archive, with a password asked for or generated")
 	rootCmd.PersistentFlags().StringVarP(&app.Flags.Config, "config", "c", "", "path to the config file, defaults to $XDG_CONFIG_HOME/qrcp/config.json")
 	rootCmd.PersistentFlags().BoolVarP(&app.Flags.Browser, "browser", "b", false, "display the QR code in a browser window")
 	rootCmd.PersistentFlags().BoolVarP(&app.Flags.Secure, "secure", "s", false, "use https connection")
@@ -33,6 +78,42 @@ func init() {
 	rootCmd.PersistentFlags().BoolVarP(&app.Flags.Reversed, "reversed", "r", false, "Reverse QR code (black text on white background)")
 	// Receive command flags
 	receiveCmd.PersistentFlags().StringVarP(&app.Flags.Output, "output", "o", "", "output directory for receiving files")
+	receiveCmd.Flags().StringVar(&app.Flags.ReceiveCode, "code", "", "receive from the sender that printed this code, e.g. 7-crossbow-lantern")
+	receiveCmd.Flags().StringVar(&app.Flags.EncryptTo, "encrypt-to", "", "encrypt the received files to the age public keys of this file, as name.age")
+	receiveCmd.Flags().StringVar(&app.Flags.Scan, "scan", "", "scan the received files with clamd:unix:<path>, clamd:tcp:<host:port> or command:<command>")
+	receiveCmd.Flags().StringVar(&app.Flags.Quarantine, "quarantine", "", "folder the infected files are moved to when scanning")
+	// Send command flags
+	sendCmd.Flags().BoolVar(&app.Flags.Code, "code", false, "print a code for the receiver to type instead of showing a QR code")
+	// Discover command flags
+	discoverCmd.Flags().DurationVarP(&app.Flags.Timeout, "timeout", "t", 3*time.Second, "how long to look for shares")
+	discoverCmd.Flags().BoolVarP(&app.Flags.Fetch, "fetch", "f", false, "choose a share to download from")
+	discoverCmd.Flags().StringVarP(&app.Flags.Output, "output", "o", "", "output directory for downloaded files")
+	// Get command flags
+	getCmd.Flags().StringVarP(&app.Flags.Output, "output", "o", "", "output directory for the downloaded file")
+	getCmd.Flags().IntVarP(&app.Flags.Parallel, "parallel", "n", client.DefaultParallel, "number of parallel connections")
+	// Decrypt command flags
+	decryptCmd.Flags().StringVar(&app.Flags.Key, "key", "", "key printed at the end of the transfer, or the URL of the share")
+	decryptCmd.Flags().StringVar(&app.Flags.Identity, "identity", "", "age identity file to decrypt the files received with --encrypt-to")
+	decryptCmd.Flags().StringVarP(&app.Flags.Output, "output", "o", "", "output directory for the decrypted files")
+	// Verify command flags
+	verifyCmd.Flags().StringVar(&app.Flags.Signature, "sig", "", "signature of the file, defaults to <file>.sig")
+	verifyCmd.Flags().StringVar(&app.Flags.Key, "key", "", "public key the file must be signed with, or a file holding it")
+	// Server command flags
+	serverCmd.Flags().StringVar(&app.Flags.Users, "users", "", "users file, YAML or htpasswd with bcrypt hashes")
+	serverCmd.Flags().StringVarP(&app.Flags.Output, "output", "o", "", "folder holding the inbox and the outbox of each user")
+	serverCmd.Flags().StringVar(&app.Flags.EncryptTo, "encrypt-to", "", "encrypt the received files to the age public keys of this file, as name.age")
+	serverCmd.Flags().StringVar(&app.Flags.Scan, "scan", "", "scan the received files with clamd:unix:<path>, clamd:tcp:<host:port> or command:<command>")
+	serverCmd.Flags().StringVar(&app.Flags.Quarantine, "quarantine", "", "folder the infected files are moved to when scanning")
+	serverCmd.MarkFlagRequired("users")
+	// User command flags
+	userCmd.PersistentFlags().StringVar(&app.Flags.Users, "users", "", "users file, YAML or htpasswd with bcrypt hashes")
+	userCmd.MarkPersistentFlagRequired("users")
+	// Share command flags
+	shareCreateCmd.Flags().StringVar(&app.Flags.ShareReceive, "receive", "", "folder receiving the files uploaded to the share")
+	shareCreateCmd.Flags().StringArrayVar(&app.Flags.ShareSend, "send", nil, "file or folder sent by the share, can be repeated")
+	shareCreateCmd.Flags().StringVar(&app.Flags.EncryptTo, "encrypt-to", "", "encrypt the received files to the age public keys of this file, as name.age")
+	shareCreateCmd.Flags().StringVar(&app.Flags.Scan, "scan", "", "scan the received files with clamd:unix:<path>, clamd:tcp:<host:port> or command:<command>")
+	shareCreateCmd.Flags().StringVar(&app.Flags.Quarantine, "quarantine", "", "folder the infected files are moved to when scanning")
 }
 
 // The root command (`mocp`) is like a shortcut of the transfer command
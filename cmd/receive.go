@@ -5,7 +5,6 @@ import (
 
 	"github.com/claudiodangelis/qrcp/config"
 	"github.com/claudiodangelis/qrcp/logger"
-	"github.com/claudiodangelis/qrcp/qr"
 	"github.com/claudiodangelis/qrcp/server"
 	"github.com/eiannone/keyboard"
 	"github.com/spf13/cobra"
@@ -14,8 +13,16 @@ import (
 func receiveCmdFunc(command *cobra.Command, args []string) error {
 	log := logger.New(app.Flags.Quiet)
 	server.ShowStartupBanner()
+	// Receive from the sender of a code instead of showing a QR code
+	if app.Flags.ReceiveCode != "" {
+		return receiveWithCode(app.Flags.ReceiveCode)
+	}
 	// Load configuration
 	cfg := config.New(app)
+	if err := checkConfirmKeyboard(cfg); err != nil {
+		server.ShowError(err)
+		return err
+	}
 	// Create the server
 	srv, err := server.New(&cfg)
 	if err != nil {
@@ -29,19 +36,58 @@ func receiveCmdFunc(command *cobra.Command, args []string) error {
 	}
 	// Prints the URL to scan to screen
 	server.ShowQRCode()
-	// Renders the QR
-	qr.RenderString(srv.ReceiveURL, cfg.Reversed)
+	// Renders the QR, one per interface when listening on all of them. A
+	// line below the QR area is reserved for in-place progress updates
+	cycler := newQRCycler(srv, srv.ReceiveURL, cfg.Reversed)
+	cycler.Render()
+	cycler.Rotate()
 	if app.Flags.Browser {
-		srv.DisplayQR(srv.ReceiveURL)
+		srv.DisplayQR(cycler.URL())
 	}
+	// Renders a QR code for each link with its own rights
+	if err := mintTokens(srv, cfg.Tokens, cfg.Reversed); err != nil {
+		server.ShowError(err)
+		return err
+	}
+	tokens := &tokenKeys{srv: srv, reversed: cfg.Reversed}
 	if err := keyboard.Open(); err == nil {
 		defer func() {
 			keyboard.Close()
 		}()
+		showTokenHelp()
 		go func() {
 			for {
 				char, key, _ := keyboard.GetKey()
-				if string(char) == "q" || key == keyboard.KeyCtrlC {
+				if key == keyboard.KeyCtrlC {
+					srv.Shutdown()
+					continue
+				}
+				// Answer the pending approval prompt, if any. Enter picks
+				// the default, other keys are ignored meanwhile so that they
+				// don't reject the device
+				if srv.AwaitingApproval() {
+					switch {
+					case char == 'y' || char == 'Y':
+						srv.Approve(true)
+					case char == 'n' || char == 'N' || key == keyboard.KeyEnter:
+						srv.Approve(false)
+					}
+					continue
+				}
+				// Cycle the QR code between interfaces
+				if key == keyboard.KeyArrowRight || key == keyboard.KeyArrowLeft {
+					step := 1
+					if key == keyboard.KeyArrowLeft {
+						step = -1
+					}
+					cycler.Next(step)
+					continue
+				}
+				// Mint and revoke links
+				if tokens.Handle(char, key) {
+					continue
+				}
+				if string(char) == "q" {
 					srv.Shutdown()
 				}
 			}
@@ -67,3 +113,15 @@ qrcp receive --output /tmp
 `,
 	RunE: receiveCmdFunc,
 }
+
+// checkConfirmKeyboard fails when --confirm is set without a keyboard to
+// answer the prompts, before the server starts listening
+func checkConfirmKeyboard(cfg config.Config) error {
+	if !cfg.Confirm {
+		return nil
+	}
+	if err := keyboard.Open(); err != nil {
+		return fmt.Errorf("--confirm needs a keyboard to answer prompts: %v", err)
+	}
+	return keyboard.Close()
+}
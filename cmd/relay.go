@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/claudiodangelis/qrcp/relay"
+	"github.com/claudiodangelis/qrcp/server"
+	"github.com/spf13/cobra"
+)
+
+// defaultRelayPort is used when --port is not set
+const defaultRelayPort = 8080
+
+func relayCmdFunc(command *cobra.Command, args []string) error {
+	port := app.Flags.Port
+	if port == 0 {
+		port = defaultRelayPort
+	}
+	addr := net.JoinHostPort(app.Flags.Bind, strconv.Itoa(port))
+	httpserver := &http.Server{
+		Addr:              addr,
+		Handler:           relay.NewServer(app.Flags.PublicURL),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	fmt.Printf("Relay listening on %s\n", addr)
+	var err error
+	if app.Flags.Secure {
+		err = httpserver.ListenAndServeTLS(app.Flags.TlsCert, app.Flags.TlsKey)
+	} else {
+		err = httpserver.ListenAndServe()
+	}
+	server.ShowError(err)
+	return err
+}
+
+var relayCmd = &cobra.Command{
+	Use:   "relay",
+	Short: "Run a relay for devices on different networks",
+	Long:  "Run a relay that mocp instances started with --relay connect to, and that forwards the requests of the clients to them. Use it when the sender and the receiver can't reach each other directly.",
+	Example: `# Run a relay on port 8080
+mocp relay
+# Run a relay with HTTPS, reachable at https://relay.example.com
+mocp relay --port 443 --secure --tls-cert cert.pem --tls-key key.pem --public-url https://relay.example.com
+# Send a file through the relay
+mocp send --relay https://relay.example.com /path/file.txt
+`,
+	Args: cobra.NoArgs,
+	RunE: relayCmdFunc,
+}
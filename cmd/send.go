@@ -1,24 +1,42 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
 	"github.com/claudiodangelis/qrcp/body"
 	"github.com/claudiodangelis/qrcp/config"
+	"github.com/claudiodangelis/qrcp/identity"
 	"github.com/claudiodangelis/qrcp/logger"
-	"github.com/claudiodangelis/qrcp/qr"
+	"github.com/claudiodangelis/qrcp/util"
 	"github.com/eiannone/keyboard"
+	"github.com/manifoldco/promptui"
 
 	"github.com/claudiodangelis/qrcp/server"
 	"github.com/spf13/cobra"
 )
 
+// archivePasswordBits is the entropy of generated archive passwords
+const archivePasswordBits = 96
+
 func sendCmdFunc(command *cobra.Command, args []string) error {
 	log := logger.New(app.Flags.Quiet)
 	server.ShowStartupBanner()
-	payload, err := body.FromArgs(args, app.Flags.Zip)
-	if err != nil {
+	var payload body.Body
+	var err error
+	if app.Flags.ArchivePassword {
+		password, generated, err := promptArchivePassword()
+		if err != nil {
+			server.ShowError(err)
+			return err
+		}
+		if payload, err = body.FromArgsEncrypted(args, password); err != nil {
+			server.ShowError(err)
+			return err
+		}
+		server.ShowArchivePassword(generated)
+	} else if payload, err = body.FromArgs(args, app.Flags.Zip); err != nil {
 		server.ShowError(err)
 		return err
 	}
@@ -29,16 +47,27 @@ func sendCmdFunc(command *cobra.Command, args []string) error {
 		return err
 	}
 	server.ShowFileInfo(payload.Filename, fi.Size())
+	// Send to a receiver knowing a code instead of showing a QR code
+	if app.Flags.Code {
+		return sendWithCode(payload)
+	}
 	// Initialize config and get interface selection
 	cfg := config.New(app)
-
-	// Choose interface before starting server
-	iface, err := config.ChooseInterface(app.Flags)
-	if err != nil {
+	if err := checkConfirmKeyboard(cfg); err != nil {
 		server.ShowError(err)
 		return err
 	}
-	cfg.Interface = iface
+
+	// Choose interface before starting server, unless passed as a flag or
+	// listening on a socket
+	if app.Flags.Interface == "" && cfg.Listen == "" && cfg.Relay == "" {
+		iface, err := config.ChooseInterface(app.Flags)
+		if err != nil {
+			server.ShowError(err)
+			return err
+		}
+		cfg.Interface = iface
+	}
 
 	srv, err := server.New(&cfg)
 	if err != nil {
@@ -46,23 +75,72 @@ func sendCmdFunc(command *cobra.Command, args []string) error {
 		return err
 	}
 
+	// Sign the file with the identity of the user, if any
+	id, err := identity.Load(identity.Path(app.Name))
+	if err == nil {
+		srv.SignWith(id)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		server.ShowError(err)
+		return err
+	}
 	// Sets the body
-	srv.Send(payload)
+	if err := srv.Send(payload); err != nil {
+		server.ShowError(err)
+		return err
+	}
 	server.ShowQRCode()
-	qr.RenderStringWithSideOverwrite(srv.SendURL, cfg.Reversed, nil)
-	// Reserve a line below the QR area for in-place progress updates
-	fmt.Println()
+	// Renders the QR, one per interface when listening on all of them. A
+	// line below the QR area is reserved for in-place progress updates
+	cycler := newQRCycler(srv, srv.SendURL, cfg.Reversed)
+	cycler.Render()
+	cycler.Rotate()
 	if app.Flags.Browser {
-		srv.DisplayQR(srv.SendURL)
+		srv.DisplayQR(cycler.URL())
 	}
+	// Renders a QR code for each link with its own rights
+	if err := mintTokens(srv, cfg.Tokens, cfg.Reversed); err != nil {
+		server.ShowError(err)
+		return err
+	}
+	tokens := &tokenKeys{srv: srv, reversed: cfg.Reversed}
 	if err := keyboard.Open(); err == nil {
 		defer func() {
 			keyboard.Close()
 		}()
+		showTokenHelp()
 		go func() {
 			for {
 				char, key, _ := keyboard.GetKey()
-				if string(char) == "q" || key == keyboard.KeyCtrlC {
+				if key == keyboard.KeyCtrlC {
+					srv.Shutdown()
+					continue
+				}
+				// Answer the pending approval prompt, if any. Enter picks
+				// the default, other keys are ignored meanwhile so that they
+				// don't reject the device
+				if srv.AwaitingApproval() {
+					switch {
+					case char == 'y' || char == 'Y':
+						srv.Approve(true)
+					case char == 'n' || char == 'N' || key == keyboard.KeyEnter:
+						srv.Approve(false)
+					}
+					continue
+				}
+				// Cycle the QR code between interfaces
+				if key == keyboard.KeyArrowRight || key == keyboard.KeyArrowLeft {
+					step := 1
+					if key == keyboard.KeyArrowLeft {
+						step = -1
+					}
+					cycler.Next(step)
+					continue
+				}
+				// Mint and revoke links
+				if tokens.Handle(char, key) {
+					continue
+				}
+				if string(char) == "q" {
 					srv.Shutdown()
 				}
 			}
@@ -76,6 +154,37 @@ func sendCmdFunc(command *cobra.Command, args []string) error {
 	return nil
 }
 
+// promptArchivePassword asks for the password of the archive, or generates
+// one, which is returned as generated too. The password is never read from
+// the command line, which would keep it in the shell history
+func promptArchivePassword() (password string, generated string, err error) {
+	prompt := promptui.Prompt{
+		Label: "Archive password (leave empty to generate one)",
+		Mask:  '*',
+	}
+	if password, err = prompt.Run(); err != nil {
+		return "", "", err
+	}
+	if password == "" {
+		password, err = util.GetRandomURLPath(archivePasswordBits, "")
+		return password, password, err
+	}
+	confirm := promptui.Prompt{
+		Label: "Confirm the password",
+		Mask:  '*',
+		Validate: func(input string) error {
+			if input != password {
+				return errors.New("the passwords don't match")
+			}
+			return nil
+		},
+	}
+	if _, err = confirm.Run(); err != nil {
+		return "", "", err
+	}
+	return password, "", nil
+}
+
 var sendCmd = &cobra.Command{
 	Use:     "transfer",
 	Short:   "Transfer a file(s) or directories from this host",
@@ -91,6 +200,8 @@ mocp /path/file1.gif /path/file2.gif
 mocp /path/directory
 # Transfer file.gif by creating a webserver on port 8080
 mocp --port 8080 /path/file.gif
+# Transfer the directory in a zip encrypted with a password
+mocp --archive-password /path/directory
 `,
 	Args: cobra.MinimumNArgs(1),
 	RunE: sendCmdFunc,
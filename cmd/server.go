@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/claudiodangelis/qrcp/config"
+	"github.com/claudiodangelis/qrcp/logger"
+	"github.com/claudiodangelis/qrcp/qr"
+	"github.com/claudiodangelis/qrcp/server"
+	"github.com/claudiodangelis/qrcp/style"
+	"github.com/claudiodangelis/qrcp/users"
+	"github.com/claudiodangelis/qrcp/util"
+	"github.com/eiannone/keyboard"
+	"github.com/spf13/cobra"
+)
+
+func serverCmdFunc(command *cobra.Command, args []string) error {
+	log := logger.New(app.Flags.Quiet)
+	server.ShowStartupBanner()
+	store, err := users.Load(util.Expand(app.Flags.Users))
+	if err != nil {
+		server.ShowError(err)
+		return err
+	}
+	if len(store.Names()) == 0 {
+		server.ShowWarning(fmt.Sprintf("There are no users yet, add one with `mocp user add <name> --users %s`", app.Flags.Users))
+	}
+	// A drop server runs until it is stopped
+	app.Flags.KeepAlive = true
+	cfg := config.New(app)
+	cfg.Accounts = true
+	if cfg.Output == "" {
+		err := errors.New("the folder of the accounts is required, set it with --output")
+		server.ShowError(err)
+		return err
+	}
+	if err := checkConfirmKeyboard(cfg); err != nil {
+		server.ShowError(err)
+		return err
+	}
+	srv, err := server.New(&cfg)
+	if err != nil {
+		server.ShowError(err)
+		return err
+	}
+	if err := srv.ServeAccounts(store, util.Expand(cfg.Output)); err != nil {
+		server.ShowError(err)
+		return err
+	}
+	server.ShowQRCode()
+	qr.RenderStringWithSide(srv.LoginURL, cfg.Reversed, []string{
+		fmt.Sprintf("%sDrop server%s", style.Bold, style.Reset),
+		fmt.Sprintf("%d users, files in %s", len(store.Names()), cfg.Output),
+		"",
+		srv.LoginURL,
+	})
+	if err := keyboard.Open(); err == nil {
+		defer func() {
+			keyboard.Close()
+		}()
+		go func() {
+			for {
+				char, key, _ := keyboard.GetKey()
+				if key == keyboard.KeyCtrlC {
+					srv.Shutdown()
+					continue
+				}
+				// Answer the pending approval prompt, if any. Enter picks
+				// the default, other keys are ignored meanwhile so that they
+				// don't reject the device
+				if srv.AwaitingApproval() {
+					switch {
+					case char == 'y' || char == 'Y':
+						srv.Approve(true)
+					case char == 'n' || char == 'N' || key == keyboard.KeyEnter:
+						srv.Approve(false)
+					}
+					continue
+				}
+				if string(char) == "q" {
+					srv.Shutdown()
+				}
+			}
+		}()
+	} else {
+		log.Print(fmt.Sprintf("Warning: keyboard not detected: %v", err))
+	}
+	return srv.Wait()
+}
+
+var serverCmd = &cobra.Command{
+	Use:   "server",
+	Short: "Run a drop server with an account for each user",
+	Long:  "Run a long-running drop server. Users log in on a web page with the password of the users file, a YAML file or an htpasswd file with bcrypt hashes, managed with mocp user. Each user has an inbox receiving their uploads and an outbox for the files shared with them, below the folder of --output, and can download and delete their own files.",
+	Example: `# Create an account, then serve the accounts below /srv/drop
+mocp user add alice --users users.yml
+mocp server --users users.yml --output /srv/drop`,
+	Args: cobra.NoArgs,
+	RunE: serverCmdFunc,
+}
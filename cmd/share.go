@@ -0,0 +1,204 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/claudiodangelis/qrcp/config"
+	"github.com/claudiodangelis/qrcp/server"
+	"github.com/claudiodangelis/qrcp/share"
+	"github.com/claudiodangelis/qrcp/style"
+	"github.com/claudiodangelis/qrcp/util"
+	"github.com/spf13/cobra"
+)
+
+var shareCmd = &cobra.Command{
+	Use:   "share",
+	Short: "Manage shares that keep their URL across restarts",
+	Long:  "Manage named shares, e.g. a drop box whose QR code is printed next to a bench. A share keeps its random token, its port, its TLS certificate and its policy in the configuration folder, so that it comes back up at the exact same URL each time it is started.",
+}
+
+var shareCreateCmd = &cobra.Command{
+	Use:   "create <name>",
+	Short: "Create a share receiving to a folder or sending files",
+	Long:  "Create a share receiving to the folder of --receive, or sending the files of --send. The port, the interface, the FQDN and the policy flags (--allow, --deny, --lan-only, --rate-limit, --confirm, --audit-log, --encrypt-to, --scan, --quarantine) are stored with it. With --secure, the certificate of --tls-cert is copied, or a self-signed one is generated.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(command *cobra.Command, args []string) error {
+		if err := createShare(args[0]); err != nil {
+			server.ShowError(err)
+			return err
+		}
+		return nil
+	},
+}
+
+var shareStartCmd = &cobra.Command{
+	Use:   "start <name>",
+	Short: "Start a share at its URL",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(command *cobra.Command, args []string) error {
+		s, err := share.Load(share.Dir(app.Name), args[0])
+		if err != nil {
+			server.ShowError(err)
+			return err
+		}
+		s.Apply(&app.Flags)
+		if s.Receive != "" {
+			return receiveCmdFunc(command, nil)
+		}
+		return sendCmdFunc(command, s.Send)
+	},
+}
+
+var shareListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the shares",
+	Args:  cobra.NoArgs,
+	RunE: func(command *cobra.Command, args []string) error {
+		shares, err := share.List(share.Dir(app.Name))
+		if err != nil {
+			server.ShowError(err)
+			return err
+		}
+		if len(shares) == 0 {
+			fmt.Println("There are no shares, create one with `mocp share create`")
+			return nil
+		}
+		for _, s := range shares {
+			target := s.Receive
+			if s.Receive == "" {
+				target = strings.Join(s.Send, ", ")
+			}
+			scheme := "http"
+			if s.Secure {
+				scheme = "https"
+			}
+			fmt.Printf("%s%-16s%s %-8s %-5s port %-5d %s\n", style.Bold, s.Name, style.Reset, s.Mode(), scheme, s.Port, target)
+		}
+		return nil
+	},
+}
+
+var shareDeleteCmd = &cobra.Command{
+	Use:   "delete <name>",
+	Short: "Delete a share, its URL stops working",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(command *cobra.Command, args []string) error {
+		if err := share.Delete(share.Dir(app.Name), args[0]); err != nil {
+			server.ShowError(err)
+			return err
+		}
+		fmt.Println(style.SuccessMessage(fmt.Sprintf("Share %s deleted", args[0])))
+		return nil
+	},
+}
+
+// createShare stores the share name, with the settings of the configuration
+// and of the flags
+func createShare(name string) error {
+	if (app.Flags.ShareReceive == "") == (len(app.Flags.ShareSend) == 0) {
+		return errors.New("a share either receives with --receive or sends with --send")
+	}
+	s, err := share.New(share.Dir(app.Name), name)
+	if err != nil {
+		return err
+	}
+	if _, err := share.Load(share.Dir(app.Name), name); err == nil {
+		return fmt.Errorf("the share %q already exists", name)
+	}
+	cfg := config.New(app)
+	if cfg.Listen != "" || cfg.Relay != "" {
+		return errors.New("shares listen on an interface, --listen and --relay can't be used")
+	}
+	if app.Flags.ShareReceive != "" {
+		if s.Receive, err = filepath.Abs(util.Expand(app.Flags.ShareReceive)); err != nil {
+			return err
+		}
+		info, err := os.Stat(s.Receive)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a folder", s.Receive)
+		}
+	}
+	for _, p := range app.Flags.ShareSend {
+		path, err := filepath.Abs(util.Expand(p))
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(path); err != nil {
+			return err
+		}
+		s.Send = append(s.Send, path)
+	}
+	// The token is always random, as the URL is meant to last
+	if s.Path, err = util.GetRandomURLPath(cfg.TokenBits, cfg.TokenAlphabet); err != nil {
+		return err
+	}
+	s.Interface = cfg.Interface
+	s.Bind = cfg.Bind
+	s.FQDN = cfg.FQDN
+	bind := cfg.Bind
+	if bind == "" {
+		if bind, err = util.GetInterfaceAddress(cfg.Interface, cfg.IPVersion); err != nil {
+			return err
+		}
+	}
+	s.Port = cfg.Port
+	if s.Port == 0 {
+		if s.Port, err = share.FreePort(bind); err != nil {
+			return err
+		}
+	}
+	s.Secure = cfg.Secure
+	s.Allow = cfg.Allow
+	s.Deny = cfg.Deny
+	s.LanOnly = cfg.LanOnly
+	s.RateLimit = cfg.RateLimit
+	s.Confirm = cfg.Confirm
+	s.AuditLog = cfg.AuditLog
+	s.EncryptTo = cfg.EncryptTo
+	if cfg.Scan != "" {
+		if cfg.Quarantine == "" {
+			return errors.New("--scan needs a --quarantine folder for the infected files")
+		}
+		s.Scan = cfg.Scan
+		if s.Quarantine, err = filepath.Abs(util.Expand(cfg.Quarantine)); err != nil {
+			return err
+		}
+	}
+	if err := s.Create(); err != nil {
+		return err
+	}
+	if err := saveShare(s, cfg, bind); err != nil {
+		os.RemoveAll(s.Dir())
+		return err
+	}
+	fmt.Println(style.SuccessMessage(fmt.Sprintf("Share %s created, start it with `mocp share start %s`", s.Name, s.Name)))
+	return nil
+}
+
+// saveShare writes the TLS material and the settings of the new share s
+func saveShare(s *share.Share, cfg config.Config, bind string) error {
+	if s.Secure {
+		if cfg.TlsCert != "" && cfg.TlsKey != "" {
+			if err := s.CopyCertificate(util.Expand(cfg.TlsCert), util.Expand(cfg.TlsKey)); err != nil {
+				return err
+			}
+		} else {
+			hosts := []string{cfg.FQDN, bind}
+			if cfg.Interface != "any" && cfg.Bind == "" {
+				addrs, _ := util.GetInterfaceAddresses(cfg.Interface, cfg.IPVersion)
+				hosts = append(hosts, addrs...)
+			}
+			if err := s.GenerateCertificate(hosts); err != nil {
+				return err
+			}
+		}
+	}
+	return s.Save()
+}
@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/claudiodangelis/qrcp/qr"
+	"github.com/claudiodangelis/qrcp/server"
+	"github.com/claudiodangelis/qrcp/style"
+	"github.com/eiannone/keyboard"
+)
+
+// tokenKeys mints and revokes capability tokens from the keyboard while the
+// server runs
+type tokenKeys struct {
+	srv      *server.Server
+	reversed bool
+	// revoking is set while the number of the token to revoke is typed
+	revoking bool
+	number   string
+}
+
+// tokenPresets are the tokens minted by a single key
+var tokenPresets = map[rune]string{
+	'r': "read-only",
+	'u': "upload-only",
+	'w': "read-write",
+}
+
+// mintTokens mints the tokens of the configuration and renders their QR
+// codes
+func mintTokens(srv *server.Server, specs []string, reversed bool) error {
+	for _, s := range specs {
+		spec, err := server.ParseTokenSpec(s)
+		if err != nil {
+			return err
+		}
+		token, err := srv.MintToken(spec)
+		if err != nil {
+			return err
+		}
+		renderToken(token, reversed)
+	}
+	return nil
+}
+
+// renderToken prints the QR code of a token, with its rights on the side
+func renderToken(token server.Token, reversed bool) {
+	qr.RenderStringWithSide(token.URL, reversed, []string{
+		fmt.Sprintf("%sLink %d%s", style.Bold, token.ID, style.Reset),
+		token.Describe(),
+		"",
+		token.URL,
+	})
+}
+
+// showTokenHelp prints the keys handled by tokenKeys
+func showTokenHelp() {
+	fmt.Printf("%sr/u/w: new read-only/upload-only/read-write link, x: revoke a link%s\n", style.BrightBlack, style.Reset)
+}
+
+// Handle processes a key, and reports whether it was a token action
+func (k *tokenKeys) Handle(char rune, key keyboard.Key) bool {
+	if k.revoking {
+		switch {
+		case char >= '0' && char <= '9':
+			k.number += string(char)
+			fmt.Print(string(char))
+		case key == keyboard.KeyEnter:
+			k.revoking = false
+			fmt.Println()
+			id, _ := strconv.Atoi(k.number)
+			if err := k.srv.RevokeToken(id); err != nil {
+				server.ShowError(err)
+			} else {
+				fmt.Println(style.SuccessMessage(fmt.Sprintf("Link %d revoked", id)))
+			}
+		case key == keyboard.KeyEsc:
+			k.revoking = false
+			fmt.Println()
+		}
+		return true
+	}
+	if char == 'x' {
+		if len(k.srv.Tokens()) == 0 {
+			server.ShowWarning("There are no links to revoke")
+			return true
+		}
+		for _, t := range k.srv.Tokens() {
+			fmt.Printf("  %d: %s\n", t.ID, t.Describe())
+		}
+		fmt.Print("Number of the link to revoke, then Enter: ")
+		k.revoking = true
+		k.number = ""
+		return true
+	}
+	if preset, ok := tokenPresets[char]; ok {
+		spec, _ := server.ParseTokenSpec(preset)
+		token, err := k.srv.MintToken(spec)
+		if err != nil {
+			server.ShowError(err)
+			return true
+		}
+		renderToken(token, k.reversed)
+		return true
+	}
+	return false
+}
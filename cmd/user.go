@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/claudiodangelis/qrcp/server"
+	"github.com/claudiodangelis/qrcp/style"
+	"github.com/claudiodangelis/qrcp/users"
+	"github.com/claudiodangelis/qrcp/util"
+	"github.com/manifoldco/promptui"
+	"github.com/spf13/cobra"
+)
+
+// promptNewPassword asks for a new password twice. When the standard input
+// is not a terminal, the password is read from its first line instead, for
+// scripts
+func promptNewPassword() (string, error) {
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice == 0 {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", errors.New("no password on the standard input")
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+	prompt := promptui.Prompt{
+		Label: "Password",
+		Mask:  '*',
+		Validate: func(input string) error {
+			if len(input) < users.MinPasswordLength {
+				return fmt.Errorf("at least %d characters", users.MinPasswordLength)
+			}
+			return nil
+		},
+	}
+	password, err := prompt.Run()
+	if err != nil {
+		return "", err
+	}
+	confirm := promptui.Prompt{
+		Label: "Confirm the password",
+		Mask:  '*',
+		Validate: func(input string) error {
+			if input != password {
+				return errors.New("the passwords don't match")
+			}
+			return nil
+		},
+	}
+	if _, err = confirm.Run(); err != nil {
+		return "", err
+	}
+	return password, nil
+}
+
+// editUsers loads the users file, applies edit and prints message
+func editUsers(edit func(store *users.Store) error, message string) error {
+	store, err := users.Load(util.Expand(app.Flags.Users))
+	if err == nil {
+		err = edit(store)
+	}
+	if err != nil {
+		server.ShowError(err)
+		return err
+	}
+	fmt.Println(style.SuccessMessage(message))
+	return nil
+}
+
+var userCmd = &cobra.Command{
+	Use:   "user",
+	Short: "Manage the accounts of mocp server",
+	Long:  "Manage the accounts of the users file of mocp server. Changes apply to a running server right away, and changing a password logs the user out.",
+}
+
+var userAddCmd = &cobra.Command{
+	Use:   "add <name>",
+	Short: "Add a user",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(command *cobra.Command, args []string) error {
+		return editUsers(func(store *users.Store) error {
+			password, err := promptNewPassword()
+			if err != nil {
+				return err
+			}
+			return store.Add(args[0], password)
+		}, fmt.Sprintf("User %s added", args[0]))
+	},
+}
+
+var userDelCmd = &cobra.Command{
+	Use:     "del <name>",
+	Short:   "Delete a user, their files are kept",
+	Aliases: []string{"delete"},
+	Args:    cobra.ExactArgs(1),
+	RunE: func(command *cobra.Command, args []string) error {
+		return editUsers(func(store *users.Store) error {
+			return store.Delete(args[0])
+		}, fmt.Sprintf("User %s deleted", args[0]))
+	},
+}
+
+var userPasswdCmd = &cobra.Command{
+	Use:   "passwd <name>",
+	Short: "Change the password of a user",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(command *cobra.Command, args []string) error {
+		return editUsers(func(store *users.Store) error {
+			if !store.Exists(args[0]) {
+				return users.ErrUnknownUser
+			}
+			password, err := promptNewPassword()
+			if err != nil {
+				return err
+			}
+			return store.SetPassword(args[0], password)
+		}, fmt.Sprintf("Password of %s changed", args[0]))
+	},
+}
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"os"
+
+	"github.com/claudiodangelis/qrcp/identity"
+	"github.com/claudiodangelis/qrcp/server"
+	"github.com/claudiodangelis/qrcp/style"
+	"github.com/spf13/cobra"
+)
+
+// readPublicKey parses a public key, given either as text or as the path of
+// a file holding it
+func readPublicKey(s string) (ed25519.PublicKey, error) {
+	if b, err := os.ReadFile(s); err == nil {
+		s = string(b)
+	}
+	return identity.ParsePublicKey(s)
+}
+
+func verifyCmdFunc(command *cobra.Command, args []string) error {
+	path := args[0]
+	sigPath := app.Flags.Signature
+	if sigPath == "" {
+		sigPath = path + identity.SignatureExtension
+	}
+	f, err := os.Open(sigPath)
+	if err != nil {
+		server.ShowError(err)
+		return err
+	}
+	defer f.Close()
+	signature, err := identity.ReadSignature(f)
+	if err != nil {
+		server.ShowError(err)
+		return err
+	}
+	key, manifest, err := signature.Verify()
+	if err != nil {
+		server.ShowError(err)
+		return err
+	}
+	if app.Flags.Key != "" {
+		expected, err := readPublicKey(app.Flags.Key)
+		if err != nil {
+			server.ShowError(err)
+			return err
+		}
+		if !key.Equal(expected) {
+			err := fmt.Errorf("the file was signed by %s, not by the expected key", identity.Fingerprint(key))
+			server.ShowError(err)
+			return err
+		}
+	}
+	file, err := manifest.Find(path)
+	if err != nil {
+		server.ShowError(err)
+		return err
+	}
+	fmt.Println(style.SuccessMessage(fmt.Sprintf("%s is intact, signed as %s on %s by %s",
+		path, file.Name, manifest.Created.Local().Format("2006-01-02 15:04"), identity.Fingerprint(key))))
+	if app.Flags.Key == "" {
+		server.ShowWarning("Make sure the fingerprint is the one of the sender, or check against their public key with --key")
+	}
+	return nil
+}
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify <file>",
+	Short: "Verify the signature of a received file",
+	Long:  "Verify that a file received from a mocp share is intact and was signed by the sender, with the signature served alongside it. Without --key, compare the fingerprint printed with the one of the sender.",
+	Example: `# Verify a file with its signature
+mocp verify report.pdf --sig report.pdf.sig
+# Verify that the file was signed by a known key
+mocp verify report.pdf --sig report.pdf.sig --key 'mocp-ed25519 <key>'
+`,
+	Args: cobra.ExactArgs(1),
+	RunE: verifyCmdFunc,
+}
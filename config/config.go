@@ -3,10 +3,12 @@ package config
 import (
 	"errors"
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/adrg/xdg"
 	"github.com/asaskevich/govalidator"
@@ -18,17 +20,53 @@ import (
 )
 
 type Config struct {
-	Interface string `yaml:",omitempty"`
-	Port      int    `yaml:",omitempty"`
-	Bind      string `yaml:",omitempty"`
-	KeepAlive bool   `yaml:",omitempty"`
-	Path      string `yaml:",omitempty"`
-	Secure    bool   `yaml:",omitempty"`
-	TlsKey    string `yaml:",omitempty"`
-	TlsCert   string `yaml:",omitempty"`
-	FQDN      string `yaml:",omitempty"`
-	Output    string `yaml:",omitempty"`
-	Reversed  bool   `yaml:",omitempty"`
+	Interface          string        `yaml:",omitempty"`
+	Port               int           `yaml:",omitempty"`
+	Bind               string        `yaml:",omitempty"`
+	KeepAlive          bool          `yaml:",omitempty"`
+	Path               string        `yaml:",omitempty"`
+	Secure             bool          `yaml:",omitempty"`
+	TlsKey             string        `yaml:",omitempty"`
+	TlsCert            string        `yaml:",omitempty"`
+	FQDN               string        `yaml:",omitempty"`
+	Output             string        `yaml:",omitempty"`
+	Reversed           bool          `yaml:",omitempty"`
+	TokenBits          int           `yaml:",omitempty"`
+	TokenAlphabet      string        `yaml:",omitempty"`
+	RateLimit          int           `yaml:",omitempty"`
+	Allow              []string      `yaml:",omitempty"`
+	Deny               []string      `yaml:",omitempty"`
+	LanOnly            bool          `yaml:",omitempty"`
+	ReadHeaderTimeout  time.Duration `yaml:",omitempty"`
+	ReadTimeout        time.Duration `yaml:",omitempty"`
+	IdleTimeout        time.Duration `yaml:",omitempty"`
+	MaxHeaderBytes     int           `yaml:",omitempty"`
+	MaxConns           int           `yaml:",omitempty"`
+	MaxConnsPerIP      int           `yaml:",omitempty"`
+	UploadStallTimeout time.Duration `yaml:",omitempty"`
+	AuditLog           string        `yaml:",omitempty"`
+	Confirm            bool          `yaml:",omitempty"`
+	IPVersion          int           `yaml:",omitempty"`
+	DualStack          bool          `yaml:",omitempty"`
+	MDNS               bool          `yaml:",omitempty"`
+	MDNSURL            bool          `yaml:",omitempty"`
+	LocalHostname      bool          `yaml:",omitempty"`
+	Resolvers          []string      `yaml:",omitempty"`
+	ResolveTimeout     time.Duration `yaml:",omitempty"`
+	BasePath           string        `yaml:",omitempty"`
+	PublicURL          string        `yaml:",omitempty"`
+	TrustedProxies     []string      `yaml:",omitempty"`
+	ProxyProtocol      bool          `yaml:",omitempty"`
+	Listen             string        `yaml:",omitempty"`
+	Relay              string        `yaml:",omitempty"`
+	E2E                bool          `yaml:",omitempty"`
+	EncryptTo          string        `yaml:",omitempty"`
+	Tokens             []string      `yaml:",omitempty"`
+	RotateQR           time.Duration `yaml:",omitempty"`
+	Scan               string        `yaml:",omitempty"`
+	Quarantine         string        `yaml:",omitempty"`
+	// Accounts is set by the drop server, which has no transfer of its own
+	Accounts bool `yaml:"-"`
 }
 
 var interactive bool = false
@@ -65,6 +103,40 @@ func New(app application.App) Config {
 	cfg.FQDN = v.GetString("fqdn")
 	cfg.Output = v.GetString("output")
 	cfg.Reversed = v.GetBool("reversed")
+	cfg.TokenBits = v.GetInt("token-bits")
+	cfg.TokenAlphabet = v.GetString("token-alphabet")
+	cfg.RateLimit = v.GetInt("rate-limit")
+	cfg.Allow = v.GetStringSlice("allow")
+	cfg.Deny = v.GetStringSlice("deny")
+	cfg.LanOnly = v.GetBool("lan-only")
+	cfg.ReadHeaderTimeout = v.GetDuration("read-header-timeout")
+	cfg.ReadTimeout = v.GetDuration("read-timeout")
+	cfg.IdleTimeout = v.GetDuration("idle-timeout")
+	cfg.MaxHeaderBytes = v.GetInt("max-header-bytes")
+	cfg.MaxConns = v.GetInt("max-conns")
+	cfg.MaxConnsPerIP = v.GetInt("max-conns-per-ip")
+	cfg.UploadStallTimeout = v.GetDuration("upload-stall-timeout")
+	cfg.AuditLog = v.GetString("audit-log")
+	cfg.Confirm = v.GetBool("confirm")
+	cfg.IPVersion = v.GetInt("ip-version")
+	cfg.DualStack = v.GetBool("dual-stack")
+	cfg.MDNS = v.GetBool("mdns")
+	cfg.MDNSURL = v.GetBool("mdns-url")
+	cfg.LocalHostname = v.GetBool("local-hostname")
+	cfg.Resolvers = v.GetStringSlice("resolver")
+	cfg.ResolveTimeout = v.GetDuration("resolve-timeout")
+	cfg.BasePath = v.GetString("base-path")
+	cfg.PublicURL = v.GetString("public-url")
+	cfg.TrustedProxies = v.GetStringSlice("trusted-proxy")
+	cfg.ProxyProtocol = v.GetBool("proxy-protocol")
+	cfg.Listen = v.GetString("listen")
+	cfg.Relay = v.GetString("relay")
+	cfg.E2E = v.GetBool("e2e")
+	cfg.EncryptTo = v.GetString("encrypt-to")
+	cfg.Tokens = v.GetStringSlice("token")
+	cfg.RotateQR = v.GetDuration("rotate-qr")
+	cfg.Scan = v.GetString("scan")
+	cfg.Quarantine = v.GetString("quarantine")
 
 	// Override
 	if app.Flags.Interface != "" {
@@ -100,10 +172,86 @@ func New(app application.App) Config {
 	if app.Flags.Reversed {
 		cfg.Reversed = true
 	}
+	if app.Flags.TokenBits != 0 {
+		cfg.TokenBits = app.Flags.TokenBits
+	}
+	if app.Flags.RateLimit != 0 {
+		cfg.RateLimit = app.Flags.RateLimit
+	}
+	if len(app.Flags.Allow) > 0 {
+		cfg.Allow = app.Flags.Allow
+	}
+	if len(app.Flags.Deny) > 0 {
+		cfg.Deny = app.Flags.Deny
+	}
+	if app.Flags.LanOnly {
+		cfg.LanOnly = true
+	}
+	if app.Flags.AuditLog != "" {
+		cfg.AuditLog = app.Flags.AuditLog
+	}
+	if app.Flags.Confirm {
+		cfg.Confirm = true
+	}
+	if app.Flags.IPVersion != 0 {
+		cfg.IPVersion = app.Flags.IPVersion
+	}
+	if app.Flags.DualStack {
+		cfg.DualStack = true
+	}
+	if app.Flags.MDNS {
+		cfg.MDNS = true
+	}
+	if app.Flags.MDNSURL {
+		cfg.MDNSURL = true
+	}
+	if app.Flags.LocalHostname {
+		cfg.LocalHostname = true
+	}
+	if len(app.Flags.Resolvers) > 0 {
+		cfg.Resolvers = app.Flags.Resolvers
+	}
+	if app.Flags.BasePath != "" {
+		cfg.BasePath = app.Flags.BasePath
+	}
+	if app.Flags.PublicURL != "" {
+		cfg.PublicURL = app.Flags.PublicURL
+	}
+	if len(app.Flags.TrustedProxies) > 0 {
+		cfg.TrustedProxies = app.Flags.TrustedProxies
+	}
+	if app.Flags.ProxyProtocol {
+		cfg.ProxyProtocol = true
+	}
+	if app.Flags.Listen != "" {
+		cfg.Listen = app.Flags.Listen
+	}
+	if app.Flags.Relay != "" {
+		cfg.Relay = app.Flags.Relay
+	}
+	if app.Flags.E2E {
+		cfg.E2E = true
+	}
+	if app.Flags.EncryptTo != "" {
+		cfg.EncryptTo = app.Flags.EncryptTo
+	}
+	if len(app.Flags.Tokens) > 0 {
+		cfg.Tokens = app.Flags.Tokens
+	}
+	if app.Flags.RotateQR != 0 {
+		cfg.RotateQR = app.Flags.RotateQR
+	}
+	if app.Flags.Scan != "" {
+		cfg.Scan = app.Flags.Scan
+	}
+	if app.Flags.Quarantine != "" {
+		cfg.Quarantine = app.Flags.Quarantine
+	}
 
-	// Discover interface if it's not been set yet
+	// Discover interface if it's not been set yet, unless listening on a
+	// socket or through a relay
 	if !interactive {
-		if cfg.Interface == "" {
+		if cfg.Interface == "" && cfg.Listen == "" && cfg.Relay == "" {
 			cfg.Interface, err = ChooseInterface(app.Flags)
 			if err != nil {
 				panic(err)
@@ -162,7 +310,12 @@ func Wizard(app application.App) error {
 		if input == "" {
 			return nil
 		}
-		if !govalidator.IsIPv4(input) {
+		// Accept IPv4 and IPv6 addresses, with an optional zone
+		host := strings.Trim(input, "[]")
+		if i := strings.LastIndex(host, "%"); i >= 0 {
+			host = host[:i]
+		}
+		if net.ParseIP(host) == nil {
 			return errors.New("invalid address")
 		}
 		return nil
@@ -174,7 +327,7 @@ func Wizard(app application.App) error {
 	}
 	if promptBindResultString, err := promptBind.Run(); err == nil {
 		if promptBindResultString != "" {
-			v.Set("bind", promptBindResultString)
+			v.Set("bind", strings.Trim(promptBindResultString, "[]"))
 		}
 	}
 	// Ask for port
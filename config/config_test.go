@@ -13,7 +13,7 @@ import (
 func TestNew(t *testing.T) {
 	os.Clearenv()
 	_, f, _, _ := runtime.Caller(0)
-	foundIface, err := chooseInterface(application.Flags{})
+	foundIface, err := ChooseInterface(application.Flags{})
 	if err != nil {
 		panic(err)
 	}
@@ -41,6 +41,12 @@ func ChooseInterface(flags application.Flags) (string, error) {
 	anyLabel := fmt.Sprintf("%s (%s)", anyName, anyIP)
 	m[anyLabel] = anyName
 	items = append(items, anyLabel)
+	// Add the "all-lan" interface, listening on every interface above
+	if len(interfaces) > 1 {
+		allLANLabel := fmt.Sprintf("%s (every interface above)", util.AllLAN)
+		m[allLANLabel] = util.AllLAN
+		items = append(items, allLANLabel)
+	}
 	// Print a colored list of options first so the user sees them immediately
 	fmt.Println(style.BrightCyan + "Available network interfaces:" + style.Reset)
 	for idx, it := range items {
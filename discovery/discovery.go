@@ -0,0 +1,172 @@
+package discovery
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/mdns"
+)
+
+// Service is the DNS-SD service type used to advertise mocp shares
+const Service = "_mocp._tcp"
+
+// Share is a mocp session advertised on the local network
+type Share struct {
+	// Instance is the name of the advertised service instance
+	Instance string
+	// Mode is either "send", "receive" or "code" for transfers with a code
+	Mode     string
+	Filename string
+	Size     int64
+	// PIN is true when the operator has to approve the device first
+	PIN bool
+	// Nameplate is the public number of the code of a transfer
+	Nameplate string
+	// URL is the address to open to join the share. It holds the secret
+	// token, so it's only advertised when asked for, and never with its
+	// fragment, which may hold the end-to-end key
+	URL  string
+	Host string
+	Port int
+	// IPs are the addresses the share was announced with
+	IPs []net.IP
+}
+
+// txt encodes the share as TXT records
+func (s Share) txt() []string {
+	pin := "0"
+	if s.PIN {
+		pin = "1"
+	}
+	txt := []string{"mode=" + s.Mode, "pin=" + pin}
+	if s.URL != "" {
+		url, _, _ := strings.Cut(s.URL, "#")
+		txt = append(txt, "url="+url)
+	}
+	if s.Filename != "" {
+		txt = append(txt, "filename="+s.Filename, "size="+strconv.FormatInt(s.Size, 10))
+	}
+	if s.Nameplate != "" {
+		txt = append(txt, "nameplate="+s.Nameplate)
+	}
+	return txt
+}
+
+// parseShare decodes a share from a service entry
+func parseShare(entry *mdns.ServiceEntry) Share {
+	share := Share{
+		Instance: strings.TrimSuffix(entry.Name, "."+Service+".local."),
+		Host:     strings.TrimSuffix(entry.Host, "."),
+		Port:     entry.Port,
+	}
+	for _, ip := range []net.IP{entry.AddrV4, entry.AddrV6} {
+		if ip != nil {
+			share.IPs = append(share.IPs, ip)
+		}
+	}
+	for _, field := range entry.InfoFields {
+		key, value, _ := strings.Cut(field, "=")
+		switch key {
+		case "mode":
+			share.Mode = value
+		case "url":
+			share.URL = value
+		case "pin":
+			share.PIN = value == "1"
+		case "filename":
+			share.Filename = value
+		case "size":
+			share.Size, _ = strconv.ParseInt(value, 10, 64)
+		case "nameplate":
+			share.Nameplate = value
+		}
+	}
+	return share
+}
+
+// LocalHostname returns the multicast DNS name of this host, such as
+// "laptop.local"
+func LocalHostname() (string, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+	hostname, _, _ = strings.Cut(hostname, ".")
+	return hostname + ".local", nil
+}
+
+// Advertiser announces a share until it's closed
+type Advertiser struct {
+	server *mdns.Server
+}
+
+// Advertise announces share on the local network. The host name of this
+// machine is announced with the given addresses as well, so that
+// `<hostname>.local` resolves even where no other responder is running
+func Advertise(share Share, ips []net.IP) (*Advertiser, error) {
+	hostname, err := LocalHostname()
+	if err != nil {
+		return nil, err
+	}
+	if share.Instance == "" {
+		share.Instance = fmt.Sprintf("mocp %s on %s", share.Mode, strings.TrimSuffix(hostname, ".local"))
+	}
+	service, err := mdns.NewMDNSService(share.Instance, Service, "", hostname+".", share.Port, ips, share.txt())
+	if err != nil {
+		return nil, err
+	}
+	server, err := mdns.NewServer(&mdns.Config{Zone: service})
+	if err != nil {
+		return nil, err
+	}
+	return &Advertiser{server: server}, nil
+}
+
+// Close stops advertising the share
+func (a *Advertiser) Close() error {
+	if a == nil {
+		return nil
+	}
+	return a.server.Shutdown()
+}
+
+// Browse looks for shares on the local network for the given duration
+func Browse(timeout time.Duration) ([]Share, error) {
+	entries := make(chan *mdns.ServiceEntry, 32)
+	shares := map[string]Share{}
+	done := make(chan struct{})
+	go func() {
+		for entry := range entries {
+			share := parseShare(entry)
+			shares[share.Instance] = share
+		}
+		close(done)
+	}()
+	params := mdns.DefaultParams(Service)
+	params.Entries = entries
+	params.Timeout = timeout
+	// The mdns package logs every closed client, keep the output clean
+	log.SetOutput(io.Discard)
+	err := mdns.Query(params)
+	log.SetOutput(os.Stderr)
+	close(entries)
+	<-done
+	if err != nil {
+		return nil, err
+	}
+	list := make([]Share, 0, len(shares))
+	for _, share := range shares {
+		list = append(list, share)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Instance < list[j].Instance
+	})
+	return list, nil
+}
@@ -0,0 +1,20 @@
+package discovery
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTxt(t *testing.T) {
+	share := Share{Mode: "receive", PIN: true, Port: 8080}
+	for _, field := range share.txt() {
+		if strings.HasPrefix(field, "url=") {
+			t.Errorf("the URL is advertised: %s", field)
+		}
+	}
+	share.URL = "http://192.0.2.1:8080/receive/secret#key=AGE-SECRET-KEY"
+	txt := strings.Join(share.txt(), " ")
+	if !strings.Contains(txt, "url=http://192.0.2.1:8080/receive/secret") || strings.Contains(txt, "key=") {
+		t.Errorf("TXT records %q", txt)
+	}
+}
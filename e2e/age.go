@@ -0,0 +1,84 @@
+package e2e
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"filippo.io/age"
+)
+
+// AgeExtension is added to the name of the files encrypted on receipt
+const AgeExtension = ".age"
+
+// ReadRecipients reads the age public keys, one per line, of the file at
+// path. Files encrypted to them can be decrypted with the age tools too
+func ReadRecipients(path string) ([]age.Recipient, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	recipients, err := age.ParseRecipients(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return recipients, nil
+}
+
+// ReadIdentities reads the age private keys of the file at path, as written
+// by age-keygen
+func ReadIdentities(path string) ([]age.Identity, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	identities, err := age.ParseIdentities(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return identities, nil
+}
+
+// DecryptAgeFile decrypts the age file at path in dir, dropping the .age
+// extension from its name, and returns the path of the decrypted file
+func DecryptAgeFile(path string, identities []age.Identity, dir string) (string, error) {
+	name := filepath.Base(path)
+	if !strings.HasSuffix(name, AgeExtension) || name == AgeExtension {
+		return "", fmt.Errorf("%s is not an age file", path)
+	}
+	target := filepath.Join(dir, strings.TrimSuffix(name, AgeExtension))
+	if _, err := os.Stat(target); err == nil {
+		return "", fmt.Errorf("%s already exists", target)
+	}
+	in, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	r, err := age.Decrypt(in, identities...)
+	if err != nil {
+		var noMatch *age.NoIdentityMatchError
+		if errors.As(err, &noMatch) {
+			return "", errors.New("the file was not encrypted to this identity")
+		}
+		return "", err
+	}
+	out, err := os.OpenFile(target+".part", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(out, r)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return "", err
+	}
+	return target, os.Rename(out.Name(), target)
+}
@@ -0,0 +1,99 @@
+package e2e
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+)
+
+func TestStreamTruncated(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, KeySize)
+	var buf bytes.Buffer
+	w, _ := NewWriter(&buf, key)
+	w.Write(bytes.Repeat([]byte("x"), FrameSize+10))
+	w.Flush()
+	r, _ := NewReader(bytes.NewReader(buf.Bytes()), key)
+	if _, err := io.ReadAll(r); !errors.Is(err, ErrTruncated) {
+		t.Fatalf("err = %v, want %v", err, ErrTruncated)
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	key, _ := NewKey()
+	content := bytes.Repeat([]byte("e2e"), FrameSize)
+	var buf bytes.Buffer
+	if err := Encrypt(&buf, bytes.NewReader(content), key, Metadata{Name: "file.bin", Size: int64(len(content))}); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("file.bin")) {
+		t.Error("the name of the file is not encrypted")
+	}
+	meta, r, err := Decrypt(bytes.NewReader(buf.Bytes()), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Name != "file.bin" || !bytes.Equal(got, content) {
+		t.Errorf("decrypted %q with %d bytes, want file.bin with %d", meta.Name, len(got), len(content))
+	}
+	wrong, _ := NewKey()
+	if _, _, err := Decrypt(bytes.NewReader(buf.Bytes()), wrong); !errors.Is(err, ErrTampered) {
+		t.Errorf("err = %v with the wrong key, want %v", err, ErrTampered)
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	key, _ := NewKey()
+	for _, s := range []string{
+		Fragment(key),
+		Fragment(key)[len("key="):],
+		"https://example.com/send/abc#" + Fragment(key),
+	} {
+		got, err := ParseKey(s)
+		if err != nil || !bytes.Equal(got, key) {
+			t.Errorf("ParseKey(%q) = %x, %v", s, got, err)
+		}
+	}
+	if _, err := ParseKey("short"); err == nil {
+		t.Error("expected an error for an invalid key")
+	}
+}
+
+func TestDecryptAgeFile(t *testing.T) {
+	dir := t.TempDir()
+	identity, _ := age.GenerateX25519Identity()
+	recipientsPath := filepath.Join(dir, "recipients.txt")
+	os.WriteFile(recipientsPath, []byte("# receive\n"+identity.Recipient().String()+"\n"), 0644)
+	recipients, err := ReadRecipients(recipientsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w, _ := age.Encrypt(&buf, recipients...)
+	w.Write([]byte("secret"))
+	w.Close()
+	encrypted := filepath.Join(dir, "notes.txt"+AgeExtension)
+	os.WriteFile(encrypted, buf.Bytes(), 0644)
+	other, _ := age.GenerateX25519Identity()
+	if _, err := DecryptAgeFile(encrypted, []age.Identity{other}, dir); err == nil {
+		t.Fatal("decrypted with the wrong identity")
+	}
+	decrypted, err := DecryptAgeFile(encrypted, []age.Identity{identity}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(decrypted); filepath.Base(decrypted) != "notes.txt" || string(b) != "secret" {
+		t.Fatalf("decrypted %s to %q", decrypted, b)
+	}
+	if _, err := DecryptAgeFile(encrypted, []age.Identity{identity}, dir); err == nil {
+		t.Fatal("overwrote the decrypted file")
+	}
+}
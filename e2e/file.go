@@ -0,0 +1,171 @@
+package e2e
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// KeySize is the size of the key of a session
+const KeySize = 32
+
+// Extension is added to the name of encrypted files
+const Extension = ".mocp"
+
+// magic starts every encrypted file
+var magic = []byte("MOCPE2E1")
+
+// saltSize is the size of the random salt deriving the key of each file
+const saltSize = 16
+
+// Metadata is the first frame of an encrypted file, so that the name of the
+// file is not disclosed either
+type Metadata struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// NewKey returns a random session key
+func NewKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// EncodeKey returns the text form of key
+func EncodeKey(key []byte) string {
+	return base64.RawURLEncoding.EncodeToString(key)
+}
+
+// Fragment returns the URL fragment carrying key. Browsers never send the
+// fragment to the server
+func Fragment(key []byte) string {
+	return "key=" + EncodeKey(key)
+}
+
+// ParseKey decodes a key, either as printed by mocp or as the URL it was
+// shared with
+func ParseKey(s string) ([]byte, error) {
+	if _, fragment, found := strings.Cut(s, "#"); found {
+		values, err := url.ParseQuery(fragment)
+		if err != nil {
+			return nil, err
+		}
+		s = values.Get("key")
+	}
+	key, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(s, "key="))
+	if err != nil || len(key) != KeySize {
+		return nil, errors.New("invalid key")
+	}
+	return key, nil
+}
+
+// fileKey derives the key of a file from the session key and its salt, so
+// that the frames of two files never share a nonce
+func fileKey(key []byte, salt []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("mocp e2e file"))
+	mac.Write(salt)
+	return mac.Sum(nil)
+}
+
+// Encrypt writes the content of r to w, encrypted with the session key
+func Encrypt(w io.Writer, r io.Reader, key []byte, meta Metadata) error {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	if _, err := w.Write(append(append([]byte{}, magic...), salt...)); err != nil {
+		return err
+	}
+	stream, err := NewWriter(w, fileKey(key, salt))
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	if _, err := stream.Write(b); err != nil {
+		return err
+	}
+	if err := stream.Flush(); err != nil {
+		return err
+	}
+	if _, err := io.Copy(stream, r); err != nil {
+		return err
+	}
+	return stream.Close()
+}
+
+// Decrypt reads the metadata of the file encrypted in r, and returns a
+// reader of its content
+func Decrypt(r io.Reader, key []byte) (Metadata, io.Reader, error) {
+	meta := Metadata{}
+	header := make([]byte, len(magic)+saltSize)
+	if _, err := io.ReadFull(r, header); err != nil || !bytes.Equal(header[:len(magic)], magic) {
+		return meta, nil, errors.New("not a file encrypted by mocp")
+	}
+	stream, err := NewReader(r, fileKey(key, header[len(magic):]))
+	if err != nil {
+		return meta, nil, err
+	}
+	frame, err := stream.Next()
+	if err != nil {
+		return meta, nil, err
+	}
+	if err := json.Unmarshal(frame, &meta); err != nil {
+		return meta, nil, err
+	}
+	return meta, stream, nil
+}
+
+// DecryptFile decrypts the file at path into dir, under its original name,
+// and returns the path of the decrypted file
+func DecryptFile(path string, key []byte, dir string) (string, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	meta, r, err := Decrypt(in, key)
+	if err != nil {
+		return "", err
+	}
+	name := filepath.Base(filepath.Clean("/" + filepath.FromSlash(meta.Name)))
+	if name == string(filepath.Separator) || name == "." {
+		return "", errors.New("invalid file name")
+	}
+	target := filepath.Join(dir, name)
+	if _, err := os.Stat(target); err == nil {
+		return "", fmt.Errorf("%s already exists", target)
+	}
+	out, err := os.OpenFile(target+".part", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return "", err
+	}
+	n, err := io.Copy(out, r)
+	if err == nil && n != meta.Size {
+		err = ErrTruncated
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return "", err
+	}
+	return target, os.Rename(out.Name(), target)
+}
@@ -0,0 +1,168 @@
+// Package e2e encrypts transfers end to end. Streams are split in
+// AES-256-GCM frames, in a format simple enough to be decrypted by the
+// browser with WebCrypto
+package e2e
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// FrameSize is the largest plaintext of a frame
+const FrameSize = 64 * 1024
+
+// Frames carry a flag as additional data, so that a stream cut after any
+// frame but the final one is detected
+var (
+	frameMore  = []byte{0}
+	frameFinal = []byte{1}
+)
+
+// ErrTruncated is returned when a stream ends before its final frame
+var ErrTruncated = errors.New("the transfer was interrupted")
+
+// ErrTampered is returned when a frame fails to decrypt
+var ErrTampered = errors.New("the transfer was tampered with, or the key is wrong")
+
+// newAEAD returns AES-256-GCM with key
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// nonce returns the nonce of the n-th frame
+func nonce(n uint64) []byte {
+	b := make([]byte, 12)
+	binary.BigEndian.PutUint64(b[4:], n)
+	return b
+}
+
+// Writer encrypts what is written to it as length-prefixed frames. Each key
+// must encrypt a single stream
+type Writer struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	frames uint64
+	buf    []byte
+}
+
+// NewWriter returns a Writer encrypting to w with key
+func NewWriter(w io.Writer, key []byte) (*Writer, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, aead: aead, buf: make([]byte, 0, FrameSize)}, nil
+}
+
+// seal writes plaintext as a frame
+func (s *Writer) seal(plaintext []byte, flag []byte) error {
+	ciphertext := s.aead.Seal(nil, nonce(s.frames), plaintext, flag)
+	s.frames++
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length, uint32(len(ciphertext)))
+	if _, err := s.w.Write(length); err != nil {
+		return err
+	}
+	_, err := s.w.Write(ciphertext)
+	return err
+}
+
+func (s *Writer) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		n := copy(s.buf[len(s.buf):cap(s.buf)], b)
+		s.buf = s.buf[:len(s.buf)+n]
+		b = b[n:]
+		written += n
+		if len(s.buf) == cap(s.buf) {
+			if err := s.Flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Flush writes what is buffered as a frame
+func (s *Writer) Flush() error {
+	if len(s.buf) == 0 {
+		return nil
+	}
+	err := s.seal(s.buf, frameMore)
+	s.buf = s.buf[:0]
+	return err
+}
+
+// Close writes the final frame
+func (s *Writer) Close() error {
+	err := s.seal(s.buf, frameFinal)
+	s.buf = s.buf[:0]
+	return err
+}
+
+// Reader decrypts the frames of a Writer
+type Reader struct {
+	r      io.Reader
+	aead   cipher.AEAD
+	frames uint64
+	buf    []byte
+	final  bool
+}
+
+// NewReader returns a Reader decrypting r with key
+func NewReader(r io.Reader, key []byte) (*Reader, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{r: r, aead: aead}, nil
+}
+
+// Next reads and decrypts the next frame
+func (s *Reader) Next() ([]byte, error) {
+	if s.final {
+		return nil, io.EOF
+	}
+	length := make([]byte, 4)
+	if _, err := io.ReadFull(s.r, length); err != nil {
+		return nil, ErrTruncated
+	}
+	size := binary.BigEndian.Uint32(length)
+	if size > FrameSize+uint32(s.aead.Overhead()) {
+		return nil, errors.New("invalid frame")
+	}
+	ciphertext := make([]byte, size)
+	if _, err := io.ReadFull(s.r, ciphertext); err != nil {
+		return nil, ErrTruncated
+	}
+	plaintext, err := s.aead.Open(nil, nonce(s.frames), ciphertext, frameMore)
+	if err != nil {
+		plaintext, err = s.aead.Open(nil, nonce(s.frames), ciphertext, frameFinal)
+		if err != nil {
+			return nil, ErrTampered
+		}
+		s.final = true
+	}
+	s.frames++
+	return plaintext, nil
+}
+
+func (s *Reader) Read(b []byte) (int, error) {
+	for len(s.buf) == 0 {
+		frame, err := s.Next()
+		if err != nil {
+			return 0, err
+		}
+		s.buf = frame
+	}
+	n := copy(b, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
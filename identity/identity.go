@@ -0,0 +1,117 @@
+package identity
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/adrg/xdg"
+)
+
+// fileName is the name of the identity in the configuration folder
+const fileName = "identity.pem"
+
+// publicKeyPrefix starts the text form of a public key
+const publicKeyPrefix = "mocp-ed25519 "
+
+// Identity is the ed25519 key signing the files sent by a user
+type Identity struct {
+	key ed25519.PrivateKey
+}
+
+// Path returns where the identity of the application name is stored, in
+// its folder below the XDG configuration folder
+func Path(name string) string {
+	return filepath.Join(xdg.ConfigHome, name, fileName)
+}
+
+// Generate returns a new random identity
+func Generate() (*Identity, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{key: key}, nil
+}
+
+// Load reads the identity at path. The error wraps os.ErrNotExist when
+// there is no identity yet
+func Load(path string) (*Identity, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("%s: not a PEM private key", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	ed, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an ed25519 key", path)
+	}
+	return &Identity{key: ed}, nil
+}
+
+// Save writes the identity to path, readable by the user only. An existing
+// identity is never overwritten
+func (id *Identity) Save(path string) error {
+	der, err := x509.MarshalPKCS8PrivateKey(id.key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("%s already exists, remove it first to create a new identity", path)
+		}
+		return err
+	}
+	err = pem.Encode(f, &pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// PublicKey returns the public key of the identity
+func (id *Identity) PublicKey() ed25519.PublicKey {
+	return id.key.Public().(ed25519.PublicKey)
+}
+
+// EncodePublicKey returns the text form of a public key, to be shared with
+// the recipients
+func EncodePublicKey(key ed25519.PublicKey) string {
+	return publicKeyPrefix + base64.StdEncoding.EncodeToString(key)
+}
+
+// ParsePublicKey decodes the text form of a public key
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	s = strings.TrimSpace(s)
+	b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(s, publicKeyPrefix))
+	if err != nil || len(b) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid public key")
+	}
+	return ed25519.PublicKey(b), nil
+}
+
+// Fingerprint returns a short form of a public key for comparing keys by
+// eye, like the fingerprints of SSH keys
+func Fingerprint(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,81 @@
+package identity
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mocp", fileName)
+	if _, err := Load(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("err = %v, want %v", err, os.ErrNotExist)
+	}
+	id, _ := Generate()
+	if err := id.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := id.Save(path); err == nil {
+		t.Fatal("overwrote the identity")
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.PublicKey().Equal(id.PublicKey()) {
+		t.Fatal("loaded a different identity")
+	}
+	key, err := ParsePublicKey(EncodePublicKey(id.PublicKey()) + "\n")
+	if err != nil || !key.Equal(id.PublicKey()) {
+		t.Fatalf("ParsePublicKey() = %v, %v", key, err)
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "report.pdf")
+	os.WriteFile(path, []byte("report"), 0644)
+	file, err := HashFile(path, "report.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := Generate()
+	signature, err := id.Sign(Manifest{Created: time.Now(), Files: []File{file}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(signature)
+	signature, err = ReadSignature(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, manifest, err := signature.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !key.Equal(id.PublicKey()) {
+		t.Fatal("verified with a different key")
+	}
+	// Renamed files are found by their content
+	renamed := filepath.Join(dir, "renamed.pdf")
+	os.Rename(path, renamed)
+	if found, err := manifest.Find(renamed); err != nil || found.Name != "report.pdf" {
+		t.Fatalf("Find() = %v, %v", found, err)
+	}
+	os.WriteFile(renamed, []byte("tampered"), 0644)
+	if _, err := manifest.Find(renamed); err == nil {
+		t.Fatal("found a modified file")
+	}
+	// A manifest changed after signing is rejected
+	signature.Manifest = bytes.Replace(signature.Manifest, []byte(file.SHA256), bytes.Repeat([]byte("0"), len(file.SHA256)), 1)
+	if _, _, err := signature.Verify(); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("err = %v, want %v", err, ErrBadSignature)
+	}
+}
@@ -0,0 +1,123 @@
+package identity
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// SignatureExtension is added to the name of a file to name its signature
+const SignatureExtension = ".sig"
+
+// signingContext separates the signatures of manifests from other uses
+// of the key
+const signingContext = "mocp manifest v1\x00"
+
+// ErrBadSignature is returned when a signature doesn't match its manifest
+var ErrBadSignature = errors.New("the signature doesn't match, the manifest was tampered with")
+
+// File is an entry of a manifest
+type File struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Manifest lists the files of a transfer
+type Manifest struct {
+	Created time.Time `json:"created"`
+	Files   []File    `json:"files"`
+}
+
+// Signature is a signed manifest, along with the public key that signed it.
+// The manifest is kept as signed, so that it is verified byte for byte
+type Signature struct {
+	Manifest  json.RawMessage `json:"manifest"`
+	PublicKey string          `json:"public_key"`
+	Signature string          `json:"signature"`
+}
+
+// HashFile returns the manifest entry of the file at path, under name
+func HashFile(path string, name string) (File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return File{}, err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return File{}, err
+	}
+	return File{Name: name, Size: size, SHA256: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+// Sign signs the manifest
+func (id *Identity) Sign(m Manifest) (*Signature, error) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return &Signature{
+		Manifest:  b,
+		PublicKey: EncodePublicKey(id.PublicKey()),
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(id.key, append([]byte(signingContext), b...))),
+	}, nil
+}
+
+// ReadSignature parses a signature, as served alongside a file
+func ReadSignature(r io.Reader) (*Signature, error) {
+	s := &Signature{}
+	if err := json.NewDecoder(io.LimitReader(r, 1<<20)).Decode(s); err != nil {
+		return nil, fmt.Errorf("invalid signature: %w", err)
+	}
+	return s, nil
+}
+
+// Verify checks the signature with its public key, and returns the key and
+// the manifest. Whether the key belongs to the expected sender is up to the
+// caller
+func (s *Signature) Verify() (ed25519.PublicKey, *Manifest, error) {
+	key, err := ParsePublicKey(s.PublicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	// The manifest is signed in its compact form, the signature file may
+	// be indented
+	var manifest bytes.Buffer
+	if err := json.Compact(&manifest, s.Manifest); err != nil {
+		return nil, nil, err
+	}
+	sig, err := base64.StdEncoding.DecodeString(s.Signature)
+	if err != nil || !ed25519.Verify(key, append([]byte(signingContext), manifest.Bytes()...), sig) {
+		return nil, nil, ErrBadSignature
+	}
+	m := &Manifest{}
+	if err := json.Unmarshal(manifest.Bytes(), m); err != nil {
+		return nil, nil, err
+	}
+	return key, m, nil
+}
+
+// Find returns the entry of the manifest matching the file at path. Files
+// are matched by content, as they may have been renamed
+func (m *Manifest) Find(path string) (*File, error) {
+	file, err := HashFile(path, "")
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range m.Files {
+		if f.SHA256 == file.SHA256 && f.Size == file.Size {
+			return &f, nil
+		}
+	}
+	return nil, errors.New("the file doesn't match any file of the manifest, it was modified or is not part of the transfer")
+}
@@ -208,6 +208,14 @@ var Upload = `
             </form>
         </div>
     </div>
+    {{if .E2E}}
+    <script>` + e2eScript + `
+        if (!mocpE2E.available) {
+            document.getElementById('submit').disabled = true
+            document.getElementById('submit').value = 'End-to-end encryption needs a secure connection (HTTPS)'
+        }
+    </script>
+    {{end}}
     <script>
         var textCheckbox = document.getElementById('check-send-text')
         var textForm = document.getElementById('send-text-form')
@@ -306,7 +314,20 @@ var Upload = `
             }
 
             xhr.open("POST", "{{.Route}}")
+            {{if .E2E}}
+            // Encrypt the files on this device, the server only stores what
+            // it can't read
+            var submitButton = document.getElementById('submit')
+            submitButton.value = 'Encrypting files, please wait.'
+            mocpE2E.encryptForm(formData).then(function (encrypted) {
+                submitButton.value = 'Transferring file, please wait.'
+                xhr.send(encrypted)
+            }, function (err) {
+                submitButton.value = 'Unable to encrypt the files: ' + err.message
+            })
+            {{else}}
             xhr.send(formData)
+            {{end}}
         })
     </script>
 </body>
@@ -338,13 +359,621 @@ var Done = `
 
 <body>
     <div class="container">
+        {{if .File}}
         <div class="alert alert-success" role="alert">
             <h4 class="alert-heading">Done!</h4>
             <p>
                 Successfully transferred to:<br/> <b>{{.File}}</b>.<br/> You can close this page now.
             </p>
+            {{if and .Scanned (not .Quarantined)}}
+            <p>All files were scanned, no threats found.</p>
+            {{end}}
+        </div>
+        {{end}}
+        {{if .Quarantined}}
+        <div class="alert alert-danger" role="alert">
+            <h4 class="alert-heading">Files refused</h4>
+            <p>These files were not accepted, they have been quarantined:</p>
+            <ul>
+                {{range .Quarantined}}
+                <li><b>{{.Name}}</b>: {{.Verdict}}</li>
+                {{end}}
+            </ul>
         </div>
+        {{end}}
     </div>
 </body>
 </html>
 `
+
+// Waiting page, shown while the operator decides whether to accept a device
+var Waiting = `
+<!doctype html>
+<html lang="en">
+
+<head>
+    <meta charset="utf-8">
+    <meta http-equiv="refresh" content="2">
+    <meta name="viewport" content="width=device-width, user-scalable=no">
+    <title>qrcp</title>
+    <style>
+        body {
+            margin: 10px;
+            font-family: sans-serif;
+        }
+    </style>
+</head>
+
+<body>
+    <h4>Waiting for approval</h4>
+    <p>The sender has to accept this device before the transfer can start. This page will reload automatically.</p>
+</body>
+</html>
+`
+
+// Rejected page, shown when the operator refuses a device
+var Rejected = `
+<!doctype html>
+<html lang="en">
+
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, user-scalable=no">
+    <title>qrcp</title>
+    <style>
+        body {
+            margin: 10px;
+            font-family: sans-serif;
+        }
+    </style>
+</head>
+
+<body>
+    <h4>Connection refused</h4>
+    <p>The connection from this device was not accepted. You can close this page now.</p>
+</body>
+</html>
+`
+
+// Token page, shows what a capability token allows: the files to list,
+// download or delete, and a link to upload more
+var Token = `
+<!doctype html>
+<html lang="en">
+
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, user-scalable=no">
+    <title>qrcp</title>
+    <style>
+        body {
+            margin: 10px;
+            font-family: sans-serif;
+        }
+        a.button {
+            display: inline-block;
+            padding: 10px 16px;
+            color: #fff;
+            background: #337ab7;
+            border-radius: 4px;
+            text-decoration: none;
+        }
+        table {
+            border-collapse: collapse;
+        }
+        td {
+            padding: 4px 8px;
+        }
+    </style>
+</head>
+
+<body>
+    <h4>Shared folder</h4>
+    {{if .Limits}}<p>{{.Limits}}</p>{{end}}
+    {{if .Upload}}<p><a class="button" href="{{.Route}}upload">Upload files</a></p>{{end}}
+    {{if .Files}}
+    <table>
+        {{range .Files}}
+        <tr>
+            <td>{{if $.Download}}<a href="{{.Route}}">{{.Name}}</a>{{else}}{{.Name}}{{end}}</td>
+            <td>{{.Size}}</td>
+            {{if $.Delete}}
+            <td>
+                <form method="post" action="{{$.Route}}delete" onsubmit="return confirm('Delete ' + this.elements['name'].value + '?')">
+                    <input type="hidden" name="name" value="{{.Name}}">
+                    <button type="submit">Delete</button>
+                </form>
+            </td>
+            {{end}}
+        </tr>
+        {{end}}
+    </table>
+    {{else if .List}}
+    <p>The folder is empty.</p>
+    {{else if .Download}}
+    <p>This link downloads files by name, ask the sender for the link of each file.</p>
+    {{end}}
+</body>
+</html>
+`
+
+// Login page of the accounts of a drop server
+var Login = `
+<!doctype html>
+<html lang="en">
+
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, user-scalable=no">
+    <title>qrcp</title>
+    <style>
+        body {
+            margin: 10px;
+            font-family: sans-serif;
+        }
+        input {
+            display: block;
+            margin-bottom: 10px;
+            padding: 6px;
+        }
+    </style>
+</head>
+
+<body>
+    <h4>Log in</h4>
+    {{if .Error}}<p>{{.Error}}</p>{{end}}
+    <form method="post" action="{{.Route}}">
+        <input type="text" name="name" placeholder="Name" autocomplete="username" autocapitalize="none" required autofocus>
+        <input type="password" name="password" placeholder="Password" autocomplete="current-password" required>
+        <button type="submit">Log in</button>
+    </form>
+</body>
+</html>
+`
+
+// Account page, lists the inbox and the outbox of a user of a drop server
+var Account = `
+<!doctype html>
+<html lang="en">
+
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, user-scalable=no">
+    <title>qrcp</title>
+    <style>
+        body {
+            margin: 10px;
+            font-family: sans-serif;
+        }
+        a.button {
+            display: inline-block;
+            padding: 10px 16px;
+            color: #fff;
+            background: #337ab7;
+            border-radius: 4px;
+            text-decoration: none;
+        }
+        table {
+            border-collapse: collapse;
+        }
+        td {
+            padding: 4px 8px;
+        }
+    </style>
+</head>
+
+<body>
+    <h4>Files of {{.Name}}</h4>
+    <form method="post" action="{{.Logout}}"><button type="submit">Log out</button></form>
+    <p><a class="button" href="{{.Route}}upload">Upload files</a></p>
+    {{range $box := .Boxes}}
+    <h5>{{.Title}}</h5>
+    {{if .Files}}
+    <table>
+        {{range .Files}}
+        <tr>
+            <td><a href="{{.Route}}">{{.Name}}</a></td>
+            <td>{{.Size}}</td>
+            <td>
+                <form method="post" action="{{$.Route}}delete" onsubmit="return confirm('Delete ' + this.elements['name'].value + '?')">
+                    <input type="hidden" name="box" value="{{$box.Box}}">
+                    <input type="hidden" name="name" value="{{.Name}}">
+                    <button type="submit">Delete</button>
+                </form>
+            </td>
+        </tr>
+        {{end}}
+    </table>
+    {{else}}
+    <p>{{.Empty}}</p>
+    {{end}}
+    {{end}}
+</body>
+</html>
+`
+
+// Gone page, shown when a link was revoked, expired or was used up
+var Gone = `
+<!doctype html>
+<html lang="en">
+
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, user-scalable=no">
+    <title>qrcp</title>
+    <style>
+        body {
+            margin: 10px;
+            font-family: sans-serif;
+        }
+    </style>
+</head>
+
+<body>
+    <h4>Link not valid</h4>
+    <p>{{.Message}} Ask the sender for a new one.</p>
+</body>
+</html>
+`
+
+// ScanAgain page, shown when the code of a rotating QR code has expired
+var ScanAgain = `
+<!doctype html>
+<html lang="en">
+
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, user-scalable=no">
+    <title>qrcp</title>
+    <style>
+        body {
+            margin: 10px;
+            font-family: sans-serif;
+        }
+    </style>
+</head>
+
+<body>
+    <h4>Scan again</h4>
+    <p>This QR code changes regularly and the one you scanned has expired. Scan the QR code shown by the sender again to connect.</p>
+</body>
+</html>
+`
+
+// RotatingQR page, shows a rotating QR code and reloads it on each rotation
+var RotatingQR = `
+<!doctype html>
+<html lang="en">
+
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, user-scalable=no">
+    <title>qrcp</title>
+    <style>
+        body {
+            margin: 0;
+            display: flex;
+            align-items: center;
+            justify-content: center;
+            height: 100vh;
+        }
+    </style>
+</head>
+
+<body>
+    <img id="qr" src="{{.Image}}" alt="QR code">
+    <script>
+        var qr = document.getElementById('qr')
+        function reload() {
+            qr.src = "{{.Image}}?" + Date.now()
+        }
+        setTimeout(function () {
+            reload()
+            setInterval(reload, {{.Period}})
+        }, {{.Next}})
+    </script>
+</body>
+</html>
+`
+
+// Landing page, tells the client which address of the server it reached
+var Landing = `
+<!doctype html>
+<html lang="en">
+
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, user-scalable=no">
+    <title>qrcp</title>
+    <style>
+        body {
+            margin: 10px;
+            font-family: sans-serif;
+        }
+        a.button {
+            display: inline-block;
+            padding: 10px 16px;
+            color: #fff;
+            background: #337ab7;
+            border-radius: 4px;
+            text-decoration: none;
+        }
+    </style>
+</head>
+
+<body>
+    <h4>Connected</h4>
+    <p>You reached the server at <b>{{.Address}}</b> through its <b>{{.Interface}}</b> interface.</p>
+    <p><a class="button" id="action" href="{{.Route}}">{{.Action}}</a></p>
+    {{if .Fingerprint}}
+    <p>Signed by <code>{{.Fingerprint}}</code>, compare this fingerprint with the one of the sender. Download the <a href="{{.Signature}}">signature</a> first, then check the file with:</p>
+    <pre>mocp verify {{.Name}} --sig {{.Name}}.sig</pre>
+    <p>The <a href="{{.PublicKey}}">public key</a> of the sender can be kept to check later files with <code>--key</code>.</p>
+    {{end}}
+    <script>
+        // Keep the key of end-to-end encryption, if any
+        document.getElementById('action').href += location.hash
+    </script>
+</body>
+</html>
+`
+
+// Decrypt page, downloads a file encrypted end to end and decrypts it in the
+// browser
+var Decrypt = `
+<!doctype html>
+<html lang="en">
+
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, user-scalable=no">
+    <title>qrcp</title>
+    <style>
+        body {
+            margin: 10px;
+            font-family: sans-serif;
+        }
+        a.button {
+            display: inline-block;
+            padding: 10px 16px;
+            color: #fff;
+            background: #337ab7;
+            border-radius: 4px;
+            text-decoration: none;
+        }
+        progress {
+            width: 100%;
+        }
+    </style>
+</head>
+
+<body>
+    <h4>Encrypted file</h4>
+    <p id="message">This file is encrypted end to end, it is decrypted on this device as it downloads.</p>
+    <p><a class="button" id="download" href="#">Download and decrypt</a></p>
+    <progress id="progress" value="0" max="1" hidden></progress>
+    {{if .Fingerprint}}
+    <p>Signed by <code>{{.Fingerprint}}</code>, compare this fingerprint with the one of the sender. Download the <a href="{{.Signature}}">signature</a> first, then check the file with:</p>
+    <pre>mocp verify {{.Name}} --sig {{.Name}}.sig</pre>
+    <p>The <a href="{{.PublicKey}}">public key</a> of the sender can be kept to check later files with <code>--key</code>.</p>
+    {{end}}
+    <script>` + e2eScript + `
+        var message = document.getElementById('message')
+        var button = document.getElementById('download')
+        var progress = document.getElementById('progress')
+        if (!mocpE2E.available) {
+            message.textContent = 'Decrypting needs a secure connection, ask the sender to use HTTPS.'
+            button.hidden = true
+        }
+        button.addEventListener('click', async function (e) {
+            e.preventDefault()
+            button.hidden = true
+            progress.hidden = false
+            var name = ''
+            var received = 0
+            var parts = []
+            var writable = null
+            try {
+                var response = await fetch("{{.Route}}")
+                if (!response.ok) {
+                    throw new Error(response.status + ' ' + response.statusText)
+                }
+                await mocpE2E.decrypt(response, async function (meta) {
+                    name = meta.name
+                    progress.max = meta.size || 1
+                    message.textContent = 'Downloading ' + name
+                    // Stream to disk when the browser can, keep the file in
+                    // memory otherwise
+                    if (window.showSaveFilePicker) {
+                        try {
+                            var handle = await showSaveFilePicker({ suggestedName: name })
+                            writable = await handle.createWritable()
+                        } catch (err) {
+                            if (err.name === 'AbortError') {
+                                throw err
+                            }
+                        }
+                    }
+                }, async function (chunk) {
+                    if (writable) {
+                        await writable.write(chunk)
+                    } else {
+                        parts.push(chunk)
+                    }
+                    received += chunk.length
+                    progress.value = received
+                })
+                if (writable) {
+                    await writable.close()
+                } else {
+                    var link = document.createElement('a')
+                    link.href = URL.createObjectURL(new Blob(parts))
+                    link.download = name
+                    document.body.appendChild(link)
+                    link.click()
+                }
+                message.textContent = 'Done! ' + name + ' was decrypted. You can close this page now.'
+            } catch (err) {
+                if (writable) {
+                    await writable.abort()
+                }
+                message.textContent = err.name === 'AbortError' ? 'Cancelled.' : 'Error: ' + err.message
+            }
+        })
+    </script>
+</body>
+</html>
+`
+
+// e2eScript encrypts and decrypts files in the browser, in the format of the
+// e2e package
+const e2eScript = `
+        var mocpE2E = (function () {
+            var magic = new TextEncoder().encode('MOCPE2E1')
+            var saltSize = 16
+            var frameSize = 64 * 1024
+            var tagSize = 16
+
+            function concat(a, b) {
+                var c = new Uint8Array(a.length + b.length)
+                c.set(a)
+                c.set(b, a.length)
+                return c
+            }
+
+            function hex(bytes) {
+                var s = ''
+                for (var i = 0; i < bytes.length; i++) {
+                    s += (bytes[i] < 16 ? '0' : '') + bytes[i].toString(16)
+                }
+                return s
+            }
+
+            // The key is in the URL fragment, which is never sent to the
+            // server
+            function sessionKey() {
+                var s = new URLSearchParams(location.hash.slice(1)).get('key') || ''
+                s = s.split('-').join('+').split('_').join('/')
+                while (s.length % 4) {
+                    s += '='
+                }
+                var raw = atob(s)
+                var key = new Uint8Array(raw.length)
+                for (var i = 0; i < raw.length; i++) {
+                    key[i] = raw.charCodeAt(i)
+                }
+                return key
+            }
+
+            // The key of a file is derived from the session key and its salt
+            async function fileKey(salt) {
+                var hmac = await crypto.subtle.importKey('raw', sessionKey(), { name: 'HMAC', hash: 'SHA-256' }, false, ['sign'])
+                var raw = await crypto.subtle.sign('HMAC', hmac, concat(new TextEncoder().encode('mocp e2e file'), salt))
+                return crypto.subtle.importKey('raw', raw, 'AES-GCM', false, ['encrypt', 'decrypt'])
+            }
+
+            // Frames are numbered by their nonce, and the last one is flagged
+            function frame(n, last) {
+                var iv = new Uint8Array(12)
+                new DataView(iv.buffer).setUint32(8, n)
+                return { name: 'AES-GCM', iv: iv, additionalData: new Uint8Array([last ? 1 : 0]) }
+            }
+
+            async function seal(key, n, plaintext, last) {
+                var ciphertext = new Uint8Array(await crypto.subtle.encrypt(frame(n, last), key, plaintext))
+                var length = new Uint8Array(4)
+                new DataView(length.buffer).setUint32(0, ciphertext.length)
+                return new Blob([length, ciphertext])
+            }
+
+            // encrypt returns the file encrypted, with its name in the first
+            // frame
+            async function encrypt(file) {
+                var salt = crypto.getRandomValues(new Uint8Array(saltSize))
+                var key = await fileKey(salt)
+                var meta = new TextEncoder().encode(JSON.stringify({ name: file.name, size: file.size }))
+                var parts = [magic, salt, await seal(key, 0, meta, false)]
+                var n = 1
+                for (var offset = 0; ; offset += frameSize) {
+                    var end = Math.min(offset + frameSize, file.size)
+                    var chunk = new Uint8Array(await file.slice(offset, end).arrayBuffer())
+                    parts.push(await seal(key, n++, chunk, end === file.size))
+                    if (end === file.size) {
+                        return new Blob(parts)
+                    }
+                }
+            }
+
+            // encryptForm encrypts the files of a form under random names
+            async function encryptForm(form) {
+                var encrypted = new FormData()
+                for (var entry of form.entries()) {
+                    var file = entry[1]
+                    if (!(file instanceof File) || file.name === '') {
+                        continue
+                    }
+                    var name = hex(crypto.getRandomValues(new Uint8Array(8))) + '.mocp'
+                    encrypted.append(entry[0], await encrypt(file), name)
+                }
+                return encrypted
+            }
+
+            // decrypt reads an encrypted response, passing its metadata to
+            // start, then each decrypted chunk to write
+            async function decrypt(response, start, write) {
+                var reader = response.body.getReader()
+                var buf = new Uint8Array(0)
+                async function read(n) {
+                    while (buf.length < n) {
+                        var result = await reader.read()
+                        if (result.done) {
+                            throw new Error('The download was interrupted')
+                        }
+                        buf = concat(buf, result.value)
+                    }
+                    var b = buf.slice(0, n)
+                    buf = buf.slice(n)
+                    return b
+                }
+                var header = await read(magic.length + saltSize)
+                if (hex(header.slice(0, magic.length)) !== hex(magic)) {
+                    throw new Error('This is not an encrypted file')
+                }
+                var key = await fileKey(header.slice(magic.length))
+                for (var n = 0; ; n++) {
+                    var length = new DataView((await read(4)).buffer).getUint32(0)
+                    if (length > frameSize + tagSize) {
+                        throw new Error('Invalid frame')
+                    }
+                    var ciphertext = await read(length)
+                    var last = false
+                    var plaintext
+                    try {
+                        plaintext = await crypto.subtle.decrypt(frame(n, false), key, ciphertext)
+                    } catch (err) {
+                        try {
+                            plaintext = await crypto.subtle.decrypt(frame(n, true), key, ciphertext)
+                        } catch (err) {
+                            throw new Error('The file was tampered with, or the key is wrong')
+                        }
+                        last = true
+                    }
+                    if (n === 0) {
+                        await start(JSON.parse(new TextDecoder().decode(plaintext)))
+                    } else {
+                        await write(new Uint8Array(plaintext))
+                    }
+                    if (last) {
+                        return
+                    }
+                }
+            }
+
+            return {
+                available: !!(window.crypto && window.crypto.subtle),
+                encrypt: encrypt,
+                encryptForm: encryptForm,
+                decrypt: decrypt
+            }
+        })()
+`
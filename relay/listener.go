@@ -0,0 +1,188 @@
+package relay
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Listener accepts the connections forwarded by a relay. It is used by the
+// HTTP server of a mocp instance in place of a TCP listener
+type Listener struct {
+	// URL is the public address of the session on the relay, to be used
+	// as the base URL of the routes
+	URL string
+	// Session is the first segment of the path of the routes
+	Session string
+	// Relay is the address the relay connections come from
+	Relay   net.IP
+	relay   *url.URL
+	control net.Conn
+	conns   chan net.Conn
+	done    chan struct{}
+	once    sync.Once
+}
+
+// parseURL parses the URL of a relay
+func parseURL(rawurl string) (*url.URL, error) {
+	relay, err := url.Parse(strings.TrimSuffix(rawurl, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if relay.Scheme != "http" && relay.Scheme != "https" {
+		return nil, fmt.Errorf("invalid relay URL: %s", rawurl)
+	}
+	return relay, nil
+}
+
+// Listen registers a session with the relay at rawurl
+func Listen(rawurl string) (*Listener, error) {
+	return ListenNameplate(rawurl, "")
+}
+
+// ListenNameplate registers a session with the relay at rawurl, which can
+// be looked up by its nameplate, the public number of a transfer code
+func ListenNameplate(rawurl string, nameplate string) (*Listener, error) {
+	relay, err := parseURL(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	path := registerPath
+	if nameplate != "" {
+		path += "?" + url.Values{"nameplate": {nameplate}}.Encode()
+	}
+	control, header, err := open(relay, path)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to the relay: %w", err)
+	}
+	ln := &Listener{
+		URL:     header.Get(urlHeader),
+		Session: header.Get(sessionHeader),
+		relay:   relay,
+		control: control,
+		conns:   make(chan net.Conn),
+		done:    make(chan struct{}),
+	}
+	if tcpAddr, ok := control.RemoteAddr().(*net.TCPAddr); ok {
+		ln.Relay = tcpAddr.IP
+	}
+	if ln.URL == "" || ln.Session == "" {
+		control.Close()
+		return nil, errors.New("the relay did not assign a session")
+	}
+	go ln.serve()
+	return ln, nil
+}
+
+// open connects to the relay and upgrades the connection
+func open(relay *url.URL, path string) (net.Conn, http.Header, error) {
+	host := relay.Host
+	if relay.Port() == "" {
+		port := "80"
+		if relay.Scheme == "https" {
+			port = "443"
+		}
+		host = net.JoinHostPort(relay.Hostname(), port)
+	}
+	dialer := &net.Dialer{Timeout: dialTimeout, KeepAlive: 30 * time.Second}
+	var conn net.Conn
+	var err error
+	if relay.Scheme == "https" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, &tls.Config{ServerName: relay.Hostname()})
+	} else {
+		conn, err = dialer.Dial("tcp", host)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	fmt.Fprintf(conn, "GET %s%s HTTP/1.1\r\nHost: %s\r\nConnection: Upgrade\r\nUpgrade: %s\r\n\r\n", relay.EscapedPath(), path, relay.Host, Protocol)
+	reader := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(dialTimeout))
+	res, err := http.ReadResponse(reader, nil)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		conn.Close()
+		return nil, nil, fmt.Errorf("unexpected response from the relay: %s", res.Status)
+	}
+	return &bufferedConn{conn, reader}, res.Header, nil
+}
+
+// Lookup returns the URL of the session registered with nameplate on the
+// relay at rawurl
+func Lookup(rawurl string, nameplate string) (string, error) {
+	relay, err := parseURL(rawurl)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.Get(relay.String() + nameplatePath + url.PathEscape(nameplate))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return "", fmt.Errorf("no transfer with the nameplate %s on the relay", nameplate)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected response from the relay: %s", resp.Status)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// serve opens a data connection for every nonce sent by the relay
+func (ln *Listener) serve() {
+	defer ln.Close()
+	scanner := bufio.NewScanner(ln.control)
+	for scanner.Scan() {
+		go func(nonce string) {
+			conn, _, err := open(ln.relay, connectPath+ln.Session+"/"+nonce)
+			if err != nil {
+				return
+			}
+			select {
+			case ln.conns <- conn:
+			case <-ln.done:
+				conn.Close()
+			}
+		}(scanner.Text())
+	}
+}
+
+// Accept waits for the next connection forwarded by the relay
+func (ln *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-ln.conns:
+		return conn, nil
+	case <-ln.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close ends the session
+func (ln *Listener) Close() error {
+	ln.once.Do(func() {
+		close(ln.done)
+		ln.control.Close()
+	})
+	return nil
+}
+
+// Addr returns the local address of the control connection
+func (ln *Listener) Addr() net.Addr {
+	return ln.control.LocalAddr()
+}
@@ -0,0 +1,283 @@
+package relay
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/claudiodangelis/qrcp/util"
+)
+
+// Protocol is the value of the Upgrade header of the connections opened by
+// mocp instances to the relay
+const Protocol = "mocp-relay"
+
+const (
+	// registerPath opens the control connection of a session
+	registerPath = "/_mocp/register"
+	// connectPath opens a data connection, followed by the session and the
+	// nonce sent by the relay
+	connectPath = "/_mocp/connect/"
+	// nameplatePath looks up a session by nameplate
+	nameplatePath = "/_mocp/nameplate/"
+	// sessionHeader and urlHeader tell the instance its session and the
+	// public URL to use in the QR code
+	sessionHeader = "Mocp-Session"
+	urlHeader     = "Mocp-Url"
+)
+
+// dialTimeout bounds the time the instance has to open a data connection
+const dialTimeout = 10 * time.Second
+
+// Server is the relay, which forwards requests to the mocp instances
+// connected to it. Requests to /<session>/... are sent to the instance
+// that registered the session
+type Server struct {
+	// PublicURL is the address of the relay used in the URLs of the
+	// instances. If empty, it is derived from the register request
+	PublicURL  string
+	mutex      sync.Mutex
+	sessions   map[string]*session
+	nameplates map[string]string
+}
+
+// NewServer returns a relay
+func NewServer(publicURL string) *Server {
+	return &Server{
+		PublicURL:  strings.TrimSuffix(publicURL, "/"),
+		sessions:   make(map[string]*session),
+		nameplates: make(map[string]string),
+	}
+}
+
+// session is a mocp instance connected to the relay
+type session struct {
+	id      string
+	control net.Conn
+	// writes to the control connection are serialized
+	mutex   sync.Mutex
+	pending map[string]chan net.Conn
+	proxy   *httputil.ReverseProxy
+}
+
+// dial asks the instance to open a data connection, and waits for it
+func (s *session) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	nonce, err := util.GetRandomURLPath(util.DefaultTokenBits, util.DefaultTokenAlphabet)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan net.Conn, 1)
+	s.mutex.Lock()
+	s.pending[nonce] = ch
+	_, err = fmt.Fprintf(s.control, "%s\n", nonce)
+	s.mutex.Unlock()
+	defer func() {
+		s.mutex.Lock()
+		delete(s.pending, nonce)
+		s.mutex.Unlock()
+		// Close a connection that arrived too late
+		select {
+		case conn := <-ch:
+			conn.Close()
+		default:
+		}
+	}()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+	select {
+	case conn := <-ch:
+		return conn, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("session %s: %w", s.id, ctx.Err())
+	}
+}
+
+// ServeHTTP handles the connections of the instances and forwards the
+// requests of the clients
+func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == registerPath:
+		srv.register(w, r)
+		return
+	case strings.HasPrefix(r.URL.Path, connectPath):
+		srv.connect(w, r)
+		return
+	case strings.HasPrefix(r.URL.Path, nameplatePath):
+		srv.lookup(w, r)
+		return
+	}
+	id, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	srv.mutex.Lock()
+	s := srv.sessions[id]
+	srv.mutex.Unlock()
+	if s == nil {
+		http.NotFound(w, r)
+		return
+	}
+	s.proxy.ServeHTTP(w, r)
+}
+
+// upgrade switches the connection to the relay protocol
+func upgrade(w http.ResponseWriter, r *http.Request, header http.Header) (net.Conn, error) {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), Protocol) {
+		http.Error(w, "Upgrade required", http.StatusUpgradeRequired)
+		return nil, errors.New("missing upgrade header")
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "Upgrade not supported", http.StatusInternalServerError)
+		return nil, errors.New("connection can't be hijacked")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	header.Set("Connection", "Upgrade")
+	header.Set("Upgrade", Protocol)
+	fmt.Fprintf(rw, "HTTP/1.1 %d %s\r\n", http.StatusSwitchingProtocols, http.StatusText(http.StatusSwitchingProtocols))
+	header.Write(rw)
+	rw.WriteString("\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &bufferedConn{conn, rw.Reader}, nil
+}
+
+// register opens a session for an instance, which lasts as long as the
+// control connection
+func (srv *Server) register(w http.ResponseWriter, r *http.Request) {
+	id, err := util.GetRandomURLPath(util.MinTokenBits, util.DefaultTokenAlphabet)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	publicURL := srv.PublicURL
+	if publicURL == "" {
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		publicURL = scheme + "://" + r.Host
+	}
+	header := http.Header{}
+	header.Set(sessionHeader, id)
+	header.Set(urlHeader, publicURL+"/"+id)
+	// Transfers with a code are found by the nameplate of the code
+	nameplate := r.URL.Query().Get("nameplate")
+	if nameplate != "" {
+		srv.mutex.Lock()
+		_, taken := srv.nameplates[nameplate]
+		if !taken {
+			srv.nameplates[nameplate] = publicURL + "/" + id
+		}
+		srv.mutex.Unlock()
+		if taken {
+			http.Error(w, "Nameplate taken", http.StatusConflict)
+			return
+		}
+		defer func() {
+			srv.mutex.Lock()
+			delete(srv.nameplates, nameplate)
+			srv.mutex.Unlock()
+		}()
+	}
+	control, err := upgrade(w, r, header)
+	if err != nil {
+		return
+	}
+	s := &session{id: id, control: control, pending: make(map[string]chan net.Conn)}
+	transport := &http.Transport{
+		DialContext:         s.dial,
+		MaxIdleConnsPerHost: 16,
+		IdleConnTimeout:     time.Minute,
+		DisableCompression:  true,
+	}
+	s.proxy = &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(&url.URL{Scheme: "http", Host: id})
+			pr.Out.URL.Path = pr.In.URL.Path
+			pr.Out.URL.RawPath = pr.In.URL.RawPath
+			pr.SetXForwarded()
+		},
+		Transport: transport,
+		// Stream downloads and uploads as they go
+		FlushInterval: -1,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Printf("Relay session %s: %v", id, err)
+			http.Error(w, "The sender is not reachable", http.StatusBadGateway)
+		},
+	}
+	srv.mutex.Lock()
+	srv.sessions[id] = s
+	srv.mutex.Unlock()
+	log.Printf("Session %s opened by %s", id, r.RemoteAddr)
+	// The instance never writes to the control connection, a read returns
+	// when it goes away
+	bufio.NewReader(control).ReadByte()
+	srv.mutex.Lock()
+	delete(srv.sessions, id)
+	srv.mutex.Unlock()
+	control.Close()
+	transport.CloseIdleConnections()
+	log.Printf("Session %s closed", id)
+}
+
+// lookup returns the URL of the session with a nameplate
+func (srv *Server) lookup(w http.ResponseWriter, r *http.Request) {
+	srv.mutex.Lock()
+	sessionURL, ok := srv.nameplates[strings.TrimPrefix(r.URL.Path, nameplatePath)]
+	srv.mutex.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	fmt.Fprintln(w, sessionURL)
+}
+
+// connect pairs a data connection with the pending request of the relay
+func (srv *Server) connect(w http.ResponseWriter, r *http.Request) {
+	id, nonce, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, connectPath), "/")
+	srv.mutex.Lock()
+	s := srv.sessions[id]
+	srv.mutex.Unlock()
+	if s == nil {
+		http.NotFound(w, r)
+		return
+	}
+	s.mutex.Lock()
+	ch := s.pending[nonce]
+	delete(s.pending, nonce)
+	s.mutex.Unlock()
+	if ch == nil {
+		http.NotFound(w, r)
+		return
+	}
+	conn, err := upgrade(w, r, http.Header{})
+	if err != nil {
+		return
+	}
+	ch <- conn
+}
+
+// bufferedConn reads what was buffered before the upgrade first
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
@@ -0,0 +1,62 @@
+package relay
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRelay(t *testing.T) {
+	relay := httptest.NewServer(NewServer(""))
+	defer relay.Close()
+	ln, err := Listen(relay.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ln.URL != relay.URL+"/"+ln.Session {
+		t.Errorf("URL = %q, want %q", ln.URL, relay.URL+"/"+ln.Session)
+	}
+	prefix := "/" + ln.Session
+	mux := http.NewServeMux()
+	mux.HandleFunc(prefix+"/send/token", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "hello %s", r.Header.Get("X-Forwarded-For"))
+	})
+	mux.HandleFunc(prefix+"/receive/token", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	})
+	instance := &http.Server{Handler: mux}
+	go instance.Serve(ln)
+	defer instance.Close()
+
+	res, err := http.Get(ln.URL + "/send/token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if got := string(body); got != "hello 127.0.0.1" {
+		t.Errorf("GET = %q, want %q", got, "hello 127.0.0.1")
+	}
+	payload := strings.Repeat("mocp", 1<<16)
+	res, err = http.Post(ln.URL+"/receive/token", "text/plain", strings.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(res.Body)
+	res.Body.Close()
+	if string(body) != payload {
+		t.Errorf("POST echoed %d bytes, want %d", len(body), len(payload))
+	}
+	res, err = http.Get(relay.URL + "/unknown/send/token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown session status = %d, want %d", res.StatusCode, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,42 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// httpResolver reads the address from the plain text body of a URL, as
+// returned by services such as https://api.ipify.org
+type httpResolver struct {
+	url string
+}
+
+func (r httpResolver) Name() string { return "http " + r.url }
+
+func (r httpResolver) Resolve(ctx context.Context, version int) (net.IP, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", r.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 256))
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(strings.TrimSpace(string(body)))
+	if ip == nil {
+		return nil, fmt.Errorf("invalid address in response: %q", body)
+	}
+	return ip, nil
+}
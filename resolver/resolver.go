@@ -0,0 +1,194 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/claudiodangelis/qrcp/util"
+)
+
+// DefaultTimeout bounds the time a single resolver is given
+const DefaultTimeout = 5 * time.Second
+
+// DefaultChain is used when no resolvers are configured
+var DefaultChain = []string{"consensus"}
+
+// Resolver finds the address this host can be reached at
+type Resolver interface {
+	// Name describes the resolver in messages
+	Name() string
+	// Resolve returns the address, preferring the given IP version (4 or 6,
+	// 0 means any)
+	Resolve(ctx context.Context, version int) (net.IP, error)
+}
+
+// Parse builds a resolver from its specification. Supported specifications
+// are:
+//
+//	consensus             HTTP consensus of public services
+//	stun:<host:port>      STUN binding request to the given server
+//	http:<url>            plain text IP address returned by the URL
+//	static:<ip>           a fixed address
+//	default-route         address of the interface used by the default route
+func Parse(spec string) (Resolver, error) {
+	kind, value, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "consensus":
+		return consensusResolver{}, nil
+	case "stun":
+		if value == "" {
+			return nil, errors.New("stun resolver needs a server address")
+		}
+		return stunResolver{server: value}, nil
+	case "http", "https":
+		// The URL scheme is part of the value, e.g. http:https://api.ipify.org
+		if !strings.HasPrefix(value, "http://") && !strings.HasPrefix(value, "https://") {
+			value = spec
+		}
+		return httpResolver{url: value}, nil
+	case "static":
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid static address: %s", value)
+		}
+		return staticResolver{ip: ip}, nil
+	case "default-route":
+		return defaultRouteResolver{}, nil
+	}
+	return nil, fmt.Errorf("unknown resolver: %s", spec)
+}
+
+// Chain tries each resolver in order and returns the first address found
+type Chain struct {
+	Resolvers []Resolver
+	Timeout   time.Duration
+}
+
+// NewChain parses the specifications into a chain. An empty list uses
+// DefaultChain and a zero timeout uses DefaultTimeout
+func NewChain(specs []string, timeout time.Duration) (*Chain, error) {
+	if len(specs) == 0 {
+		specs = DefaultChain
+	}
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	chain := &Chain{Timeout: timeout}
+	for _, spec := range specs {
+		r, err := Parse(spec)
+		if err != nil {
+			return nil, err
+		}
+		chain.Resolvers = append(chain.Resolvers, r)
+	}
+	return chain, nil
+}
+
+// Resolve returns the first address found, along with the name of the
+// resolver that found it
+func (c *Chain) Resolve(version int) (net.IP, string, error) {
+	errs := []error{}
+	for _, r := range c.Resolvers {
+		ip, err := resolveWithTimeout(r, version, c.Timeout)
+		if err == nil {
+			return ip, r.Name(), nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", r.Name(), err))
+	}
+	return nil, "", errors.Join(errs...)
+}
+
+// resolveWithTimeout runs r, giving up after timeout even if the resolver
+// doesn't honor the context
+func resolveWithTimeout(r Resolver, version int, timeout time.Duration) (net.IP, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	type result struct {
+		ip  net.IP
+		err error
+	}
+	results := make(chan result, 1)
+	go func() {
+		ip, err := r.Resolve(ctx, version)
+		results <- result{ip, err}
+	}()
+	select {
+	case res := <-results:
+		if res.err == nil && !matchesVersion(res.ip, version) {
+			return nil, fmt.Errorf("%s is not an IPv%d address", res.ip, version)
+		}
+		return res.ip, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// matchesVersion reports whether ip is of the given IP version
+func matchesVersion(ip net.IP, version int) bool {
+	switch version {
+	case 4:
+		return ip.To4() != nil
+	case 6:
+		return ip.To4() == nil
+	}
+	return true
+}
+
+// LANAddress returns the address of this host on the local network, used
+// when no resolver succeeds
+func LANAddress(version int) (net.IP, error) {
+	if ip, err := resolveWithTimeout(defaultRouteResolver{}, version, DefaultTimeout); err == nil {
+		return ip, nil
+	}
+	_, addrs, err := util.AllLANAddresses()
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(strings.Split(addrs[0], "%")[0])
+	if ip == nil {
+		return nil, errors.New("no LAN address found")
+	}
+	return ip, nil
+}
+
+type consensusResolver struct{}
+
+func (consensusResolver) Name() string { return "consensus" }
+
+func (consensusResolver) Resolve(ctx context.Context, version int) (net.IP, error) {
+	return util.GetExternalIP(version)
+}
+
+type staticResolver struct {
+	ip net.IP
+}
+
+func (r staticResolver) Name() string { return "static" }
+
+func (r staticResolver) Resolve(ctx context.Context, version int) (net.IP, error) {
+	return r.ip, nil
+}
+
+type defaultRouteResolver struct{}
+
+func (defaultRouteResolver) Name() string { return "default-route" }
+
+// Resolve connects a UDP socket towards a public address, which sends no
+// packet but makes the system pick the interface of the default route
+func (defaultRouteResolver) Resolve(ctx context.Context, version int) (net.IP, error) {
+	target := "192.0.2.1:9"
+	if version == 6 {
+		target = "[2001:db8::1]:9"
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", target)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
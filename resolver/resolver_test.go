@@ -0,0 +1,92 @@
+package resolver
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeSTUN answers binding requests with a fixed XOR-MAPPED-ADDRESS
+func fakeSTUN(t *testing.T, mapped net.IP) string {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if n < stunHeaderSize {
+				continue
+			}
+			resp := make([]byte, stunHeaderSize+12)
+			binary.BigEndian.PutUint16(resp[0:], stunBindingSuccess)
+			binary.BigEndian.PutUint16(resp[2:], 12)
+			copy(resp[4:20], buf[4:20])
+			binary.BigEndian.PutUint16(resp[20:], stunXorMappedAddress)
+			binary.BigEndian.PutUint16(resp[22:], 8)
+			resp[25] = 0x01
+			binary.BigEndian.PutUint16(resp[26:], 4242^uint16(stunMagicCookie>>16))
+			for i, b := range mapped.To4() {
+				resp[28+i] = b ^ buf[4+i]
+			}
+			conn.WriteTo(resp, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestChain(t *testing.T) {
+	stun := fakeSTUN(t, net.ParseIP("203.0.113.7"))
+	web := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "198.51.100.3")
+	}))
+	defer web.Close()
+	// Nothing listens on this port, the STUN request times out
+	closed, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead := closed.LocalAddr().String()
+	closed.Close()
+	tests := []struct {
+		name  string
+		specs []string
+		want  string
+		from  string
+	}{
+		{"stun", []string{"stun:" + stun}, "203.0.113.7", "stun " + stun},
+		{"http", []string{"http:" + web.URL}, "198.51.100.3", "http " + web.URL},
+		{"static", []string{"static:192.0.2.10"}, "192.0.2.10", "static"},
+		{"fallback", []string{"stun:" + dead, "static:192.0.2.11"}, "192.0.2.11", "static"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain, err := NewChain(tt.specs, time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ip, from, err := chain.Resolve(4)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ip.String() != tt.want || from != tt.from {
+				t.Errorf("Resolve() = %s from %q, want %s from %q", ip, from, tt.want, tt.from)
+			}
+		})
+	}
+	if _, _, err := (&Chain{Resolvers: []Resolver{staticResolver{ip: net.ParseIP("::1")}}, Timeout: time.Second}).Resolve(4); err == nil {
+		t.Error("Resolve() should reject an address of the wrong IP version")
+	}
+	if _, err := Parse("carrier-pigeon"); err == nil {
+		t.Error("Parse() should reject unknown resolvers")
+	}
+}
@@ -0,0 +1,137 @@
+package resolver
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+)
+
+// STUN message constants, see RFC 5389
+const (
+	stunBindingRequest   = 0x0001
+	stunBindingSuccess   = 0x0101
+	stunMagicCookie      = 0x2112A442
+	stunMappedAddress    = 0x0001
+	stunXorMappedAddress = 0x0020
+	stunHeaderSize       = 20
+)
+
+// stunResolver asks a STUN server for the address it sees requests from
+type stunResolver struct {
+	server string
+}
+
+func (r stunResolver) Name() string { return "stun " + r.server }
+
+func (r stunResolver) Resolve(ctx context.Context, version int) (net.IP, error) {
+	network := "udp"
+	switch version {
+	case 4:
+		network = "udp4"
+	case 6:
+		network = "udp6"
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, r.server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	request := make([]byte, stunHeaderSize)
+	binary.BigEndian.PutUint16(request[0:], stunBindingRequest)
+	binary.BigEndian.PutUint32(request[4:], stunMagicCookie)
+	if _, err := rand.Read(request[8:20]); err != nil {
+		return nil, err
+	}
+	response := make([]byte, 1024)
+	// UDP may drop packets, retransmit a few times within the deadline
+	for attempt := 0; ; attempt++ {
+		if _, err := conn.Write(request); err != nil {
+			return nil, err
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Duration(attempt+1) * 500 * time.Millisecond))
+		n, err := conn.Read(response)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() && ctx.Err() == nil {
+				continue
+			}
+			return nil, err
+		}
+		return parseStunResponse(response[:n], request[8:20])
+	}
+}
+
+// parseStunResponse extracts the mapped address from a binding response
+func parseStunResponse(msg []byte, transactionID []byte) (net.IP, error) {
+	if len(msg) < stunHeaderSize {
+		return nil, errors.New("short STUN response")
+	}
+	if binary.BigEndian.Uint16(msg[0:]) != stunBindingSuccess {
+		return nil, errors.New("unexpected STUN response type")
+	}
+	if string(msg[8:20]) != string(transactionID) {
+		return nil, errors.New("mismatching STUN transaction")
+	}
+	length := int(binary.BigEndian.Uint16(msg[2:]))
+	if len(msg) < stunHeaderSize+length {
+		return nil, errors.New("truncated STUN response")
+	}
+	var mapped net.IP
+	attrs := msg[stunHeaderSize : stunHeaderSize+length]
+	for len(attrs) >= 4 {
+		attrType := binary.BigEndian.Uint16(attrs[0:])
+		attrLen := int(binary.BigEndian.Uint16(attrs[2:]))
+		if len(attrs) < 4+attrLen {
+			break
+		}
+		value := attrs[4 : 4+attrLen]
+		switch attrType {
+		case stunXorMappedAddress:
+			if ip := parseStunAddress(value, msg[4:20]); ip != nil {
+				return ip, nil
+			}
+		case stunMappedAddress:
+			mapped = parseStunAddress(value, nil)
+		}
+		// Attributes are padded to 4 bytes
+		attrs = attrs[4+(attrLen+3)&^3:]
+	}
+	if mapped != nil {
+		return mapped, nil
+	}
+	return nil, errors.New("no mapped address in STUN response")
+}
+
+// parseStunAddress decodes a (XOR-)MAPPED-ADDRESS value. xor is the magic
+// cookie followed by the transaction ID, or nil for a plain address
+func parseStunAddress(value []byte, xor []byte) net.IP {
+	if len(value) < 4 {
+		return nil
+	}
+	var size int
+	switch value[1] {
+	case 0x01:
+		size = net.IPv4len
+	case 0x02:
+		size = net.IPv6len
+	default:
+		return nil
+	}
+	if len(value) < 4+size {
+		return nil
+	}
+	ip := make(net.IP, size)
+	copy(ip, value[4:4+size])
+	if xor != nil {
+		for i := range ip {
+			ip[i] ^= xor[i]
+		}
+	}
+	return ip
+}
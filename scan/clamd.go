@@ -0,0 +1,95 @@
+package scan
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// chunkSize is the largest chunk sent to clamd at once
+const chunkSize = 64 * 1024
+
+// Clamd scans files with the INSTREAM command of clamd
+type Clamd struct {
+	Network string
+	Address string
+	Timeout time.Duration
+}
+
+// clamdStream is a file being sent to clamd
+type clamdStream struct {
+	conn    net.Conn
+	timeout time.Duration
+	// err is the first error of the connection. Once set, the rest of the
+	// file is dropped
+	err       error
+	closeOnce sync.Once
+}
+
+// Start connects to clamd and starts an INSTREAM
+func (c *Clamd) Start() (Stream, error) {
+	conn, err := net.DialTimeout(c.Network, c.Address, c.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("unable to reach clamd: %w", err)
+	}
+	s := &clamdStream{conn: conn, timeout: c.Timeout}
+	conn.SetWriteDeadline(time.Now().Add(c.Timeout))
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("unable to reach clamd: %w", err)
+	}
+	return s, nil
+}
+
+// Write sends p in chunks, each prefixed with its length
+func (s *clamdStream) Write(p []byte) (int, error) {
+	for b := p; len(b) > 0 && s.err == nil; {
+		n := min(len(b), chunkSize)
+		s.conn.SetWriteDeadline(time.Now().Add(s.timeout))
+		if s.err = binary.Write(s.conn, binary.BigEndian, uint32(n)); s.err == nil {
+			_, s.err = s.conn.Write(b[:n])
+		}
+		b = b[n:]
+	}
+	return len(p), nil
+}
+
+// Verdict ends the stream with an empty chunk and reads the reply of clamd,
+// e.g. "stream: OK" or "stream: Eicar-Signature FOUND"
+func (s *clamdStream) Verdict() (Verdict, error) {
+	defer s.Close()
+	if s.err == nil {
+		s.conn.SetWriteDeadline(time.Now().Add(s.timeout))
+		s.err = binary.Write(s.conn, binary.BigEndian, uint32(0))
+	}
+	// clamd may have replied early, e.g. when the file is too large
+	s.conn.SetReadDeadline(time.Now().Add(s.timeout))
+	reply, err := bufio.NewReader(s.conn).ReadString(0)
+	if err != nil {
+		if s.err != nil {
+			return Verdict{}, fmt.Errorf("clamd: %w", s.err)
+		}
+		return Verdict{}, fmt.Errorf("clamd: %w", err)
+	}
+	reply = strings.TrimSuffix(reply, "\x00")
+	reply = strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case reply == "OK":
+		return Verdict{}, nil
+	case strings.HasSuffix(reply, " FOUND"):
+		return Verdict{Threat: strings.TrimSuffix(reply, " FOUND")}, nil
+	}
+	return Verdict{}, fmt.Errorf("clamd: %s", reply)
+}
+
+// Close drops the connection to clamd
+func (s *clamdStream) Close() error {
+	s.closeOnce.Do(func() {
+		s.conn.Close()
+	})
+	return nil
+}
@@ -0,0 +1,114 @@
+package scan
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Command scans files with a command reading them on its standard input.
+// It exits with 0 when the file is clean and 1 when it is infected, like
+// clamscan and clamdscan, and prints the threat found
+type Command struct {
+	Args    []string
+	Timeout time.Duration
+}
+
+// commandStream is a file being piped to the command
+type commandStream struct {
+	cmd     *exec.Cmd
+	cancel  context.CancelFunc
+	timeout time.Duration
+	stdin   io.WriteCloser
+	output  *bytes.Buffer
+	// err is the first error of the pipe, e.g. when the command exits
+	// before reading the whole file
+	err error
+	// waited is set once the command exited
+	waited bool
+}
+
+// Start starts the command
+func (c *Command) Start() (Stream, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd := exec.CommandContext(ctx, c.Args[0], c.Args[1:]...)
+	output := &bytes.Buffer{}
+	cmd.Stdout = output
+	cmd.Stderr = output
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		cancel()
+		return nil, fmt.Errorf("unable to start the scanner: %w", err)
+	}
+	return &commandStream{cmd: cmd, cancel: cancel, timeout: c.Timeout, stdin: stdin, output: output}, nil
+}
+
+func (s *commandStream) Write(p []byte) (int, error) {
+	if s.err == nil {
+		_, s.err = s.stdin.Write(p)
+	}
+	return len(p), nil
+}
+
+// Verdict closes the standard input of the command and waits for it. The
+// timeout runs from there, as the file is piped while being received
+func (s *commandStream) Verdict() (Verdict, error) {
+	defer s.cancel()
+	if s.timeout > 0 {
+		timer := time.AfterFunc(s.timeout, s.cancel)
+		defer timer.Stop()
+	}
+	s.stdin.Close()
+	err := s.cmd.Wait()
+	s.waited = true
+	var exit *exec.ExitError
+	switch {
+	case err == nil && s.err != nil:
+		return Verdict{}, fmt.Errorf("%s didn't read the whole file: %v", s.cmd.Args[0], s.err)
+	case err == nil:
+		return Verdict{}, nil
+	case errors.As(err, &exit) && exit.ExitCode() == 1:
+		return Verdict{Threat: threat(s.output.String())}, nil
+	}
+	return Verdict{}, fmt.Errorf("%s: %v: %s", s.cmd.Args[0], err, strings.TrimSpace(s.output.String()))
+}
+
+// Close kills the command, unless it already exited
+func (s *commandStream) Close() error {
+	if s.waited {
+		return nil
+	}
+	s.cancel()
+	s.stdin.Close()
+	s.waited = true
+	s.cmd.Wait()
+	return nil
+}
+
+// threat extracts the name of the threat from the output of the command,
+// e.g. "stdin: Eicar-Signature FOUND"
+func threat(output string) string {
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasSuffix(line, " FOUND") {
+			line = strings.TrimSuffix(line, " FOUND")
+			if i := strings.LastIndex(line, ": "); i >= 0 {
+				line = line[i+2:]
+			}
+			return line
+		}
+	}
+	if line := strings.TrimSpace(output); line != "" {
+		return strings.SplitN(line, "\n", 2)[0]
+	}
+	return "infected"
+}
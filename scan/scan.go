@@ -0,0 +1,114 @@
+package scan
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout bounds the connection to clamd, and how long the verdict
+// takes once a file is received
+const DefaultTimeout = 2 * time.Minute
+
+// Verdict is the result of the scan of a file
+type Verdict struct {
+	// Threat names what was found, it is empty for clean files
+	Threat string
+}
+
+// Clean reports whether nothing was found
+func (v Verdict) Clean() bool {
+	return v.Threat == ""
+}
+
+// Scanner scans files while they are received
+type Scanner interface {
+	// Start starts the scan of a file, whose content is written to the
+	// returned stream
+	Start() (Stream, error)
+}
+
+// Stream receives the content of a file being scanned
+type Stream interface {
+	// Write sends content to the scanner. Errors of the scanner are kept
+	// for Verdict, so that the file can still be received
+	Write(p []byte) (int, error)
+	// Verdict ends the stream and waits for the verdict
+	Verdict() (Verdict, error)
+	// Close aborts the scan, e.g. when the upload fails. It does nothing
+	// once Verdict was called
+	Close() error
+}
+
+// New returns the scanner of spec:
+//
+//	clamd:unix:/run/clamav/clamd.ctl  clamd on a Unix socket
+//	clamd:tcp:127.0.0.1:3310          clamd on a TCP address
+//	command:clamscan --no-summary -   a command reading the file on its
+//	                                  standard input, exiting with 0 when
+//	                                  clean and 1 when infected
+func New(spec string) (Scanner, error) {
+	kind, arg, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "clamd":
+		network, address, ok := strings.Cut(arg, ":")
+		if !ok || (network != "unix" && network != "tcp") || address == "" {
+			return nil, fmt.Errorf("invalid clamd address %q, use clamd:unix:<path> or clamd:tcp:<host:port>", arg)
+		}
+		return &Clamd{Network: network, Address: address, Timeout: DefaultTimeout}, nil
+	case "command":
+		args := strings.Fields(arg)
+		if len(args) == 0 {
+			return nil, errors.New("the scanner command is empty")
+		}
+		return &Command{Args: args, Timeout: DefaultTimeout}, nil
+	}
+	return nil, fmt.Errorf("unknown scanner %q, use clamd:<network>:<address> or command:<command>", spec)
+}
+
+// Quarantine moves the file at path to dir, under a name that doesn't
+// overwrite the files already there, and returns its new path
+func Quarantine(path string, dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(filepath.Base(path), ext)
+	target := filepath.Join(dir, base+ext)
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(target); errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		target = filepath.Join(dir, fmt.Sprintf("%s(%d)%s", base, i, ext))
+	}
+	if err := os.Rename(path, target); err == nil {
+		return target, nil
+	}
+	// The quarantine may be on another device
+	if err := copyFile(path, target); err != nil {
+		os.Remove(target)
+		return "", err
+	}
+	return target, os.Remove(path)
+}
+
+func copyFile(from string, to string) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
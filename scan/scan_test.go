@@ -0,0 +1,186 @@
+package scan
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// eicar is the standard test file of antivirus software
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// fakeClamd serves the INSTREAM command of clamd on a Unix socket, finding
+// the EICAR test file and refusing streams larger than limit
+func fakeClamd(t *testing.T, limit int) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "clamd.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				if command, err := r.ReadString(0); err != nil || command != "zINSTREAM\x00" {
+					conn.Write([]byte("UNKNOWN COMMAND\x00"))
+					return
+				}
+				var data bytes.Buffer
+				for {
+					var size uint32
+					if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+						return
+					}
+					if size == 0 {
+						break
+					}
+					if data.Len()+int(size) > limit {
+						conn.Write([]byte("INSTREAM size limit exceeded. ERROR\x00"))
+						return
+					}
+					if _, err := io.CopyN(&data, r, int64(size)); err != nil {
+						return
+					}
+				}
+				if strings.Contains(data.String(), "EICAR-STANDARD-ANTIVIRUS-TEST-FILE") {
+					conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+					return
+				}
+				conn.Write([]byte("stream: OK\x00"))
+			}(conn)
+		}
+	}()
+	return path
+}
+
+// scan streams content to scanner in small writes, like the receive
+// handler does
+func scan(t *testing.T, scanner Scanner, content []byte) (Verdict, error) {
+	t.Helper()
+	stream, err := scanner.Start()
+	if err != nil {
+		return Verdict{}, err
+	}
+	for b := content; len(b) > 0; {
+		n := min(len(b), 1024)
+		stream.Write(b[:n])
+		b = b[n:]
+	}
+	return stream.Verdict()
+}
+
+func TestNew(t *testing.T) {
+	for _, spec := range []string{"clamd:unix:/run/clamd.ctl", "clamd:tcp:127.0.0.1:3310", "command:clamscan --no-summary -"} {
+		if _, err := New(spec); err != nil {
+			t.Errorf("New(%q) = %v", spec, err)
+		}
+	}
+	for _, spec := range []string{"clamd:udp:127.0.0.1:3310", "clamd:unix:", "command:", "virustotal"} {
+		if _, err := New(spec); err == nil {
+			t.Errorf("New(%q) should fail", spec)
+		}
+	}
+}
+
+func TestClamd(t *testing.T) {
+	scanner, err := New("clamd:unix:" + fakeClamd(t, 1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		content []byte
+		threat  string
+		err     bool
+	}{
+		{"clean", []byte("hello"), "", false},
+		{"infected", []byte(eicar), "Eicar-Test-Signature", false},
+		{"empty", nil, "", false},
+		{"several chunks", bytes.Repeat([]byte("a"), 3*chunkSize+1), "", false},
+		{"too large", bytes.Repeat([]byte("a"), 2<<20), "", true},
+	}
+	for _, tt := range tests {
+		verdict, err := scan(t, scanner, tt.content)
+		if (err != nil) != tt.err || verdict.Threat != tt.threat {
+			t.Errorf("%s: verdict %+v, %v", tt.name, verdict, err)
+		}
+	}
+	unreachable, _ := New("clamd:unix:" + filepath.Join(t.TempDir(), "missing.sock"))
+	if _, err := unreachable.Start(); err == nil {
+		t.Error("starting a scan without clamd should fail")
+	}
+}
+
+func TestCommand(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no shell")
+	}
+	script := filepath.Join(t.TempDir(), "scanner.sh")
+	os.WriteFile(script, []byte("#!/bin/sh\ncase \"$(cat)\" in\n*EICAR*) echo 'stdin: Eicar-Test-Signature FOUND'; exit 1;;\nerror) echo 'database missing'; exit 2;;\nesac\n"), 0755)
+	scanner, err := New("command:" + script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verdict, err := scan(t, scanner, []byte("hello")); err != nil || !verdict.Clean() {
+		t.Errorf("clean file: %+v, %v", verdict, err)
+	}
+	if verdict, err := scan(t, scanner, []byte(eicar)); err != nil || verdict.Threat != "Eicar-Test-Signature" {
+		t.Errorf("infected file: %+v, %v", verdict, err)
+	}
+	if _, err := scan(t, scanner, []byte("error")); err == nil || !strings.Contains(err.Error(), "database missing") {
+		t.Errorf("failing scanner: %v", err)
+	}
+}
+
+func TestCommandClose(t *testing.T) {
+	if _, err := exec.LookPath("cat"); err != nil {
+		t.Skip("no cat")
+	}
+	scanner, err := New("command:cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := scanner.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.Write([]byte("the first half of the file"))
+	stream.Close()
+	if cmd := stream.(*commandStream).cmd; cmd.ProcessState == nil {
+		t.Error("the scanner is still running")
+	}
+}
+
+func TestQuarantine(t *testing.T) {
+	dir := t.TempDir()
+	quarantine := filepath.Join(t.TempDir(), "quarantine")
+	for i := 0; i < 2; i++ {
+		path := filepath.Join(dir, "invoice.pdf")
+		os.WriteFile(path, []byte(eicar), 0644)
+		target, err := Quarantine(path, quarantine)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"invoice.pdf", "invoice(1).pdf"}[i]
+		if filepath.Base(target) != want {
+			t.Errorf("quarantined as %s, want %s", filepath.Base(target), want)
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Error("the file was left in place")
+		}
+	}
+}
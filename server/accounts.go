@@ -0,0 +1,261 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/claudiodangelis/qrcp/audit"
+	"github.com/claudiodangelis/qrcp/pages"
+	"github.com/claudiodangelis/qrcp/users"
+	"github.com/claudiodangelis/qrcp/util"
+)
+
+// accountCookie carries the session of the users logged in
+const accountCookie = "mocp-account"
+
+// accountSessionLifetime is how long users stay logged in
+const accountSessionLifetime = 12 * time.Hour
+
+// Folders of each user, below the folder of the accounts: files uploaded
+// by the user land in the inbox, files for the user are put in the outbox
+const (
+	Inbox  = "inbox"
+	Outbox = "outbox"
+)
+
+// accountSession is the session of a logged in user. It ends with the
+// password it was opened with
+type accountSession struct {
+	name    string
+	hash    string
+	expires time.Time
+}
+
+// accounts serves a folder for each user of the store, below root
+type accounts struct {
+	store    *users.Store
+	root     string
+	mu       sync.Mutex
+	sessions map[string]accountSession
+}
+
+// ServeAccounts turns the server into a drop server for the users of
+// store, each with an inbox and an outbox below root. Users log in at
+// LoginURL
+func (s *Server) ServeAccounts(store *users.Store, root string) error {
+	if s.e2eKey != nil {
+		return errors.New("accounts can't be used with --e2e")
+	}
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(root)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a valid directory", root)
+	}
+	s.accounts = &accounts{store: store, root: root, sessions: make(map[string]accountSession)}
+	s.LoginURL = s.BaseURL + "/login"
+	http.HandleFunc(s.basePath+"/login", s.audit.Handler("", s.approvals.Handler(s.serveLogin)))
+	http.HandleFunc(s.basePath+"/logout", s.audit.Handler("", s.serveLogout))
+	http.HandleFunc(s.basePath+"/account/", s.audit.Handler("", s.approvals.Handler(s.serveAccount)))
+	return nil
+}
+
+// user returns the name of the user logged in with the request
+func (a *accounts) user(r *http.Request) (string, bool) {
+	cookie, err := r.Cookie(accountCookie)
+	if err != nil {
+		return "", false
+	}
+	a.mu.Lock()
+	session, ok := a.sessions[cookie.Value]
+	a.mu.Unlock()
+	if !ok || time.Now().After(session.expires) || !a.store.Current(session.name, session.hash) {
+		return "", false
+	}
+	return session.name, true
+}
+
+// login opens the session of the user name, whose password matched hash
+func (a *accounts) login(w http.ResponseWriter, r *http.Request, name string, hash string) error {
+	for _, box := range []string{Inbox, Outbox} {
+		if err := os.MkdirAll(filepath.Join(a.root, name, box), 0700); err != nil {
+			return err
+		}
+	}
+	id, err := util.GetSessionID()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	a.mu.Lock()
+	for key, session := range a.sessions {
+		if now.After(session.expires) {
+			delete(a.sessions, key)
+		}
+	}
+	a.sessions[id] = accountSession{name: name, hash: hash, expires: now.Add(accountSessionLifetime)}
+	a.mu.Unlock()
+	http.SetCookie(w, &http.Cookie{
+		Name:     accountCookie,
+		Value:    id,
+		Path:     "/",
+		Expires:  now.Add(accountSessionLifetime),
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// serveLogin serves the login page, and logs the users in
+func (s *Server) serveLogin(w http.ResponseWriter, r *http.Request) {
+	htmlVariables := struct {
+		Route string
+		Error string
+	}{Route: s.basePath + "/login"}
+	if r.Method != http.MethodPost {
+		serveTemplate("login", pages.Login, w, htmlVariables)
+		return
+	}
+	name := r.FormValue("name")
+	hash, ok := s.accounts.store.Authenticate(name, r.FormValue("password"))
+	if !ok {
+		log.Printf("Failed login as %q from %s", name, remoteIP(r))
+		w.WriteHeader(http.StatusUnauthorized)
+		htmlVariables.Error = "Wrong name or password."
+		serveTemplate("login", pages.Login, w, htmlVariables)
+		return
+	}
+	if err := s.accounts.login(w, r, name, hash); err != nil {
+		log.Printf("Unable to log %s in: %v", name, err)
+		http.Error(w, "Unable to log in", http.StatusInternalServerError)
+		return
+	}
+	audit.SetUser(r.Context(), name)
+	log.Printf("%s logged in from %s", name, remoteIP(r))
+	http.Redirect(w, r, s.basePath+"/account/", http.StatusSeeOther)
+}
+
+// serveLogout ends the session of the user
+func (s *Server) serveLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if cookie, err := r.Cookie(accountCookie); err == nil {
+		s.accounts.mu.Lock()
+		delete(s.accounts.sessions, cookie.Value)
+		s.accounts.mu.Unlock()
+	}
+	http.SetCookie(w, &http.Cookie{Name: accountCookie, Path: "/", MaxAge: -1})
+	http.Redirect(w, r, s.basePath+"/login", http.StatusSeeOther)
+}
+
+// accountBox is a folder of a user, as listed on the account page
+type accountBox struct {
+	Box   string
+	Title string
+	Empty string
+	Files []listedFile
+}
+
+// serveAccount serves the routes of a user: the account page, the upload
+// to the inbox, the files of both folders and their deletion
+func (s *Server) serveAccount(w http.ResponseWriter, r *http.Request) {
+	name, ok := s.accounts.user(r)
+	if !ok {
+		http.Redirect(w, r, s.basePath+"/login", http.StatusSeeOther)
+		return
+	}
+	audit.SetUser(r.Context(), name)
+	route := s.basePath + "/account/"
+	dir := filepath.Join(s.accounts.root, name)
+	action := strings.TrimPrefix(r.URL.Path, route)
+	switch {
+	case action == "":
+		htmlVariables := struct {
+			Name   string
+			Route  string
+			Logout string
+			Boxes  []accountBox
+		}{Name: name, Route: route, Logout: s.basePath + "/logout"}
+		for _, box := range []accountBox{
+			{Box: Inbox, Title: "Uploaded by you", Empty: "You haven't uploaded any files."},
+			{Box: Outbox, Title: "Shared with you", Empty: "No files are shared with you."},
+		} {
+			files, err := listFolder(filepath.Join(dir, box.Box), route+"files/"+box.Box+"/")
+			if err != nil {
+				log.Printf("Unable to list the files of %s: %v", name, err)
+			}
+			box.Files = files
+			htmlVariables.Boxes = append(htmlVariables.Boxes, box)
+		}
+		serveTemplate("account", pages.Account, w, htmlVariables)
+	case action == "upload":
+		s.receive(route+"upload", filepath.Join(dir, Inbox), true)(w, r)
+	case action == "delete":
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		box, file := r.FormValue("box"), r.FormValue("name")
+		if box != Inbox && box != Outbox {
+			http.NotFound(w, r)
+			return
+		}
+		p, err := folderPath(filepath.Join(dir, box), file)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		if err := os.Remove(p); err != nil {
+			http.Error(w, "Unable to delete the file", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("%s deleted %s/%s", name, box, file)
+		http.Redirect(w, r, route, http.StatusSeeOther)
+	case strings.HasPrefix(action, "files/"):
+		box, file, _ := strings.Cut(strings.TrimPrefix(action, "files/"), "/")
+		if box != Inbox && box != Outbox {
+			http.NotFound(w, r)
+			return
+		}
+		p, err := folderPath(filepath.Join(dir, box), file)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			http.Error(w, "Unable to open the file", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(file)}))
+		if r.Method == http.MethodGet {
+			audit.AddFile(r.Context(), audit.File{Name: box + "/" + file, Size: info.Size()})
+		}
+		http.ServeContent(w, r, path.Base(file), info.ModTime(), f)
+	default:
+		http.NotFound(w, r)
+	}
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/claudiodangelis/qrcp/config"
+	"github.com/claudiodangelis/qrcp/users"
+)
+
+func TestServeAccount(t *testing.T) {
+	root := t.TempDir()
+	store, err := users.Load(filepath.Join(t.TempDir(), "users.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"alice", "bob"} {
+		if err := store.Add(name, name+" password"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	uploads := ""
+	s := &Server{
+		accounts: &accounts{store: store, root: root, sessions: map[string]accountSession{}},
+		receive: func(route string, dir string, keepAlive bool) http.HandlerFunc {
+			return func(w http.ResponseWriter, r *http.Request) { uploads = dir }
+		},
+	}
+	login := func(name string, password string) *httptest.ResponseRecorder {
+		form := url.Values{"name": {name}, "password": {password}}
+		r := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		s.serveLogin(w, r)
+		return w
+	}
+	if w := login("alice", "bob password"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("login with the password of another user = %d", w.Code)
+	}
+	w := login("alice", "alice password")
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("login = %d", w.Code)
+	}
+	cookie := w.Result().Cookies()[0]
+	get := func(method string, route string, form url.Values) *httptest.ResponseRecorder {
+		var r *http.Request
+		if form != nil {
+			r = httptest.NewRequest(method, route, strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			r = httptest.NewRequest(method, route, nil)
+		}
+		r.AddCookie(cookie)
+		w := httptest.NewRecorder()
+		s.serveAccount(w, r)
+		return w
+	}
+	os.WriteFile(filepath.Join(root, "alice", Outbox, "report.pdf"), []byte("report"), 0644)
+	os.MkdirAll(filepath.Join(root, "bob", Inbox), 0700)
+	os.WriteFile(filepath.Join(root, "bob", Inbox, "secret.txt"), []byte("secret"), 0644)
+	if w := get("GET", "/account/", nil); !strings.Contains(w.Body.String(), "/account/files/outbox/report.pdf") {
+		t.Fatalf("account page:\n%s", w.Body)
+	}
+	if w := get("GET", "/account/files/outbox/report.pdf", nil); w.Code != http.StatusOK || w.Body.String() != "report" {
+		t.Fatalf("download = %d %q", w.Code, w.Body)
+	}
+	if w := get("GET", "/account/files/inbox/../../bob/inbox/secret.txt", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("download from another user = %d", w.Code)
+	}
+	if w := get("GET", "/account/files/secret/report.pdf", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("download from an unknown folder = %d", w.Code)
+	}
+	get("POST", "/account/upload", nil)
+	if uploads != filepath.Join(root, "alice", Inbox) {
+		t.Fatalf("uploaded to %s", uploads)
+	}
+	if w := get("POST", "/account/delete", url.Values{"box": {Outbox}, "name": {"report.pdf"}}); w.Code != http.StatusSeeOther {
+		t.Fatalf("delete = %d", w.Code)
+	}
+	if _, err := os.Stat(filepath.Join(root, "alice", Outbox, "report.pdf")); !os.IsNotExist(err) {
+		t.Fatal("the file was not deleted")
+	}
+	// Changing the password ends the session
+	if err := store.SetPassword("alice", "new alice password"); err != nil {
+		t.Fatal(err)
+	}
+	if w := get("GET", "/account/", nil); w.Code != http.StatusSeeOther {
+		t.Fatalf("account page after a change of password = %d", w.Code)
+	}
+}
+
+func TestAccountsWithoutTransferRoutes(t *testing.T) {
+	cfg := config.Config{Interface: "lo", KeepAlive: true, Accounts: true}
+	s, err := New(&cfg)
+	if err != nil {
+		t.Skipf("no loopback interface named lo: %v", err)
+	}
+	store, err := users.Load(filepath.Join(t.TempDir(), "users.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ServeAccounts(store, t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Timeout: 5 * time.Second}
+	for _, route := range []string{s.SendURL, s.ReceiveURL, s.LoginURL} {
+		resp, err := client.Get(route)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		want := http.StatusNotFound
+		if route == s.LoginURL {
+			want = http.StatusOK
+		}
+		if resp.StatusCode != want {
+			t.Errorf("%s = %s, want %d", route, resp.Status, want)
+		}
+	}
+}
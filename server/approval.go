@@ -0,0 +1,207 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/claudiodangelis/qrcp/pages"
+	"github.com/claudiodangelis/qrcp/util"
+)
+
+// deviceCookie identifies a device across requests when --confirm is set
+const deviceCookie = "mocp-device"
+
+const (
+	// maxPendingPerIP is how many devices of one address may wait for a
+	// decision at the same time
+	maxPendingPerIP = 3
+	// rejectedLifetime is how long rejected devices are remembered, so
+	// that they aren't asked about again right away
+	rejectedLifetime = 10 * time.Minute
+)
+
+// errTooManyPending is returned when an address has too many devices waiting
+// for a decision
+var errTooManyPending = errors.New("too many devices waiting for approval")
+
+// device is a client waiting for, or having received, the approval of the
+// operator
+type device struct {
+	ip    string
+	agent string
+	// cookie identifies the device if it's a browser
+	cookie    string
+	decided   chan struct{}
+	decidedAt time.Time
+	accepted  bool
+}
+
+// approvals keeps track of the devices that connected to the server and of
+// the decisions taken by the operator. Devices are asked about one at a time
+type approvals struct {
+	mu      sync.Mutex
+	devices map[string]*device
+	queue   []*device
+}
+
+func newApprovals() *approvals {
+	return &approvals{devices: make(map[string]*device)}
+}
+
+func deviceKey(ip, agent, cookie string) string {
+	return ip + "\x00" + agent + "\x00" + cookie
+}
+
+// device returns the device identified by ip, user agent and cookie,
+// queueing it for approval if it's the first time it's seen. Requests
+// without a known cookie are the same device as the last one of their
+// address and user agent, unless that one is an accepted browser, so that
+// clients dropping cookies can't queue endless prompts
+func (a *approvals) device(ip, agent, cookie string, browser bool) (*device, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.prune(time.Now())
+	if cookie != "" {
+		if d, ok := a.devices[deviceKey(ip, agent, cookie)]; ok {
+			return d, nil
+		}
+	}
+	anonymous := deviceKey(ip, agent, "")
+	if d, ok := a.devices[anonymous]; ok && !(browser && d.accepted) {
+		return d, nil
+	}
+	pending := 0
+	for _, d := range a.queue {
+		if d.ip == ip {
+			pending++
+		}
+	}
+	if pending >= maxPendingPerIP {
+		return nil, errTooManyPending
+	}
+	d := &device{ip: ip, agent: agent, decided: make(chan struct{})}
+	if browser {
+		var err error
+		if d.cookie, err = util.GetRandomURLPath(0, ""); err != nil {
+			return nil, err
+		}
+		a.devices[deviceKey(ip, agent, d.cookie)] = d
+	}
+	a.devices[anonymous] = d
+	a.queue = append(a.queue, d)
+	if len(a.queue) == 1 {
+		ShowApprovalPrompt(d.ip, describeUserAgent(d.agent))
+	}
+	return d, nil
+}
+
+// prune forgets the devices rejected for longer than rejectedLifetime
+func (a *approvals) prune(now time.Time) {
+	for key, d := range a.devices {
+		if !d.decidedAt.IsZero() && !d.accepted && now.Sub(d.decidedAt) > rejectedLifetime {
+			delete(a.devices, key)
+		}
+	}
+}
+
+// pending reports whether a device is waiting for a decision
+func (a *approvals) pending() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.queue) > 0
+}
+
+// decide accepts or rejects the device at the head of the queue, then asks
+// about the next one
+func (a *approvals) decide(accept bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.queue) == 0 {
+		return
+	}
+	d := a.queue[0]
+	a.queue = a.queue[1:]
+	d.accepted = accept
+	d.decidedAt = time.Now()
+	close(d.decided)
+	if accept {
+		log.Printf("Accepted device %s (%s)", d.ip, describeUserAgent(d.agent))
+	} else {
+		log.Printf("Rejected device %s (%s)", d.ip, describeUserAgent(d.agent))
+	}
+	if len(a.queue) > 0 {
+		ShowApprovalPrompt(a.queue[0].ip, describeUserAgent(a.queue[0].agent))
+	}
+}
+
+// Handler only lets requests from accepted devices through. Browsers are
+// shown a page that reloads until the operator decides, other clients wait
+// for the decision
+func (a *approvals) Handler(next http.HandlerFunc) http.HandlerFunc {
+	if a == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Browsers are told apart by a cookie, other clients by their
+		// address and user agent only
+		browser := strings.HasPrefix(r.UserAgent(), "Mozilla")
+		var value string
+		if cookie, err := r.Cookie(deviceCookie); err == nil {
+			value = cookie.Value
+		}
+		d, err := a.device(remoteIP(r), r.UserAgent(), value, browser)
+		if errors.Is(err, errTooManyPending) {
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if d.cookie != "" && d.cookie != value {
+			http.SetCookie(w, &http.Cookie{
+				Name:     deviceCookie,
+				Value:    d.cookie,
+				Path:     "/",
+				HttpOnly: true,
+				SameSite: http.SameSiteStrictMode,
+			})
+		}
+		select {
+		case <-d.decided:
+		default:
+			if browser {
+				serveTemplate("waiting", pages.Waiting, w, nil)
+				return
+			}
+			select {
+			case <-d.decided:
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if !d.accepted {
+			w.WriteHeader(http.StatusForbidden)
+			serveTemplate("rejected", pages.Rejected, w, nil)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// AwaitingApproval reports whether the operator is being asked to accept a
+// device. It's always false unless --confirm is set
+func (s Server) AwaitingApproval() bool {
+	return s.approvals != nil && s.approvals.pending()
+}
+
+// Approve accepts or rejects the device the operator is being asked about
+func (s Server) Approve(accept bool) {
+	if s.approvals != nil {
+		s.approvals.decide(accept)
+	}
+}
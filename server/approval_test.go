@@ -0,0 +1,63 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestApprovalsWithoutCookies(t *testing.T) {
+	a := newApprovals()
+	handler := a.Handler(func(w http.ResponseWriter, r *http.Request) {})
+	request := func(agent string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		r.Header.Set("User-Agent", agent)
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+	// A browser dropping its cookie is asked about once
+	for i := 0; i < 10; i++ {
+		request("Mozilla/5.0", nil)
+	}
+	if len(a.queue) != 1 {
+		t.Fatalf("%d devices queued, want 1", len(a.queue))
+	}
+	// Changing the user agent doesn't queue more than maxPendingPerIP
+	var w *httptest.ResponseRecorder
+	for i := 0; i < 10; i++ {
+		w = request("Mozilla/5.0 "+string(rune('a'+i)), nil)
+	}
+	if len(a.queue) != maxPendingPerIP || w.Code != http.StatusTooManyRequests {
+		t.Fatalf("%d devices queued, last status %d", len(a.queue), w.Code)
+	}
+	// The browser keeping its cookie is let through once accepted, another
+	// browser without it is asked about
+	first := request("Mozilla/5.0", nil).Result().Cookies()
+	if len(first) != 1 {
+		t.Fatal("no device cookie")
+	}
+	for a.pending() {
+		a.decide(true)
+	}
+	if w := request("Mozilla/5.0", first[0]); w.Code != http.StatusOK {
+		t.Errorf("accepted browser got status %d", w.Code)
+	}
+	request("Mozilla/5.0", nil)
+	if len(a.queue) != 1 {
+		t.Errorf("%d devices queued, want 1", len(a.queue))
+	}
+	// Rejected devices are forgotten after a while
+	a.decide(false)
+	a.prune(time.Now().Add(rejectedLifetime + time.Minute))
+	for _, d := range a.devices {
+		if !d.accepted {
+			t.Error("a rejected device was kept")
+		}
+	}
+}
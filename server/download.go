@@ -0,0 +1,141 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fileDigest is the SHA-256 of the shared file, computed in the background
+// when the file is shared and advertised to clients in the Repr-Digest
+// header
+type fileDigest struct {
+	done chan struct{}
+	sum  []byte
+}
+
+// digestFile starts hashing the file at path
+func digestFile(path string) *fileDigest {
+	d := &fileDigest{done: make(chan struct{})}
+	go func() {
+		defer close(d.done)
+		f, err := os.Open(path)
+		if err != nil {
+			return
+		}
+		defer f.Close()
+		hash := sha256.New()
+		if _, err := io.Copy(hash, f); err != nil {
+			return
+		}
+		d.sum = hash.Sum(nil)
+	}()
+	return d
+}
+
+// header returns the value of the Repr-Digest header, see RFC 9530. When
+// wait is false, it is empty until the file has been hashed
+func (d *fileDigest) header(wait bool) string {
+	if d == nil {
+		return ""
+	}
+	if wait {
+		<-d.done
+	}
+	select {
+	case <-d.done:
+	default:
+		return ""
+	}
+	if d.sum == nil {
+		return ""
+	}
+	return "sha-256=:" + base64.StdEncoding.EncodeToString(d.sum) + ":"
+}
+
+// errUnsatisfiableRange is returned for ranges outside of the file
+var errUnsatisfiableRange = errors.New("unsatisfiable range")
+
+// parseRange parses a Range header with a single range of bytes, and
+// returns its start and length. ok is false when the whole file has to be
+// sent, as for multiple ranges which are not supported
+func parseRange(header string, size int64) (start, length int64, ok bool, err error) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, size, false, nil
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, size, false, nil
+	}
+	if first == "" {
+		// The last bytes of the file
+		suffix, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || suffix <= 0 {
+			return 0, 0, false, errUnsatisfiableRange
+		}
+		suffix = min(suffix, size)
+		return size - suffix, suffix, true, nil
+	}
+	start, err = strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false, errUnsatisfiableRange
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false, errUnsatisfiableRange
+		}
+		end = min(end, size-1)
+	}
+	return start, end - start + 1, true, nil
+}
+
+// sendProgress adds up the bytes sent by the parallel requests of a
+// download, so that they share one progress bar
+type sendProgress struct {
+	mutex    sync.Mutex
+	inflight int
+	start    time.Time
+	sent     int64
+}
+
+// begin counts a request, starting a new download if none is in flight
+func (p *sendProgress) begin() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.inflight == 0 {
+		p.start = time.Now()
+		p.sent = 0
+	}
+	p.inflight++
+}
+
+// end removes a request
+func (p *sendProgress) end() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.inflight--
+}
+
+// add records n bytes sent
+func (p *sendProgress) add(n int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.sent += int64(n)
+}
+
+// stats returns the bytes sent and the time elapsed since the download
+// started
+func (p *sendProgress) stats() (int64, time.Duration) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.sent, time.Since(p.start)
+}
@@ -0,0 +1,32 @@
+package server
+
+import "testing"
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header  string
+		start   int64
+		length  int64
+		partial bool
+		err     bool
+	}{
+		{"", 0, 100, false, false},
+		{"bytes=0-9", 0, 10, true, false},
+		{"bytes=90-", 90, 10, true, false},
+		{"bytes=90-200", 90, 10, true, false},
+		{"bytes=-20", 80, 20, true, false},
+		{"bytes=0-1,5-6", 0, 100, false, false},
+		{"bytes=100-", 0, 0, false, true},
+		{"bytes=9-1", 0, 0, false, true},
+	}
+	for _, tt := range tests {
+		start, length, partial, err := parseRange(tt.header, 100)
+		if (err != nil) != tt.err {
+			t.Errorf("parseRange(%q) error = %v", tt.header, err)
+			continue
+		}
+		if start != tt.start || length != tt.length || partial != tt.partial {
+			t.Errorf("parseRange(%q) = %d, %d, %v, want %d, %d, %v", tt.header, start, length, partial, tt.start, tt.length, tt.partial)
+		}
+	}
+}
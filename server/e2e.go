@@ -0,0 +1,44 @@
+package server
+
+import (
+	"os"
+
+	"github.com/claudiodangelis/qrcp/body"
+	"github.com/claudiodangelis/qrcp/e2e"
+)
+
+// encryptBody encrypts the body to a temporary file. A temporary body, like
+// the zip of several files, is removed so that no plaintext copy is left
+func encryptBody(p body.Body, key []byte) (body.Body, error) {
+	in, err := os.Open(p.Path)
+	if err != nil {
+		return p, err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return p, err
+	}
+	out, err := os.CreateTemp("", "mocp-*"+e2e.Extension)
+	if err != nil {
+		return p, err
+	}
+	err = e2e.Encrypt(out, in, key, e2e.Metadata{Name: p.Filename, Size: info.Size()})
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return p, err
+	}
+	if p.DeleteAfterTransfer {
+		if err := p.Delete(); err != nil {
+			return p, err
+		}
+	}
+	return body.Body{
+		Filename:            "download" + e2e.Extension,
+		Path:                out.Name(),
+		DeleteAfterTransfer: true,
+	}, nil
+}
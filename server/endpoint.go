@@ -0,0 +1,51 @@
+package server
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/claudiodangelis/qrcp/pages"
+)
+
+// Endpoint is one of the addresses the server can be reached at
+type Endpoint struct {
+	// Interface is the name of the network interface
+	Interface string
+	// Address is the IP address of the interface
+	Address string
+	// URL is the landing page for clients connecting through the interface
+	URL string
+}
+
+// serveLanding renders a page telling the client which interface and address
+// it reached, with a link to the transfer
+func (s *Server) serveLanding(w http.ResponseWriter, r *http.Request, path string) {
+	htmlVariables := struct {
+		Interface string
+		Address   string
+		Route     string
+		Action    string
+		signatureVariables
+	}{
+		Interface:          "unknown",
+		Address:            requestBaseURL(r, s.basePath),
+		Route:              s.basePath + "/send/" + path,
+		Action:             "Download " + s.body.Filename,
+		signatureVariables: s.signatureInfo(path),
+	}
+	if s.outputDir != "" {
+		htmlVariables.Route = s.basePath + "/receive/" + path
+		htmlVariables.Action = "Upload files"
+	}
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+			htmlVariables.Address = tcpAddr.IP.String()
+			for _, e := range s.Endpoints {
+				if net.ParseIP(e.Address).Equal(tcpAddr.IP) {
+					htmlVariables.Interface = e.Interface
+				}
+			}
+		}
+	}
+	serveTemplate("landing", pages.Landing, w, htmlVariables)
+}
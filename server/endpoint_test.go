@@ -0,0 +1,55 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/claudiodangelis/qrcp/config"
+	"github.com/claudiodangelis/qrcp/util"
+)
+
+func TestAllLANEndpoints(t *testing.T) {
+	cfg := config.Config{Interface: util.AllLAN, KeepAlive: true}
+	s, err := New(&cfg)
+	if err != nil {
+		t.Skipf("no LAN interface: %v", err)
+	}
+	if len(s.Endpoints) == 0 {
+		t.Fatal("no endpoints listed")
+	}
+	if err := s.ReceiveTo(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	receive, _ := url.Parse(s.ReceiveURL)
+	client := &http.Client{Timeout: 5 * time.Second}
+	for _, e := range s.Endpoints {
+		u, err := url.Parse(e.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.Hostname() != e.Address || !strings.Contains(u.Path, "/start/") {
+			t.Errorf("%s: landing page %s isn't on %s", e.Interface, e.URL, e.Address)
+		}
+		// Each landing page names the interface it was reached through and
+		// links to the transfer
+		resp, err := client.Get(e.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: landing page = %s", e.Interface, resp.Status)
+		}
+		if !strings.Contains(string(body), "<b>"+e.Interface+"</b> interface") {
+			t.Errorf("%s: the landing page doesn't name the interface", e.Interface)
+		}
+		if !strings.Contains(string(body), `href="`+receive.Path+`"`) {
+			t.Errorf("%s: the landing page doesn't link to %s", e.Interface, receive.Path)
+		}
+	}
+}
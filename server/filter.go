@@ -0,0 +1,114 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+
+	"github.com/claudiodangelis/qrcp/config"
+	"github.com/claudiodangelis/qrcp/util"
+)
+
+// connFilter decides whether a connection is accepted based on the address
+// of the remote peer
+type connFilter struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// parseNetworks parses a list of CIDR blocks or plain IP addresses
+func parseNetworks(entries []string) ([]*net.IPNet, error) {
+	networks := []*net.IPNet{}
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address: %s", entry)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// newConnFilter builds the filter from the configuration. It returns nil
+// when no filtering is configured
+func newConnFilter(cfg *config.Config) (*connFilter, error) {
+	allow, err := parseNetworks(cfg.Allow)
+	if err != nil {
+		return nil, err
+	}
+	deny, err := parseNetworks(cfg.Deny)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.LanOnly {
+		lan, err := util.InterfaceNetworks(cfg.Interface)
+		if err != nil {
+			return nil, err
+		}
+		if len(lan) == 0 {
+			return nil, fmt.Errorf("no networks found for interface %s", cfg.Interface)
+		}
+		allow = append(allow, lan...)
+	}
+	if len(allow) == 0 && len(deny) == 0 {
+		return nil, nil
+	}
+	return &connFilter{allow: allow, deny: deny}, nil
+}
+
+// accepts reports whether a connection from addr is allowed
+func (f *connFilter) accepts(addr net.Addr) bool {
+	if f == nil {
+		return true
+	}
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	return f.acceptsIP(tcpAddr.IP)
+}
+
+// acceptsIP reports whether a client with the given address is allowed
+func (f *connFilter) acceptsIP(ip net.IP) bool {
+	if f == nil {
+		return true
+	}
+	for _, network := range f.deny {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	if len(f.allow) == 0 {
+		return true
+	}
+	for _, network := range f.allow {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// reject closes a connection refused by the filter and logs it
+func (f *connFilter) reject(conn net.Conn) {
+	log.Printf("Rejected connection from %s", conn.RemoteAddr())
+	conn.Close()
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/claudiodangelis/qrcp/config"
+)
+
+func TestConnFilter(t *testing.T) {
+	tests := []struct {
+		name   string
+		allow  []string
+		deny   []string
+		ip     string
+		accept bool
+	}{
+		{"no lists", nil, nil, "203.0.113.9", true},
+		{"allowed cidr", []string{"192.168.1.0/24"}, nil, "192.168.1.20", true},
+		{"outside the allowed cidr", []string{"192.168.1.0/24"}, nil, "192.168.2.20", false},
+		{"allowed ip", []string{"192.168.1.20"}, nil, "192.168.1.20", true},
+		{"other ip", []string{"192.168.1.20"}, nil, "192.168.1.21", false},
+		{"denied ip", nil, []string{"192.168.1.20"}, "192.168.1.20", false},
+		{"not denied", nil, []string{"192.168.1.20"}, "192.168.1.21", true},
+		{"deny before allow", []string{"192.168.1.0/24"}, []string{"192.168.1.20"}, "192.168.1.20", false},
+		{"allowed next to a denied ip", []string{"192.168.1.0/24"}, []string{"192.168.1.20"}, "192.168.1.21", true},
+		{"ipv6 cidr", []string{"2001:db8::/32"}, nil, "2001:db8::1", true},
+		{"outside the ipv6 cidr", []string{"2001:db8::/32"}, nil, "2001:db9::1", false},
+		{"ipv6 ip", []string{"2001:db8::1"}, nil, "2001:db8::1", true},
+		{"denied ipv6 ip", []string{"2001:db8::/32"}, []string{"2001:db8::1"}, "2001:db8::1", false},
+		{"ipv4 mapped ipv6", []string{"192.168.1.20"}, nil, "::ffff:192.168.1.20", true},
+		{"ipv4 list, ipv6 client", []string{"192.168.1.0/24"}, nil, "2001:db8::1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := newConnFilter(&config.Config{Allow: tt.allow, Deny: tt.deny})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := f.acceptsIP(net.ParseIP(tt.ip)); got != tt.accept {
+				t.Errorf("acceptsIP(%s) = %v, want %v", tt.ip, got, tt.accept)
+			}
+			if got := f.accepts(&net.TCPAddr{IP: net.ParseIP(tt.ip), Port: 1234}); got != tt.accept {
+				t.Errorf("accepts(%s) = %v, want %v", tt.ip, got, tt.accept)
+			}
+		})
+	}
+	if f, _ := newConnFilter(&config.Config{}); f != nil {
+		t.Error("newConnFilter() without lists should return no filter")
+	}
+	for _, entry := range []string{"192.168.1", "192.168.1.0/33", "example.com"} {
+		if _, err := parseNetworks([]string{entry}); err == nil {
+			t.Errorf("parseNetworks(%q) should fail", entry)
+		}
+	}
+	if networks, _ := parseNetworks([]string{" 10.0.0.1 ", ""}); len(networks) != 1 {
+		t.Errorf("parseNetworks() = %v, want one network", networks)
+	}
+}
+
+func TestConnFilterLanOnly(t *testing.T) {
+	f, err := newConnFilter(&config.Config{Interface: "lo", LanOnly: true, Deny: []string{"127.0.0.2"}})
+	if err != nil {
+		t.Skipf("no loopback interface named lo: %v", err)
+	}
+	if !f.acceptsIP(net.ParseIP("127.0.0.1")) {
+		t.Error("a client on the network of the interface was rejected")
+	}
+	if f.acceptsIP(net.ParseIP("127.0.0.2")) {
+		t.Error("a denied client on the network of the interface was accepted")
+	}
+	if f.acceptsIP(net.ParseIP("203.0.113.9")) {
+		t.Error("a client outside the network of the interface was accepted")
+	}
+	if f.accepts(&net.UnixAddr{Name: "@", Net: "unix"}) {
+		t.Error("a connection without an IP address was accepted")
+	}
+}
+
+func TestProxiesHandlerFilter(t *testing.T) {
+	filter, _ := newConnFilter(&config.Config{Allow: []string{"192.168.1.0/24"}})
+	p := &proxies{filter: filter, local: true}
+	handler := p.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	tests := []struct {
+		name      string
+		forwarded string
+		status    int
+	}{
+		{"allowed client", "192.168.1.20", http.StatusOK},
+		{"refused client", "203.0.113.9", http.StatusForbidden},
+		{"unknown client", "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		// Peers of a Unix socket have no address
+		r.RemoteAddr = "@"
+		if tt.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.status)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultRateLimit is the number of requests per second a single IP
+	// address is allowed to make when `rate-limit` is not configured
+	defaultRateLimit = 10
+	// tarpitStep is the delay added for each unknown path requested by an IP
+	tarpitStep = 500 * time.Millisecond
+	// tarpitMax caps the delay applied to a single unknown path request
+	tarpitMax = 10 * time.Second
+	// clientTTL is how long an idle client is remembered
+	clientTTL = 10 * time.Minute
+)
+
+// clientState keeps the rate limiting bucket and the count of unknown paths
+// requested by a single IP address
+type clientState struct {
+	tokens float64
+	last   time.Time
+	misses int
+}
+
+// guard rate limits requests per IP address and tarpits requests to unknown
+// paths, so that the random path can't be enumerated by brute force
+type guard struct {
+	next    *http.ServeMux
+	rate    float64
+	burst   float64
+	mu      sync.Mutex
+	clients map[string]*clientState
+	pruned  time.Time
+}
+
+// newGuard wraps mux. A zero limit uses defaultRateLimit, a negative limit
+// disables rate limiting but keeps the tarpit
+func newGuard(mux *http.ServeMux, limit int) *guard {
+	if limit == 0 {
+		limit = defaultRateLimit
+	}
+	return &guard{
+		next:    mux,
+		rate:    float64(limit),
+		burst:   float64(limit) * 4,
+		clients: make(map[string]*clientState),
+		pruned:  time.Now(),
+	}
+}
+
+// client returns the state for ip, creating it if needed. The caller must
+// hold g.mu
+func (g *guard) client(ip string, now time.Time) *clientState {
+	if now.Sub(g.pruned) > clientTTL {
+		for k, c := range g.clients {
+			if now.Sub(c.last) > clientTTL {
+				delete(g.clients, k)
+			}
+		}
+		g.pruned = now
+	}
+	c, ok := g.clients[ip]
+	if !ok {
+		c = &clientState{tokens: g.burst, last: now}
+		g.clients[ip] = c
+	}
+	return c
+}
+
+// allow consumes a token from the bucket of ip and reports whether the
+// request can proceed, along with the time to wait before retrying
+func (g *guard) allow(ip string) (bool, time.Duration) {
+	now := time.Now()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	c := g.client(ip, now)
+	if g.rate > 0 {
+		c.tokens += now.Sub(c.last).Seconds() * g.rate
+		if c.tokens > g.burst {
+			c.tokens = g.burst
+		}
+	}
+	c.last = now
+	if g.rate <= 0 {
+		return true, 0
+	}
+	if c.tokens < 1 {
+		return false, time.Duration((1 - c.tokens) / g.rate * float64(time.Second))
+	}
+	c.tokens--
+	return true, 0
+}
+
+// miss records a request to an unknown path and returns how long to stall it
+func (g *guard) miss(ip string) time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	c := g.client(ip, time.Now())
+	c.misses++
+	if c.misses == 10 {
+		log.Printf("Client %s requested %d unknown paths, slowing it down", ip, c.misses)
+	}
+	delay := time.Duration(c.misses) * tarpitStep
+	if delay > tarpitMax {
+		delay = tarpitMax
+	}
+	return delay
+}
+
+func (g *guard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ip := remoteIP(r)
+	if ok, retry := g.allow(ip); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(retry.Seconds())+1))
+		http.Error(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	if _, pattern := g.next.Handler(r); pattern == "" {
+		select {
+		case <-time.After(g.miss(ip)):
+		case <-r.Context().Done():
+			return
+		}
+		http.NotFound(w, r)
+		return
+	}
+	g.next.ServeHTTP(w, r)
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGuardRateLimit(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/known", func(w http.ResponseWriter, r *http.Request) {})
+	g := newGuard(mux, 1)
+	request := func(ip string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/known", nil)
+		r.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		g.ServeHTTP(w, r)
+		return w
+	}
+	// The burst is let through, the next request has to wait
+	for i := 0; i < 4; i++ {
+		if w := request("192.0.2.1"); w.Code != http.StatusOK {
+			t.Fatalf("request %d within the burst got status %d", i, w.Code)
+		}
+	}
+	w := request("192.0.2.1")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request over the burst got status %d", w.Code)
+	}
+	if w.Header().Get("Retry-After") != "1" {
+		t.Errorf("Retry-After = %q, want 1", w.Header().Get("Retry-After"))
+	}
+	// Other clients have their own bucket
+	if w := request("192.0.2.2"); w.Code != http.StatusOK {
+		t.Errorf("another client got status %d", w.Code)
+	}
+	// A negative limit disables rate limiting
+	g = newGuard(mux, -1)
+	for i := 0; i < 100; i++ {
+		if w := request("192.0.2.1"); w.Code != http.StatusOK {
+			t.Fatalf("request %d without rate limiting got status %d", i, w.Code)
+		}
+	}
+}
+
+func TestGuardTarpit(t *testing.T) {
+	g := newGuard(http.NewServeMux(), -1)
+	// Each unknown path stalls the client longer, up to tarpitMax
+	var last time.Duration
+	for i := 1; i <= 30; i++ {
+		delay := g.miss("192.0.2.1")
+		if delay < last || delay > tarpitMax {
+			t.Fatalf("miss %d delay = %v, previous %v", i, delay, last)
+		}
+		last = delay
+	}
+	if last != tarpitMax {
+		t.Errorf("delay after 30 misses = %v, want %v", last, tarpitMax)
+	}
+	if delay := g.miss("192.0.2.2"); delay != tarpitStep {
+		t.Errorf("first miss of another client delay = %v, want %v", delay, tarpitStep)
+	}
+	// An unknown path is answered with a 404 once the delay is over
+	r := httptest.NewRequest("GET", "/unknown", nil)
+	r.RemoteAddr = "192.0.2.3:1234"
+	w := httptest.NewRecorder()
+	start := time.Now()
+	g.ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound || time.Since(start) < tarpitStep {
+		t.Errorf("unknown path got status %d after %v", w.Code, time.Since(start))
+	}
+	// A client giving up isn't answered
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	w = httptest.NewRecorder()
+	start = time.Now()
+	g.ServeHTTP(w, r.WithContext(ctx))
+	if time.Since(start) >= 2*tarpitStep {
+		t.Errorf("the tarpit ignored the client going away for %v", time.Since(start))
+	}
+}
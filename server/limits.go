@@ -0,0 +1,112 @@
+package server
+
+import (
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/claudiodangelis/qrcp/config"
+)
+
+// Defaults used when the corresponding configuration value is zero. A
+// negative configuration value disables the limit
+const (
+	defaultReadHeaderTimeout  = 10 * time.Second
+	defaultIdleTimeout        = 60 * time.Second
+	defaultMaxHeaderBytes     = 64 << 10
+	defaultMaxConns           = 256
+	defaultMaxConnsPerIP      = 16
+	defaultUploadStallTimeout = 30 * time.Second
+)
+
+// orDefault returns d when v is zero, zero when v is negative and v otherwise
+func orDefault[T int | time.Duration](v, d T) T {
+	if v == 0 {
+		return d
+	}
+	if v < 0 {
+		return 0
+	}
+	return v
+}
+
+// connLimiter caps the number of open connections, in total and per IP
+type connLimiter struct {
+	max      int
+	maxPerIP int
+	mu       sync.Mutex
+	total    int
+	perIP    map[string]int
+}
+
+// newConnLimiter returns a limiter configured from cfg
+func newConnLimiter(cfg *config.Config) *connLimiter {
+	return &connLimiter{
+		max:      orDefault(cfg.MaxConns, defaultMaxConns),
+		maxPerIP: orDefault(cfg.MaxConnsPerIP, defaultMaxConnsPerIP),
+		perIP:    make(map[string]int),
+	}
+}
+
+// acquire reserves a slot for ip and reports whether the connection can be
+// accepted. An empty ip is only counted towards the total
+func (l *connLimiter) acquire(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.max > 0 && l.total >= l.max {
+		log.Printf("Rejected connection from %s: too many open connections", ip)
+		return false
+	}
+	if ip == "" {
+		l.total++
+		return true
+	}
+	if l.maxPerIP > 0 && l.perIP[ip] >= l.maxPerIP {
+		log.Printf("Rejected connection from %s: too many open connections from this address", ip)
+		return false
+	}
+	l.total++
+	l.perIP[ip]++
+	return true
+}
+
+// release frees the slot reserved for ip
+func (l *connLimiter) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.total--
+	if ip == "" {
+		return
+	}
+	if l.perIP[ip]--; l.perIP[ip] <= 0 {
+		delete(l.perIP, ip)
+	}
+}
+
+// limitedConn releases its slot in the limiter when closed
+type limitedConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+// Close closes the connection and releases its slot
+func (c *limitedConn) Close() error {
+	c.once.Do(c.release)
+	return c.Conn.Close()
+}
+
+// track acquires a slot for conn and wraps it so that the slot is released
+// on close. It returns nil if the connection is over the limits. The per-IP
+// limit is only applied if perIP is set
+func (l *connLimiter) track(conn net.Conn, perIP bool) net.Conn {
+	var ip string
+	if tcpAddr, ok := conn.RemoteAddr().(*net.TCPAddr); ok && perIP {
+		ip = tcpAddr.IP.String()
+	}
+	if !l.acquire(ip) {
+		return nil
+	}
+	return &limitedConn{Conn: conn, release: func() { l.release(ip) }}
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/claudiodangelis/qrcp/config"
+)
+
+func TestOrDefault(t *testing.T) {
+	tests := []struct {
+		v, d, want time.Duration
+	}{
+		{0, time.Second, time.Second},
+		{-1, time.Second, 0},
+		{time.Minute, time.Second, time.Minute},
+	}
+	for _, tt := range tests {
+		if got := orDefault(tt.v, tt.d); got != tt.want {
+			t.Errorf("orDefault(%v, %v) = %v, want %v", tt.v, tt.d, got, tt.want)
+		}
+	}
+	if got := orDefault(0, defaultMaxConns); got != defaultMaxConns {
+		t.Errorf("orDefault(0, %d) = %d", defaultMaxConns, got)
+	}
+}
+
+// addrConn is a connection from a given remote address
+type addrConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c addrConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func TestConnLimiter(t *testing.T) {
+	l := newConnLimiter(&config.Config{MaxConns: 3, MaxConnsPerIP: 2})
+	conn := func(ip string) net.Conn {
+		c, _ := net.Pipe()
+		return addrConn{c, &net.TCPAddr{IP: net.ParseIP(ip), Port: 1234}}
+	}
+	first := l.track(conn("192.0.2.1"), true)
+	if first == nil || l.track(conn("192.0.2.1"), true) == nil {
+		t.Fatal("connections within the cap were refused")
+	}
+	if l.track(conn("192.0.2.1"), true) != nil {
+		t.Error("a connection over the per-IP cap was accepted")
+	}
+	// Without the per-IP cap only the total counts
+	if l.track(conn("192.0.2.1"), false) == nil {
+		t.Error("a connection within the total cap was refused")
+	}
+	if l.track(conn("192.0.2.2"), true) != nil {
+		t.Error("a connection over the total cap was accepted")
+	}
+	// Closing twice releases the slot once
+	first.Close()
+	first.Close()
+	if l.total != 2 || l.perIP["192.0.2.1"] != 1 {
+		t.Errorf("after close: %d open, %d from the address", l.total, l.perIP["192.0.2.1"])
+	}
+	if l.track(conn("192.0.2.2"), true) == nil {
+		t.Error("a connection was refused after a slot was released")
+	}
+	// Negative values disable the caps
+	l = newConnLimiter(&config.Config{MaxConns: -1, MaxConnsPerIP: -1})
+	for i := 0; i < defaultMaxConns+1; i++ {
+		if l.track(conn("192.0.2.1"), true) == nil {
+			t.Fatalf("connection %d refused without caps", i)
+		}
+	}
+}
+
+// dialServer opens a connection to the server of s
+func dialServer(t *testing.T, s *Server) net.Conn {
+	t.Helper()
+	u, err := url.Parse(s.ReceiveURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("tcp", u.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// closedWithin reports whether the server closes conn within d
+func closedWithin(conn net.Conn, d time.Duration) bool {
+	conn.SetReadDeadline(time.Now().Add(d))
+	_, err := io.Copy(io.Discard, conn)
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return false
+	}
+	return true
+}
+
+func TestServerLimits(t *testing.T) {
+	cfg := config.Config{
+		Interface:         "lo",
+		KeepAlive:         true,
+		ReadHeaderTimeout: 200 * time.Millisecond,
+		IdleTimeout:       200 * time.Millisecond,
+	}
+	s, err := New(&cfg)
+	if err != nil {
+		t.Skipf("no loopback interface named lo: %v", err)
+	}
+	dir := t.TempDir()
+	if err := s.ReceiveTo(dir); err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(s.ReceiveURL)
+	// A client that doesn't finish its headers is dropped
+	slow := dialServer(t, s)
+	fmt.Fprintf(slow, "GET %s HTTP/1.1\r\n", u.Path)
+	if !closedWithin(slow, 3*time.Second) {
+		t.Error("a client stalling in the headers was kept")
+	}
+	// An idle keep-alive connection is closed
+	idle := dialServer(t, s)
+	fmt.Fprintf(idle, "GET %s HTTP/1.1\r\nHost: %s\r\n\r\n", u.Path, u.Host)
+	resp, err := http.ReadResponse(bufio.NewReader(idle), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if !closedWithin(idle, 3*time.Second) {
+		t.Error("an idle connection was kept")
+	}
+	// The third connection from the same address is refused, the others
+	// are kept open
+	cfg = config.Config{Interface: "lo", KeepAlive: true, MaxConnsPerIP: 2}
+	if s, err = New(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ReceiveTo(dir); err != nil {
+		t.Fatal(err)
+	}
+	first := dialServer(t, s)
+	dialServer(t, s)
+	if !closedWithin(dialServer(t, s), time.Second) {
+		t.Error("a connection over the per-IP cap was kept")
+	}
+	if closedWithin(first, 200*time.Millisecond) {
+		t.Error("a connection within the per-IP cap was closed")
+	}
+}
+
+func TestReceiveStalledUpload(t *testing.T) {
+	cfg := config.Config{Interface: "lo", KeepAlive: true, UploadStallTimeout: 200 * time.Millisecond}
+	s, err := New(&cfg)
+	if err != nil {
+		t.Skipf("no loopback interface named lo: %v", err)
+	}
+	dir := t.TempDir()
+	if err := s.ReceiveTo(dir); err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(s.ReceiveURL)
+	conn := dialServer(t, s)
+	part := "--boundary\r\n" +
+		"Content-Disposition: form-data; name=\"files\"; filename=\"stalled.txt\"\r\n\r\n" +
+		"the first half of the file"
+	fmt.Fprintf(conn, "POST %s HTTP/1.1\r\nHost: %s\r\nContent-Type: multipart/form-data; boundary=boundary\r\nContent-Length: 100000\r\n\r\n%s", u.Path, u.Host, part)
+	// The client stops sending, the upload is aborted
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestTimeout {
+		t.Errorf("stalled upload = %s", resp.Status)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "stalled.txt")); !os.IsNotExist(err) {
+		t.Error("the partial file was kept")
+	}
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenSocket creates the listeners for the `listen` configuration value,
+// either `unix:<path>` for a Unix domain socket or `systemd` for the sockets
+// passed by systemd socket activation
+func listenSocket(spec string) ([]net.Listener, error) {
+	if path, ok := strings.CutPrefix(spec, "unix:"); ok {
+		if path == "" {
+			return nil, errors.New("missing path of the Unix socket")
+		}
+		// Remove a stale socket left behind by a previous run
+		if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			if err := os.Remove(path); err != nil {
+				return nil, err
+			}
+		}
+		listener, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{listener}, nil
+	}
+	if spec == "systemd" {
+		return systemdListeners()
+	}
+	return nil, fmt.Errorf("invalid listen address %q, use unix:<path> or systemd", spec)
+}
+
+// isSocketListen reports whether the `listen` configuration value replaces
+// the network interface
+func isSocketListen(spec string) bool {
+	return spec != ""
+}
+
+// systemdListeners returns the listeners passed by systemd, see
+// sd_listen_fds(3). File descriptors start at 3
+func systemdListeners() ([]net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, errors.New("no sockets passed by systemd (LISTEN_PID is not set for this process)")
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count < 1 {
+		return nil, errors.New("no sockets passed by systemd (LISTEN_FDS is not set)")
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	// Don't pass the sockets on to child processes
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	listeners := []net.Listener{}
+	for i := 0; i < count; i++ {
+		name := "LISTEN_FD_" + strconv.Itoa(3+i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		file := os.NewFile(uintptr(3+i), name)
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("socket %s: %w", name, err)
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// socketListener applies the connection caps and the PROXY protocol to
+// listeners other than TCP ones, such as Unix domain sockets
+type socketListener struct {
+	net.Listener
+	filter        *connFilter
+	limits        *connLimiter
+	proxyProtocol bool
+}
+
+// Accept waits for the next connection within the connection caps
+func (ln socketListener) Accept() (net.Conn, error) {
+	for {
+		c, err := ln.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		conn := ln.limits.track(c, false)
+		if conn == nil {
+			c.Close()
+			continue
+		}
+		if ln.proxyProtocol {
+			return newProxyConn(conn, ln.filter), nil
+		}
+		return conn, nil
+	}
+}
+
+// wrapListener applies keepalives, filters and caps to a listener
+func wrapListener(listener net.Listener, filter *connFilter, limits *connLimiter, p *proxies, proxyProtocol bool) net.Listener {
+	if tcp, ok := listener.(*net.TCPListener); ok {
+		return tcpKeepAliveListener{tcp, filter, limits, p, proxyProtocol}
+	}
+	return socketListener{listener, filter, limits, proxyProtocol}
+}
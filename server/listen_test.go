@@ -0,0 +1,109 @@
+package server
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// socketDir returns a directory whose path is short enough for Unix sockets
+func socketDir(t *testing.T) string {
+	dir, err := os.MkdirTemp("", "mocp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func TestListenUnixSocket(t *testing.T) {
+	dir := socketDir(t)
+	// A stale socket left behind by a previous run is replaced
+	path := filepath.Join(dir, "stale.sock")
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Skipf("no Unix sockets: %v", err)
+	}
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+	listeners, err := listenSocket("unix:" + path)
+	if err != nil {
+		t.Fatalf("listenSocket() with a stale socket: %v", err)
+	}
+	listeners[0].Close()
+	// Any other file is left alone
+	path = filepath.Join(dir, "file")
+	os.WriteFile(path, []byte("data"), 0644)
+	if _, err := listenSocket("unix:" + path); err == nil {
+		t.Error("listenSocket() replaced a regular file")
+	}
+	if b, _ := os.ReadFile(path); string(b) != "data" {
+		t.Error("listenSocket() removed a regular file")
+	}
+	for _, spec := range []string{"unix:", "tcp:localhost:8080"} {
+		if _, err := listenSocket(spec); err == nil {
+			t.Errorf("listenSocket(%q) should fail", spec)
+		}
+	}
+}
+
+func TestSystemdListeners(t *testing.T) {
+	tests := []struct {
+		name string
+		pid  string
+		fds  string
+	}{
+		{"not set", "", ""},
+		{"another process", strconv.Itoa(os.Getpid() + 1), "1"},
+		{"no sockets", strconv.Itoa(os.Getpid()), "0"},
+		{"invalid count", strconv.Itoa(os.Getpid()), "many"},
+	}
+	for _, tt := range tests {
+		t.Setenv("LISTEN_PID", tt.pid)
+		t.Setenv("LISTEN_FDS", tt.fds)
+		if _, err := systemdListeners(); err == nil {
+			t.Errorf("%s: systemdListeners() should fail", tt.name)
+		}
+	}
+}
+
+// TestSystemdListenersHelper runs in the process started by
+// TestSystemdListenersActivation, which passes it a socket as fd 3
+func TestSystemdListenersHelper(t *testing.T) {
+	if os.Getenv("MOCP_TEST_SYSTEMD") != "1" {
+		t.Skip("only run by TestSystemdListenersActivation")
+	}
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	listeners, err := systemdListeners()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listeners) != 1 {
+		t.Fatalf("%d listeners, want 1", len(listeners))
+	}
+	if os.Getenv("LISTEN_FDS") != "" {
+		t.Error("LISTEN_FDS is passed on to child processes")
+	}
+}
+
+func TestSystemdListenersActivation(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("no loopback: %v", err)
+	}
+	defer listener.Close()
+	file, err := listener.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	cmd := exec.Command(os.Args[0], "-test.run=^TestSystemdListenersHelper$")
+	cmd.Env = append(os.Environ(), "MOCP_TEST_SYSTEMD=1", "LISTEN_FDS=1", "LISTEN_FDNAMES=http")
+	cmd.ExtraFiles = []*os.File{file}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/claudiodangelis/qrcp/discovery"
+	"github.com/claudiodangelis/qrcp/util"
+)
+
+// advertiseIPs returns the addresses to announce for the given bind
+// addresses. The unspecified address is replaced with the addresses of all
+// the suitable interfaces
+func advertiseIPs(binds []string) []net.IP {
+	ips := []net.IP{}
+	for _, bind := range binds {
+		ip := net.ParseIP(bind)
+		if ip == nil {
+			continue
+		}
+		if !ip.IsUnspecified() {
+			ips = append(ips, ip)
+			continue
+		}
+		interfaces, err := util.Interfaces(false)
+		if err != nil {
+			continue
+		}
+		for _, address := range interfaces {
+			if ip := net.ParseIP(address); ip != nil {
+				ips = append(ips, ip)
+			}
+		}
+	}
+	return ips
+}
+
+// advertise announces the share via multicast DNS, if enabled. The URL
+// holds the secret token, it's only announced with --mdns-url
+func (s *Server) advertise(mode string) {
+	if !s.mdns {
+		return
+	}
+	share := discovery.Share{
+		Mode: mode,
+		PIN:  s.approvals != nil,
+		Port: s.port,
+	}
+	if s.mdnsURL {
+		share.URL = s.ReceiveURL
+		if mode == "send" {
+			share.URL = s.SendURL
+			share.Filename = s.body.Filename
+			if fi, err := os.Stat(s.body.Path); err == nil {
+				share.Size = fi.Size()
+			}
+		}
+	}
+	advertiser, err := discovery.Advertise(share, s.mdnsIPs)
+	if err != nil {
+		ShowWarning(fmt.Sprintf("Unable to advertise the share on the local network: %v", err))
+		return
+	}
+	s.advertiser = advertiser
+}
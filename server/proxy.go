@@ -0,0 +1,331 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxyHeaderTimeout bounds the time a client has to send the PROXY header
+const proxyHeaderTimeout = 10 * time.Second
+
+// proxyV2Signature starts every PROXY protocol v2 header
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// normalizeBasePath returns the base path with a leading slash and without
+// a trailing one, or an empty string for the root
+func normalizeBasePath(basePath string) string {
+	basePath = strings.Trim(basePath, "/")
+	if basePath == "" {
+		return ""
+	}
+	return "/" + basePath
+}
+
+// proxies describes the reverse proxies in front of the server
+type proxies struct {
+	trusted []*net.IPNet
+	filter  *connFilter
+	// local is set when listening on a Unix socket, whose peers are trusted
+	local bool
+}
+
+// fromSocket reports whether addr is the peer of a Unix socket
+func (p *proxies) fromSocket(addr string) bool {
+	return p.local && (addr == "" || addr == "@")
+}
+
+// trusts reports whether the request was forwarded by a trusted proxy
+func (p *proxies) trusts(addr string) bool {
+	if p.fromSocket(addr) {
+		return true
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	for _, network := range p.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedFor returns the client address from the Forwarded or
+// X-Forwarded-For headers, skipping the trusted proxies from the right. It
+// also returns the index of the hop of the client, or -1
+func (p *proxies) forwardedFor(r *http.Request) (string, int) {
+	var hops []string
+	if elements := forwardedElements(r); len(elements) > 0 {
+		for _, element := range elements {
+			hops = append(hops, forwardedParam(element, "for"))
+		}
+	} else {
+		for _, value := range strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				hops = append(hops, value)
+			}
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		host := strings.Trim(hops[i], "[]")
+		if h, _, err := net.SplitHostPort(hops[i]); err == nil {
+			host = h
+		}
+		if net.ParseIP(host) == nil {
+			continue
+		}
+		if i == 0 || !p.trusts(host) {
+			return host, i
+		}
+	}
+	return "", -1
+}
+
+// forwardedElements returns the elements of the Forwarded headers, one per
+// hop
+func forwardedElements(r *http.Request) []string {
+	forwarded := r.Header.Values("Forwarded")
+	if len(forwarded) == 0 {
+		return nil
+	}
+	return strings.Split(strings.Join(forwarded, ","), ",")
+}
+
+// lastValue returns the last value of a comma separated header, the one
+// added by the nearest proxy
+func lastValue(values []string) string {
+	values = strings.Split(strings.Join(values, ","), ",")
+	return strings.TrimSpace(values[len(values)-1])
+}
+
+// forwardedParam returns the value of a parameter of a Forwarded element
+func forwardedParam(element, name string) string {
+	for _, pair := range strings.Split(element, ";") {
+		key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if ok && strings.EqualFold(key, name) {
+			return strings.Trim(value, `"`)
+		}
+	}
+	return ""
+}
+
+// Handler rewrites requests forwarded by a trusted proxy, so that the rest of
+// the server sees the address of the client, the public host and the public
+// scheme. Clients refused by the allow and deny lists are rejected here too,
+// as the listener only sees the address of the proxy
+func (p *proxies) Handler(next http.Handler) http.Handler {
+	if p == nil || (len(p.trusted) == 0 && !p.local) {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !p.trusts(r.RemoteAddr) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		client, hop := p.forwardedFor(r)
+		if client != "" {
+			r.RemoteAddr = net.JoinHostPort(client, "0")
+			if !p.filter.acceptsIP(net.ParseIP(client)) {
+				log.Printf("Rejected request from %s", client)
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+		} else if p.filter != nil && p.fromSocket(r.RemoteAddr) {
+			// Nothing filtered the peers of the socket, refuse the requests
+			// whose client is unknown rather than letting them through
+			log.Printf("Rejected request without a forwarded client address")
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		// The host and the scheme are those of the element added by the
+		// proxy the client connected to, the elements on its left were
+		// written by the client
+		var host, proto string
+		if elements := forwardedElements(r); len(elements) > 0 {
+			element := elements[len(elements)-1]
+			if hop >= 0 {
+				element = elements[hop]
+			}
+			host = forwardedParam(element, "host")
+			proto = forwardedParam(element, "proto")
+		}
+		if host == "" {
+			host = lastValue(r.Header.Values("X-Forwarded-Host"))
+		}
+		if proto == "" {
+			proto = lastValue(r.Header.Values("X-Forwarded-Proto"))
+		}
+		if host != "" {
+			r.Host = host
+		}
+		if proto != "" {
+			r.URL.Scheme = proto
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestBaseURL returns the URL the client used to reach the server,
+// including the base path
+func requestBaseURL(r *http.Request, basePath string) string {
+	scheme := r.URL.Scheme
+	if scheme == "" {
+		scheme = "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, r.Host, basePath)
+}
+
+// proxyConn is a connection starting with a PROXY protocol header. The
+// header is parsed on first use, in the goroutine serving the connection
+type proxyConn struct {
+	net.Conn
+	reader   *bufio.Reader
+	once     sync.Once
+	source   net.Addr
+	err      error
+	filter   *connFilter
+	accepted bool
+}
+
+func newProxyConn(conn net.Conn, filter *connFilter) *proxyConn {
+	return &proxyConn{Conn: conn, reader: bufio.NewReader(conn), filter: filter}
+}
+
+// init reads the header, then applies the allow and deny lists to the
+// address of the client
+func (c *proxyConn) init() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+		c.source, c.err = readProxyHeader(c.reader)
+		c.Conn.SetReadDeadline(time.Time{})
+		if c.err != nil {
+			log.Printf("Invalid PROXY header from %s: %v", c.Conn.RemoteAddr(), c.err)
+			c.Conn.Close()
+			return
+		}
+		if c.source == nil {
+			c.source = c.Conn.RemoteAddr()
+		}
+		if !c.filter.accepts(c.source) {
+			log.Printf("Rejected connection from %s", c.source)
+			c.err = errors.New("connection refused")
+			c.Conn.Close()
+		}
+	})
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns the address of the client as reported by the proxy
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.init()
+	if c.source != nil {
+		return c.source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// readProxyHeader parses a PROXY protocol v1 or v2 header. It returns a nil
+// address for connections the proxy made on its own (LOCAL or UNKNOWN)
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	peek, err := r.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(peek, proxyV2Signature) {
+		return readProxyHeaderV2(r)
+	}
+	if !bytes.HasPrefix(peek, []byte("PROXY ")) {
+		return nil, errors.New("missing PROXY header")
+	}
+	return readProxyHeaderV1(r)
+}
+
+// readProxyHeaderV1 parses a header such as
+// "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n"
+func readProxyHeaderV1(r *bufio.Reader) (net.Addr, error) {
+	line := make([]byte, 0, 107)
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) >= 107 {
+			return nil, errors.New("PROXY v1 header too long")
+		}
+	}
+	fields := strings.Fields(strings.TrimSuffix(string(line), "\r\n"))
+	if len(fields) < 2 {
+		return nil, errors.New("malformed PROXY v1 header")
+	}
+	if fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errors.New("malformed PROXY v1 header")
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.Atoi(fields[4])
+	if ip == nil || err != nil || port < 0 || port > 65535 {
+		return nil, errors.New("malformed PROXY v1 addresses")
+	}
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+// readProxyHeaderV2 parses a binary header
+func readProxyHeaderV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if header[12]>>4 != 2 {
+		return nil, errors.New("unsupported PROXY protocol version")
+	}
+	body := make([]byte, binary.BigEndian.Uint16(header[14:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	// LOCAL command: the connection was made by the proxy itself
+	if header[12]&0x0f == 0 {
+		return nil, nil
+	}
+	switch header[13] {
+	case 0x11: // TCP over IPv4
+		if len(body) < 12 {
+			return nil, errors.New("short PROXY v2 addresses")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:]))}, nil
+	case 0x21: // TCP over IPv6
+		if len(body) < 36 {
+			return nil, errors.New("short PROXY v2 addresses")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:]))}, nil
+	}
+	return nil, nil
+}
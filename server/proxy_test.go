@@ -0,0 +1,101 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadProxyHeader(t *testing.T) {
+	v2 := append([]byte{}, proxyV2Signature...)
+	v2 = append(v2, 0x21, 0x11, 0x00, 0x0c, 203, 0, 113, 9, 10, 0, 0, 1, 0xdc, 0x04, 0x01, 0xbb)
+	tests := []struct {
+		name   string
+		header []byte
+		want   string
+	}{
+		{"v1 tcp4", []byte("PROXY TCP4 203.0.113.9 10.0.0.1 56324 443\r\n"), "203.0.113.9:56324"},
+		{"v1 tcp6", []byte("PROXY TCP6 2001:db8::9 2001:db8::1 56324 443\r\n"), "[2001:db8::9]:56324"},
+		{"v1 unknown", []byte("PROXY UNKNOWN\r\n"), ""},
+		{"v2 tcp4", v2, "203.0.113.9:56324"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bufio.NewReader(bytes.NewReader(append(tt.header, "GET / HTTP/1.1\r\n"...)))
+			addr, err := readProxyHeader(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := ""
+			if addr != nil {
+				got = addr.String()
+			}
+			if got != tt.want {
+				t.Errorf("readProxyHeader() = %q, want %q", got, tt.want)
+			}
+			if rest, _ := r.ReadString('\n'); rest != "GET / HTTP/1.1\r\n" {
+				t.Errorf("readProxyHeader() consumed the request, left %q", rest)
+			}
+		})
+	}
+	if _, err := readProxyHeader(bufio.NewReader(bytes.NewReader([]byte("GET / HTTP/1.1\r\n")))); err == nil {
+		t.Error("readProxyHeader() should fail without a header")
+	}
+}
+
+func TestForwardedFor(t *testing.T) {
+	_, trusted, _ := net.ParseCIDR("10.0.0.0/8")
+	p := &proxies{trusted: []*net.IPNet{trusted}}
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		want   string
+	}{
+		{"x-forwarded-for", "X-Forwarded-For", "198.51.100.1, 10.0.0.2", "198.51.100.1"},
+		{"spoofed", "X-Forwarded-For", "1.2.3.4, 198.51.100.1, 10.0.0.2", "198.51.100.1"},
+		{"forwarded", "Forwarded", `for="[2001:db8::1]:4711";proto=https, for=10.0.0.2`, "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set(tt.header, tt.value)
+			if got, _ := p.forwardedFor(r); got != tt.want {
+				t.Errorf("forwardedFor() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxiesHandlerHost(t *testing.T) {
+	_, trusted, _ := net.ParseCIDR("10.0.0.0/8")
+	p := &proxies{trusted: []*net.IPNet{trusted}}
+	tests := []struct {
+		name      string
+		forwarded string
+		host      string
+		scheme    string
+	}{
+		{"one proxy", "for=198.51.100.1;host=files.lab;proto=https", "files.lab", "https"},
+		{"spoofed by the client", "for=1.2.3.4;host=evil.example;proto=http, for=198.51.100.1;host=files.lab;proto=https", "files.lab", "https"},
+		{"two proxies", "for=198.51.100.1;host=files.lab;proto=https, for=10.0.0.2;host=internal:8080;proto=http", "files.lab", "https"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var host, scheme string
+			handler := p.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				host, scheme = r.Host, r.URL.Scheme
+			}))
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = "10.0.0.1:1234"
+			r.Header.Set("Forwarded", tt.forwarded)
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+			if host != tt.host || scheme != tt.scheme {
+				t.Errorf("host %q, scheme %q, want %q, %q", host, scheme, tt.host, tt.scheme)
+			}
+		})
+	}
+}
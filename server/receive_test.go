@@ -0,0 +1,113 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/claudiodangelis/qrcp/config"
+)
+
+// abortUpload starts uploading name to rawurl and drops the connection
+// halfway through the file
+func abortUpload(t *testing.T, rawurl string, name string) {
+	t.Helper()
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("tcp", u.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	part := "--boundary\r\n" +
+		fmt.Sprintf("Content-Disposition: form-data; name=\"files\"; filename=%q\r\n\r\n", name) +
+		"the first half of the file"
+	fmt.Fprintf(conn, "POST %s HTTP/1.1\r\nHost: %s\r\nContent-Type: multipart/form-data; boundary=boundary\r\nContent-Length: 100000\r\n\r\n%s", u.Path, u.Host, part)
+	// Give the server the time to start writing the file
+	time.Sleep(100 * time.Millisecond)
+}
+
+func TestReceiveKeepsServingAfterFailedUploads(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.Config{Interface: "lo", KeepAlive: true}
+	s, err := New(&cfg)
+	if err != nil {
+		t.Skipf("no loopback interface named lo: %v", err)
+	}
+	if err := s.ReceiveTo(dir); err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Timeout: 5 * time.Second}
+	// A body that isn't multipart is refused, the server goes on
+	resp, err := client.Post(s.ReceiveURL, "text/plain", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("malformed upload = %s", resp.Status)
+	}
+	// An aborted upload leaves nothing behind
+	abortUpload(t, s.ReceiveURL, "aborted.txt")
+	for i := 0; ; i++ {
+		if _, err := os.Stat(filepath.Join(dir, "aborted.txt")); os.IsNotExist(err) {
+			break
+		}
+		if i == 50 {
+			t.Fatal("the partial file was kept")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	resp, err = client.Get(s.ReceiveURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("upload page after failed uploads = %s", resp.Status)
+	}
+}
+
+func TestReceiveAbortedScan(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no shell")
+	}
+	dir := t.TempDir()
+	// The scanner writes its process ID, then reads the file
+	pidFile := filepath.Join(t.TempDir(), "scanner.pid")
+	script := filepath.Join(t.TempDir(), "scanner.sh")
+	os.WriteFile(script, []byte("#!/bin/sh\necho $$ > "+pidFile+"\nexec cat > /dev/null\n"), 0755)
+	cfg := config.Config{Interface: "lo", KeepAlive: true, Scan: "command:" + script, Quarantine: filepath.Join(t.TempDir(), "quarantine")}
+	s, err := New(&cfg)
+	if err != nil {
+		t.Skipf("no loopback interface named lo: %v", err)
+	}
+	if err := s.ReceiveTo(dir); err != nil {
+		t.Fatal(err)
+	}
+	abortUpload(t, s.ReceiveURL, "aborted.txt")
+	var pid int
+	for i := 0; ; i++ {
+		b, _ := os.ReadFile(pidFile)
+		pid, _ = strconv.Atoi(strings.TrimSpace(string(b)))
+		// Signal 0 only checks that the process exists
+		if proc, err := os.FindProcess(pid); pid != 0 && (err != nil || proc.Signal(syscall.Signal(0)) != nil) {
+			break
+		}
+		if i == 50 {
+			t.Fatalf("the scanner %d is still running", pid)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
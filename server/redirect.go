@@ -0,0 +1,119 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// recordTypeHandshake is the first byte of every TLS session
+const recordTypeHandshake = 0x16
+
+// redirectTimeout bounds the time to read a plain HTTP request
+const redirectTimeout = 10 * time.Second
+
+// errRedirected ends the TLS handshake of plain HTTP connections, which
+// have been answered with a redirect
+var errRedirected = errors.New("redirected plain HTTP request to HTTPS")
+
+// redirectListener lets a TLS listener answer plain HTTP requests with a
+// redirect to the HTTPS URL on the same port
+type redirectListener struct {
+	net.Listener
+	// host is the configured host and port of the server. The Host header
+	// of the request is not trusted, it would make an open redirect
+	host string
+}
+
+// Accept waits for the next connection
+func (ln redirectListener) Accept() (net.Conn, error) {
+	conn, err := ln.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &redirectConn{Conn: conn, reader: bufio.NewReader(conn), host: ln.host}, nil
+}
+
+// redirectConn sniffs the first byte of the connection, TLS sessions are
+// passed through while plain HTTP requests are redirected
+type redirectConn struct {
+	net.Conn
+	reader *bufio.Reader
+	host   string
+	once   sync.Once
+	err    error
+	// deadline is the read deadline set by the HTTP server, such as the
+	// one of the TLS handshake, restored once the connection is sniffed
+	mu       sync.Mutex
+	deadline time.Time
+}
+
+// init sniffs the connection and redirects plain HTTP requests
+func (c *redirectConn) init() {
+	c.once.Do(func() {
+		sniff := time.Now().Add(redirectTimeout)
+		if deadline := c.readDeadline(); !deadline.IsZero() && deadline.Before(sniff) {
+			sniff = deadline
+		}
+		c.Conn.SetReadDeadline(sniff)
+		defer func() { c.Conn.SetReadDeadline(c.readDeadline()) }()
+		first, err := c.reader.Peek(1)
+		if err != nil || first[0] == recordTypeHandshake {
+			return
+		}
+		c.err = errRedirected
+		defer c.Conn.Close()
+		r, err := http.ReadRequest(c.reader)
+		if err != nil {
+			return
+		}
+		location := "https://" + c.host + r.URL.RequestURI()
+		fmt.Fprintf(c.Conn, "HTTP/1.1 301 Moved Permanently\r\nLocation: %s\r\nContent-Length: 0\r\nConnection: close\r\n\r\n", location)
+	})
+}
+
+// readDeadline returns the read deadline last set by the HTTP server
+func (c *redirectConn) readDeadline() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.deadline
+}
+
+func (c *redirectConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline = t
+	c.mu.Unlock()
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *redirectConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline = t
+	c.mu.Unlock()
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *redirectConn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+// redirectLogFilter drops the handshake errors of redirected connections
+// from the log of the HTTP server
+type redirectLogFilter struct{}
+
+func (redirectLogFilter) Write(p []byte) (int, error) {
+	if bytes.Contains(p, []byte(errRedirected.Error())) {
+		return len(p), nil
+	}
+	return log.Writer().Write(p)
+}
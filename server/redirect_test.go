@@ -0,0 +1,69 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// startRedirectServer starts an HTTPS server answering plain HTTP with a
+// redirect to host
+func startRedirectServer(t *testing.T, host string) *httptest.Server {
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	s.Listener = redirectListener{s.Listener, host}
+	s.Config.ReadHeaderTimeout = 200 * time.Millisecond
+	s.StartTLS()
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestRedirectLocation(t *testing.T) {
+	s := startRedirectServer(t, "192.0.2.1:8443")
+	conn, err := net.Dial("tcp", s.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// The Host header of the client is ignored
+	fmt.Fprintf(conn, "GET /send/abc?x=1 HTTP/1.1\r\nHost: evil.example\r\n\r\n")
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMovedPermanently {
+		t.Errorf("plain HTTP request = %s", resp.Status)
+	}
+	if got := resp.Header.Get("Location"); got != "https://192.0.2.1:8443/send/abc?x=1" {
+		t.Errorf("redirected to %q", got)
+	}
+	// TLS clients are served
+	resp, err = s.Client().Get(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("HTTPS request = %s", resp.Status)
+	}
+}
+
+func TestRedirectStalledHandshake(t *testing.T) {
+	s := startRedirectServer(t, "192.0.2.1:8443")
+	conn, err := net.Dial("tcp", s.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// A client starting a TLS handshake and going silent is dropped once
+	// the handshake times out
+	conn.Write([]byte{recordTypeHandshake})
+	if !closedWithin(conn, 3*time.Second) {
+		t.Error("a client stalling in the TLS handshake was kept")
+	}
+}
@@ -0,0 +1,213 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"image/jpeg"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/claudiodangelis/qrcp/pages"
+	"github.com/claudiodangelis/qrcp/qr"
+	"github.com/claudiodangelis/qrcp/util"
+)
+
+// minRotation is the shortest period of rotating QR codes, leaving time to
+// scan them
+const minRotation = 5 * time.Second
+
+// joinCookie carries the session of the clients that joined through a
+// rotating QR code
+const joinCookie = "mocp-session"
+
+// joinedLifetime is how long the session of a client that joined is kept
+// without being used
+const joinedLifetime = 10 * time.Minute
+
+// joinCodeLength is the length of the code in the URL of a rotating QR
+// code, 130 bits
+const joinCodeLength = 26
+
+// codeEncoding encodes the codes of rotating QR codes
+var codeEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// rotation derives the code in the URL of the QR code from the time, like
+// TOTP, so that a photo of the QR code stops working after a while. Clients
+// that joined with a valid code keep their session with a cookie
+type rotation struct {
+	key    []byte
+	period time.Duration
+	mu     sync.Mutex
+	// joined maps the sessions of the clients to their last use
+	joined map[string]time.Time
+}
+
+func newRotation(period time.Duration) (*rotation, error) {
+	if period < minRotation {
+		return nil, fmt.Errorf("QR codes can't rotate faster than every %s", minRotation)
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return &rotation{key: key, period: period, joined: make(map[string]time.Time)}, nil
+}
+
+// step returns the number of the period at t
+func (r *rotation) step(t time.Time) int64 {
+	return t.UnixNano() / int64(r.period)
+}
+
+// code returns the code of the period step
+func (r *rotation) code(step int64) string {
+	mac := hmac.New(sha256.New, r.key)
+	binary.Write(mac, binary.BigEndian, step)
+	return codeEncoding.EncodeToString(mac.Sum(nil))[:joinCodeLength]
+}
+
+// valid reports whether code is the one of the current period, or of the
+// previous one for those who scanned right before it rotated
+func (r *rotation) valid(code string, now time.Time) bool {
+	step := r.step(now)
+	return hmac.Equal([]byte(code), []byte(r.code(step))) || hmac.Equal([]byte(code), []byte(r.code(step-1)))
+}
+
+// next returns when the code rotates after now
+func (r *rotation) next(now time.Time) time.Time {
+	return time.Unix(0, (r.step(now)+1)*int64(r.period))
+}
+
+// member reports whether the request comes from a client that joined
+func (r *rotation) member(req *http.Request) bool {
+	cookie, err := req.Cookie(joinCookie)
+	if err != nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	last, ok := r.joined[cookie.Value]
+	if !ok || now.Sub(last) > joinedLifetime {
+		return false
+	}
+	r.joined[cookie.Value] = now
+	return true
+}
+
+// prune forgets the sessions unused for longer than joinedLifetime. The
+// caller must hold r.mu
+func (r *rotation) prune(now time.Time) {
+	for value, last := range r.joined {
+		if now.Sub(last) > joinedLifetime {
+			delete(r.joined, value)
+		}
+	}
+}
+
+// join starts the session of a client
+func (r *rotation) join(w http.ResponseWriter) error {
+	value, err := util.GetSessionID()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	r.mu.Lock()
+	r.prune(now)
+	r.joined[value] = now
+	r.mu.Unlock()
+	http.SetCookie(w, &http.Cookie{
+		Name:     joinCookie,
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// Handler only lets the clients that joined through the QR code through.
+// The others are asked to scan it again
+func (r *rotation) Handler(next http.HandlerFunc) http.HandlerFunc {
+	if r == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !r.member(req) {
+			w.WriteHeader(http.StatusGone)
+			serveTemplate("scan-again", pages.ScanAgain, w, nil)
+			return
+		}
+		next(w, req)
+	}
+}
+
+// serveJoin starts the session of the clients with a valid code, and sends
+// them to the route of the transfer
+func (s *Server) serveJoin(w http.ResponseWriter, r *http.Request, path string) {
+	code := strings.TrimPrefix(r.URL.Path, s.basePath+"/join/")
+	if !s.rotation.member(r) {
+		if !s.rotation.valid(code, time.Now()) {
+			w.WriteHeader(http.StatusGone)
+			serveTemplate("scan-again", pages.ScanAgain, w, nil)
+			return
+		}
+		if err := s.rotation.join(w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	route := s.basePath + "/send/" + path
+	if s.outputDir != "" {
+		route = s.basePath + "/receive/" + path
+	}
+	http.Redirect(w, r, route, http.StatusFound)
+}
+
+// Rotates reports whether the QR code rotates
+func (s *Server) Rotates() bool {
+	return s.rotation != nil
+}
+
+// JoinURL returns the URL of the rotating QR code, valid until the time
+// returned
+func (s *Server) JoinURL() (string, time.Time) {
+	now := time.Now()
+	return s.BaseURL + "/join/" + s.rotation.code(s.rotation.step(now)) + s.fragment, s.rotation.next(now)
+}
+
+// displayRotatingQR serves a page showing the rotating QR code, reloading
+// it on each rotation. It is only served to this machine, where the browser
+// is opened, as anyone reaching it could join
+func (s *Server) displayRotatingQR(route string) {
+	local := func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if ip := net.ParseIP(remoteIP(r)); ip == nil || !ip.IsLoopback() {
+				http.NotFound(w, r)
+				return
+			}
+			next(w, r)
+		}
+	}
+	http.HandleFunc(s.basePath+route, local(func(w http.ResponseWriter, r *http.Request) {
+		_, next := s.JoinURL()
+		serveTemplate("qr", pages.RotatingQR, w, struct {
+			Image  string
+			Next   int64
+			Period int64
+		}{s.basePath + route + ".jpg", time.Until(next).Milliseconds(), s.rotation.period.Milliseconds()})
+	}))
+	http.HandleFunc(s.basePath+route+".jpg", local(func(w http.ResponseWriter, r *http.Request) {
+		url, _ := s.JoinURL()
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("Cache-Control", "no-store")
+		jpeg.Encode(w, qr.RenderImage(url), nil)
+	}))
+	openBrowser(s.BaseURL + route)
+}
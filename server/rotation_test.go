@@ -0,0 +1,100 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRotationValid(t *testing.T) {
+	if _, err := newRotation(time.Second); err == nil {
+		t.Fatal("rotating every second should fail")
+	}
+	r, err := newRotation(30 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(3000, 0)
+	code := r.code(r.step(now))
+	if len(code) != joinCodeLength {
+		t.Fatalf("code %q has length %d", code, len(code))
+	}
+	tests := []struct {
+		at   time.Duration
+		want bool
+	}{
+		{0, true},
+		{29 * time.Second, true},
+		{30 * time.Second, true},
+		{59 * time.Second, true},
+		{60 * time.Second, false},
+		{-time.Second, false},
+	}
+	for _, tt := range tests {
+		if got := r.valid(code, now.Add(tt.at)); got != tt.want {
+			t.Errorf("valid after %s = %v, want %v", tt.at, got, tt.want)
+		}
+	}
+	if r.valid("", now) {
+		t.Error("an empty code is valid")
+	}
+	if next := r.next(now); !next.Equal(time.Unix(3030, 0)) {
+		t.Errorf("next = %s", next)
+	}
+}
+
+func TestServeJoin(t *testing.T) {
+	r, err := newRotation(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{rotation: r}
+	join := func(code string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/join/"+code, nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		s.serveJoin(w, req, "abcd")
+		return w
+	}
+	route := r.Handler(func(w http.ResponseWriter, req *http.Request) {})
+	if w := join("expired", nil); w.Code != http.StatusGone {
+		t.Fatalf("join with an expired code = %d", w.Code)
+	}
+	w := join(r.code(r.step(time.Now())), nil)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/send/abcd" {
+		t.Fatalf("join = %d %s", w.Code, w.Header().Get("Location"))
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != joinCookie {
+		t.Fatalf("cookies = %v", cookies)
+	}
+	// Once joined, the session outlives the code
+	if w := join("expired", cookies[0]); w.Code != http.StatusFound {
+		t.Fatalf("join with a session = %d", w.Code)
+	}
+	req := httptest.NewRequest("GET", "/send/abcd", nil)
+	req.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	route(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("route with a session = %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	route(w, httptest.NewRequest("GET", "/send/abcd", nil))
+	if w.Code != http.StatusGone {
+		t.Fatalf("route without a session = %d", w.Code)
+	}
+	// Sessions left unused are forgotten
+	r.prune(time.Now().Add(joinedLifetime + time.Minute))
+	if len(r.joined) != 0 {
+		t.Errorf("%d unused sessions kept", len(r.joined))
+	}
+	w = httptest.NewRecorder()
+	route(w, req)
+	if w.Code != http.StatusGone {
+		t.Fatalf("route with a forgotten session = %d", w.Code)
+	}
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/claudiodangelis/qrcp/audit"
+	"github.com/claudiodangelis/qrcp/scan"
+)
+
+// Verdicts recorded for the files received while scanning
+const (
+	verdictClean      = "clean"
+	verdictInfected   = "infected: "
+	verdictNotScanned = "not scanned: "
+)
+
+// fileScan is the scan of a file being received. The content is written to
+// it as it arrives, before any encryption
+type fileScan struct {
+	stream scan.Stream
+	err    error
+}
+
+// startScan starts the scan of a file, it returns nil when files are not
+// scanned. Failing to reach the scanner is reported with the verdict, so
+// that the file is quarantined
+func (s *Server) startScan() *fileScan {
+	if s.scanner == nil {
+		return nil
+	}
+	stream, err := s.scanner.Start()
+	return &fileScan{stream: stream, err: err}
+}
+
+func (f *fileScan) Write(p []byte) (int, error) {
+	if f.stream == nil {
+		return len(p), nil
+	}
+	return f.stream.Write(p)
+}
+
+// Close aborts the scan if the file wasn't received, so that no connection
+// or scanner process is left behind
+func (f *fileScan) Close() error {
+	if f.stream == nil {
+		return nil
+	}
+	return f.stream.Close()
+}
+
+// finishScan records the verdict of the scan of the file received at path.
+// Files that are infected, or that couldn't be scanned, are moved to the
+// quarantine folder, and finishScan reports true
+func (s *Server) finishScan(f *fileScan, path string, file *audit.File) bool {
+	verdict := scan.Verdict{}
+	err := f.err
+	if err == nil {
+		verdict, err = f.stream.Verdict()
+	}
+	if err == nil && verdict.Clean() {
+		file.Verdict = verdictClean
+		log.Printf("Scanned %s: clean", file.Name)
+		return false
+	}
+	if err != nil {
+		file.Verdict = verdictNotScanned + err.Error()
+	} else {
+		file.Verdict = verdictInfected + verdict.Threat
+	}
+	target, qerr := scan.Quarantine(path, s.quarantine)
+	if qerr != nil {
+		// The file must not stay among the files received
+		log.Printf("Unable to quarantine %s, deleting it: %v", path, qerr)
+		os.Remove(path)
+		file.Stored = ""
+	} else {
+		file.Stored = target
+	}
+	ShowWarning(fmt.Sprintf("%s quarantined (%s)", file.Name, file.Verdict))
+	return true
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/claudiodangelis/qrcp/audit"
+	"github.com/claudiodangelis/qrcp/scan"
+)
+
+// fakeScanner finds the files containing "virus"
+type fakeScanner struct {
+	err error
+}
+
+type fakeStream struct {
+	content strings.Builder
+}
+
+func (s *fakeScanner) Start() (scan.Stream, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &fakeStream{}, nil
+}
+
+func (s *fakeStream) Write(p []byte) (int, error) {
+	return s.content.Write(p)
+}
+
+func (s *fakeStream) Close() error {
+	return nil
+}
+
+func (s *fakeStream) Verdict() (scan.Verdict, error) {
+	if strings.Contains(s.content.String(), "virus") {
+		return scan.Verdict{Threat: "Fake-Virus"}, nil
+	}
+	return scan.Verdict{}, nil
+}
+
+func TestFinishScan(t *testing.T) {
+	tests := []struct {
+		name        string
+		content     string
+		err         error
+		quarantined bool
+		verdict     string
+	}{
+		{"clean", "hello", nil, false, verdictClean},
+		{"infected", "a virus", nil, true, verdictInfected + "Fake-Virus"},
+		{"unreachable scanner", "hello", errors.New("connection refused"), true, verdictNotScanned + "connection refused"},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		s := &Server{scanner: &fakeScanner{err: tt.err}, quarantine: filepath.Join(dir, "quarantine")}
+		path := filepath.Join(dir, "file.txt")
+		os.WriteFile(path, []byte(tt.content), 0644)
+		f := s.startScan()
+		f.Write([]byte(tt.content))
+		file := audit.File{Name: "file.txt"}
+		if got := s.finishScan(f, path, &file); got != tt.quarantined {
+			t.Errorf("%s: quarantined = %v, want %v", tt.name, got, tt.quarantined)
+		}
+		if file.Verdict != tt.verdict {
+			t.Errorf("%s: verdict %q, want %q", tt.name, file.Verdict, tt.verdict)
+		}
+		_, err := os.Stat(path)
+		if tt.quarantined != os.IsNotExist(err) {
+			t.Errorf("%s: file left in place = %v", tt.name, err == nil)
+		}
+		if tt.quarantined && file.Stored != filepath.Join(s.quarantine, "file.txt") {
+			t.Errorf("%s: stored as %q", tt.name, file.Stored)
+		}
+	}
+	if (&Server{}).startScan() != nil {
+		t.Error("files are scanned without a scanner")
+	}
+}
@@ -2,7 +2,11 @@ package server
 
 import (
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"image/jpeg"
 	"io"
@@ -20,10 +24,18 @@ import (
 	"sync"
 
 	"github.com/claudiodangelis/qrcp/qr"
+	"github.com/claudiodangelis/qrcp/resolver"
+	"github.com/claudiodangelis/qrcp/scan"
 
+	"filippo.io/age"
+	"github.com/claudiodangelis/qrcp/audit"
 	"github.com/claudiodangelis/qrcp/body"
 	"github.com/claudiodangelis/qrcp/config"
+	"github.com/claudiodangelis/qrcp/discovery"
+	"github.com/claudiodangelis/qrcp/e2e"
+	"github.com/claudiodangelis/qrcp/identity"
 	"github.com/claudiodangelis/qrcp/pages"
+	"github.com/claudiodangelis/qrcp/relay"
 	"github.com/claudiodangelis/qrcp/style"
 	"github.com/claudiodangelis/qrcp/util"
 	"gopkg.in/cheggaaa/pb.v1"
@@ -37,11 +49,49 @@ type Server struct {
 	// SendURL is the URL used to send the file
 	SendURL string
 	// ReceiveURL is the URL used to Receive the file
-	ReceiveURL  string
+	ReceiveURL string
+	// LoginURL is the login page of the accounts, when serving them
+	LoginURL string
+	// basePath prefixes all routes, e.g. when behind a reverse proxy
+	basePath string
+	// Endpoints lists the landing page of each interface the server listens
+	// on, when using `interface: all-lan`
+	Endpoints   []Endpoint
 	instance    *http.Server
+	audit       *audit.Log
+	approvals   *approvals
+	proxies     *proxies
+	port        int
+	mdns        bool
+	mdnsURL     bool
+	mdnsIPs     []net.IP
+	advertiser  *discovery.Advertiser
 	body        body.Body
+	digest      *fileDigest
 	outputDir   string
 	stopChannel chan bool
+	// e2eKey encrypts the files end to end, it is only shared in the
+	// fragment of the URLs
+	e2eKey []byte
+	// recipients are the age public keys received files are encrypted to
+	recipients []age.Recipient
+	// scanner scans the files received, the infected ones are moved to
+	// quarantine
+	scanner    scan.Scanner
+	quarantine string
+	// identity signs the manifest of the file sent, into signature
+	identity  *identity.Identity
+	signature *bodySignature
+	// tokens are the capability tokens minted for the session, which
+	// reuse the upload handler in receive, like accounts
+	tokens   *tokens
+	accounts *accounts
+	receive  func(route string, outputDir string, keepAlive bool) http.HandlerFunc
+	// rotation makes the QR code lead to a join route changing over time
+	rotation *rotation
+	// fragment is added to the URLs, it carries the key of end-to-end
+	// encryption
+	fragment string
 	// expectParallelRequests is set to true when qrcp sends files, in order
 	// to support downloading of parallel chunks
 	expectParallelRequests bool
@@ -62,20 +112,44 @@ func (s *Server) ReceiveTo(dir string) error {
 		return fmt.Errorf("%s is not a valid directory", output)
 	}
 	s.outputDir = output
+	s.advertise("receive")
 	return nil
 }
 
-// Send adds a handler for sending the file
-func (s *Server) Send(p body.Body) {
+// Send adds a handler for sending the file. With an identity, the file is
+// signed as is, and with end-to-end encryption, it is encrypted afterwards
+func (s *Server) Send(p body.Body) error {
+	if s.identity != nil {
+		s.signature = signBody(p, s.identity)
+		ShowSigned(identity.Fingerprint(s.identity.PublicKey()))
+	}
+	if s.e2eKey != nil {
+		// The plaintext may be removed once encrypted
+		if s.signature != nil {
+			<-s.signature.done
+		}
+		encrypted, err := encryptBody(p, s.e2eKey)
+		if err != nil {
+			return err
+		}
+		p = encrypted
+	}
 	s.body = p
+	s.digest = digestFile(p.Path)
 	s.expectParallelRequests = true
+	s.advertise("send")
+	return nil
 }
 
 // DisplayQR creates a handler for serving the QR code in the browser
 func (s *Server) DisplayQR(url string) {
 	const PATH = "/qr"
+	if s.rotation != nil {
+		s.displayRotatingQR(PATH)
+		return
+	}
 	qrImg := qr.RenderImage(url)
-	http.HandleFunc(PATH, func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc(s.basePath+PATH, func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "image/jpeg")
 		if err := jpeg.Encode(w, qrImg, nil); err != nil {
 			panic(err)
@@ -87,9 +161,25 @@ func (s *Server) DisplayQR(url string) {
 // Wait for transfer to be completed, it waits forever if kept awlive
 func (s Server) Wait() error {
 	<-s.stopChannel
+	if err := s.advertiser.Close(); err != nil {
+		log.Println(err)
+	}
 	if err := s.instance.Shutdown(context.Background()); err != nil {
 		log.Println(err)
 	}
+	ShowClientSummary(s.audit.Summary())
+	if s.e2eKey != nil && s.outputDir != "" {
+		paths := []string{}
+		for _, c := range s.audit.Summary() {
+			for _, f := range c.Files {
+				paths = append(paths, filepath.Join(s.outputDir, filepath.FromSlash(f)))
+			}
+		}
+		ShowDecryptHint(e2e.EncodeKey(s.e2eKey), paths)
+	}
+	if err := s.audit.Close(); err != nil {
+		log.Println(err)
+	}
 	if s.body.DeleteAfterTransfer {
 		if err := s.body.Delete(); err != nil {
 			panic(err)
@@ -111,61 +201,253 @@ func New(cfg *config.Config) (*Server, error) {
 	app := &Server{}
 	// Get the address of the configured interface to bind the server to.
 	// If `bind` configuration parameter has been configured, it takes precedence
-	bind, err := util.GetInterfaceAddress(cfg.Interface)
-	if err != nil {
-		return &Server{}, err
+	if cfg.IPVersion != 0 && cfg.IPVersion != 4 && cfg.IPVersion != 6 {
+		return nil, fmt.Errorf("invalid IP version %d, use 4 or 6", cfg.IPVersion)
+	}
+	var bind string
+	var binds, names []string
+	var err error
+	if !isSocketListen(cfg.Listen) && cfg.Relay == "" {
+		if cfg.Interface == util.AllLAN && cfg.Bind == "" {
+			// Listen on every suitable interface
+			names, binds, err = util.AllLANAddresses()
+			if err != nil {
+				return nil, err
+			}
+			bind = binds[0]
+		} else {
+			bind, err = util.GetInterfaceAddress(cfg.Interface, cfg.IPVersion)
+			if err != nil {
+				return &Server{}, err
+			}
+			if cfg.Bind != "" {
+				bind = strings.Trim(cfg.Bind, "[]")
+			}
+			binds = []string{bind}
+		}
+		// With dual-stack, listen on both the IPv4 and the IPv6 address of the
+		// interface. The unspecified address is already dual-stack
+		if cfg.DualStack && cfg.Bind == "" && cfg.Interface != "any" && cfg.Interface != util.AllLAN {
+			binds, err = util.GetInterfaceAddresses(cfg.Interface, cfg.IPVersion)
+			if err != nil {
+				return nil, err
+			}
+		}
 	}
-	if cfg.Bind != "" {
-		bind = cfg.Bind
+	// Build the allow and deny lists for incoming connections
+	filter, err := newConnFilter(cfg)
+	if err != nil {
+		return nil, err
 	}
-	// Create a listener. If `port: 0`, a random one is chosen
-	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", bind, cfg.Port))
+	trusted, err := parseNetworks(cfg.TrustedProxies)
 	if err != nil {
 		return nil, err
 	}
-	// Set the value of computed port
-	port := listener.Addr().(*net.TCPAddr).Port
+	app.proxies = &proxies{trusted: trusted, filter: filter}
+	// A PROXY header sets the address of the client, so it's only read from
+	// trusted proxies, or from the peers of a Unix socket
+	if cfg.ProxyProtocol && len(trusted) == 0 && !strings.HasPrefix(cfg.Listen, "unix:") {
+		return nil, errors.New("--proxy-protocol needs --trusted-proxy, the addresses of the proxies sending the PROXY header")
+	}
+	// Create the listeners. If `port: 0`, a random one is chosen by the first
+	// listener and reused by the others
+	port := cfg.Port
+	listeners := []net.Listener{}
+	if isSocketListen(cfg.Listen) {
+		// URLs can't be derived from a socket, they come from the FQDN or
+		// the public URL instead
+		if cfg.FQDN == "" && cfg.PublicURL == "" {
+			return nil, errors.New("listening on a socket requires a FQDN or a public URL")
+		}
+		listeners, err = listenSocket(cfg.Listen)
+		if err != nil {
+			return nil, err
+		}
+		app.proxies.local = true
+	}
+	// Behind a relay, connections are opened outbound and the relay is the
+	// trusted proxy of the clients
+	var relayListener *relay.Listener
+	if cfg.Relay != "" {
+		if cfg.Secure {
+			return nil, errors.New("--secure can't be used with a relay, use an https relay URL instead")
+		}
+		relayListener, err = relay.Listen(cfg.Relay)
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, relayListener)
+		relayNetworks, err := parseNetworks([]string{relayListener.Relay.String()})
+		if err != nil {
+			relayListener.Close()
+			return nil, err
+		}
+		app.proxies.trusted = append(app.proxies.trusted, relayNetworks...)
+	}
+	for _, b := range binds {
+		listener, err := net.Listen("tcp", net.JoinHostPort(b, strconv.Itoa(port)))
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+		// Set the value of computed port
+		port = listener.Addr().(*net.TCPAddr).Port
+	}
 	// Set the host
-	host := fmt.Sprintf("%s:%d", bind, port)
+	host := net.JoinHostPort(bind, strconv.Itoa(port))
+	if isSocketListen(cfg.Listen) {
+		host = cfg.Listen
+	} else if relayListener != nil {
+		host = relayListener.URL
+	}
 	// Get a random path to use
 	path := cfg.Path
 	if path == "" {
-		path = util.GetRandomURLPath()
+		path, err = util.GetRandomURLPath(cfg.TokenBits, cfg.TokenAlphabet)
+		if err != nil {
+			return nil, err
+		}
+	} else if util.TokenEntropy(path) < util.MinTokenBits {
+		ShowWarning(fmt.Sprintf("The path %q is easy to guess, leave it empty to use a random token", path))
 	}
 	// Set the hostname
-	hostname := fmt.Sprintf("%s:%d", bind, port)
+	hostname := util.URLHost(bind, port)
 	// Use external IP when using `interface: any`, unless a FQDN is set
-	if bind == "0.0.0.0" && cfg.FQDN == "" {
+	if bind != "" && net.ParseIP(bind).IsUnspecified() && cfg.FQDN == "" && !cfg.LocalHostname {
 		fmt.Println("Retrieving the external IP...")
-		extIP, err := util.GetExternalIP()
+		chain, err := resolver.NewChain(cfg.Resolvers, cfg.ResolveTimeout)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
-		extIPString := extIP.String()
-		fmtstring := "%s:%d"
-		if strings.Count(extIPString, ":") >= 2 {
-			// IPv6 address, wrap it in [] to add a port
-			fmtstring = "[%s]:%d"
+		extIP, _, err := chain.Resolve(cfg.IPVersion)
+		if err != nil {
+			// Offline or air-gapped, use the address on the local network
+			extIP, err = resolver.LANAddress(cfg.IPVersion)
+			if err != nil {
+				return nil, err
+			}
+			ShowWarning(fmt.Sprintf("Unable to retrieve the external IP, using the LAN address %s instead", extIP))
+		}
+		hostname = util.URLHost(extIP.String(), port)
+	}
+	// Use the multicast DNS name of this host if requested
+	if cfg.LocalHostname && cfg.FQDN == "" {
+		localHostname, err := discovery.LocalHostname()
+		if err != nil {
+			return nil, err
 		}
-		hostname = fmt.Sprintf(fmtstring, extIPString, port)
+		hostname = util.URLHost(localHostname, port)
 	}
-	// Use a fully-qualified domain name if set
+	// Use a fully-qualified domain name if set. Behind a socket without a
+	// configured port, the default port of the scheme is used
 	if cfg.FQDN != "" {
-		hostname = fmt.Sprintf("%s:%d", cfg.FQDN, port)
+		hostname = util.URLHost(cfg.FQDN, port)
+		if port == 0 {
+			hostname = cfg.FQDN
+		}
 	}
 	// Set URLs
 	protocol := "http"
 	if cfg.Secure {
 		protocol = "https"
 	}
-	app.BaseURL = fmt.Sprintf("%s://%s", protocol, hostname)
+	// Prefix routes and URLs with the base path. A public URL, e.g. the
+	// address of a reverse proxy, replaces the computed one
+	basePath := normalizeBasePath(cfg.BasePath)
+	app.BaseURL = fmt.Sprintf("%s://%s%s", protocol, hostname, basePath)
+	if cfg.PublicURL != "" {
+		publicURL, err := url.Parse(cfg.PublicURL)
+		if err != nil {
+			return nil, err
+		}
+		if publicURL.Scheme == "" || publicURL.Host == "" {
+			return nil, fmt.Errorf("invalid public URL: %s", cfg.PublicURL)
+		}
+		if basePath == "" {
+			basePath = normalizeBasePath(publicURL.Path)
+		}
+		app.BaseURL = fmt.Sprintf("%s://%s%s", publicURL.Scheme, publicURL.Host, basePath)
+	}
+	// The relay forwards the requests under the path of the session
+	if relayListener != nil {
+		app.BaseURL = relayListener.URL + basePath
+		basePath = "/" + relayListener.Session + basePath
+	}
+	app.basePath = basePath
 	app.SendURL = fmt.Sprintf("%s/send/%s",
 		app.BaseURL, path)
 	app.ReceiveURL = fmt.Sprintf("%s/receive/%s",
 		app.BaseURL, path)
+	for i, name := range names {
+		app.Endpoints = append(app.Endpoints, Endpoint{
+			Interface: name,
+			Address:   binds[i],
+			URL:       fmt.Sprintf("%s://%s%s/start/%s", protocol, util.URLHost(binds[i], port), basePath, path),
+		})
+	}
+	// The key of end-to-end encryption is only shared in the fragment of the
+	// URLs, which browsers don't send to the server
+	if cfg.E2E {
+		app.e2eKey, err = e2e.NewKey()
+		if err != nil {
+			return nil, err
+		}
+		fragment := "#" + e2e.Fragment(app.e2eKey)
+		app.fragment = fragment
+		app.SendURL += fragment
+		app.ReceiveURL += fragment
+		for i := range app.Endpoints {
+			app.Endpoints[i].URL += fragment
+		}
+		// Browsers only provide WebCrypto to secure pages
+		if !strings.HasPrefix(app.BaseURL, "https://") {
+			ShowWarning("Browsers only decrypt over HTTPS, use --e2e with --secure or an https URL")
+		}
+	}
+	if cfg.RotateQR != 0 {
+		app.rotation, err = newRotation(cfg.RotateQR)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// Received files are encrypted as they are written to disk, so that
+	// only the owner of the private key can read them
+	if cfg.EncryptTo != "" {
+		if cfg.E2E {
+			return nil, errors.New("--encrypt-to can't be used with --e2e, the files are already received encrypted")
+		}
+		app.recipients, err = e2e.ReadRecipients(util.Expand(cfg.EncryptTo))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Scan != "" {
+		if cfg.E2E {
+			return nil, errors.New("--scan can't be used with --e2e, the files are received encrypted")
+		}
+		if cfg.Quarantine == "" {
+			return nil, errors.New("--scan needs a --quarantine folder for the infected files")
+		}
+		if app.scanner, err = scan.New(cfg.Scan); err != nil {
+			return nil, err
+		}
+		if app.quarantine, err = filepath.Abs(util.Expand(cfg.Quarantine)); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(app.quarantine, 0700); err != nil {
+			return nil, err
+		}
+	}
 	// Create a server
 	httpserver := &http.Server{
-		Addr: host,
+		Addr:              host,
+		ReadHeaderTimeout: orDefault(cfg.ReadHeaderTimeout, defaultReadHeaderTimeout),
+		ReadTimeout:       max(cfg.ReadTimeout, 0),
+		IdleTimeout:       orDefault(cfg.IdleTimeout, defaultIdleTimeout),
+		MaxHeaderBytes:    orDefault(cfg.MaxHeaderBytes, defaultMaxHeaderBytes),
 		TLSConfig: &tls.Config{
 			MinVersion:               tls.VersionTLS12,
 			CurvePreferences:         []tls.CurveID{tls.CurveP521, tls.CurveP384, tls.CurveP256},
@@ -178,7 +460,26 @@ func New(cfg *config.Config) (*Server, error) {
 			},
 		},
 		TLSNextProto: make(map[string]func(*http.Server, *tls.Conn, http.Handler)),
+		// Rate limit clients and tarpit unknown paths to prevent enumeration
+		Handler:  app.proxies.Handler(newGuard(http.DefaultServeMux, cfg.RateLimit)),
+		ErrorLog: log.New(redirectLogFilter{}, "", log.LstdFlags),
 	}
+	// Record every request to the send and receive routes
+	app.audit, err = audit.New(cfg.AuditLog, remoteIP)
+	if err != nil {
+		return nil, err
+	}
+	app.port = port
+	app.mdns = cfg.MDNS
+	app.mdnsURL = cfg.MDNSURL
+	if cfg.MDNS {
+		app.mdnsIPs = advertiseIPs(binds)
+	}
+	// Ask the operator to accept each new device
+	if cfg.Confirm {
+		app.approvals = newApprovals()
+	}
+	app.tokens = &tokens{bits: cfg.TokenBits, alphabet: cfg.TokenAlphabet}
 	// Create channel to send message to stop server
 	app.stopChannel = make(chan bool)
 	// Create cookie used to verify request is coming from first client to connect
@@ -186,7 +487,6 @@ func New(cfg *config.Config) (*Server, error) {
 	// Gracefully shutdown when an OS signal is received or when "q" is pressed
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, os.Interrupt)
-	start := time.Now()
 	go func() {
 		<-sig
 		app.stopChannel <- true
@@ -197,9 +497,26 @@ func New(cfg *config.Config) (*Server, error) {
 	var waitgroup sync.WaitGroup
 	waitgroup.Add(1)
 	var initCookie sync.Once
+	// Parallel requests for ranges of the file share the progress bar
+	var progress sendProgress
+	// The routes of the transfer are left out of the drop server of
+	// accounts, which only serves the routes of the users
+	transfer := http.DefaultServeMux
+	if cfg.Accounts {
+		transfer = http.NewServeMux()
+	}
 	// Create handlers
 	// Send handler (sends file to caller)
-	http.HandleFunc("/send/"+path, func(w http.ResponseWriter, r *http.Request) {
+	transfer.HandleFunc(basePath+"/send/"+path, app.audit.Handler(path, app.rotation.Handler(app.approvals.Handler(func(w http.ResponseWriter, r *http.Request) {
+		// With end-to-end encryption, browsers get a page downloading the
+		// file with `raw` and decrypting it
+		if app.e2eKey != nil && r.URL.Query().Get("raw") == "" && strings.HasPrefix(r.Header.Get("User-Agent"), "Mozilla") {
+			serveTemplate("decrypt", pages.Decrypt, w, struct {
+				Route string
+				signatureVariables
+			}{basePath + "/send/" + path + "?raw=1", app.signatureInfo(path)})
+			return
+		}
 		if !cfg.KeepAlive && strings.HasPrefix(r.Header.Get("User-Agent"), "Mozilla") {
 			if cookie.Value == "" {
 				initCookie.Do(func() {
@@ -246,9 +563,24 @@ func New(cfg *config.Config) (*Server, error) {
 			return
 		}
 		total := fi.Size()
-		w.Header().Set("Content-Length", strconv.FormatInt(total, 10))
 		// Content type fallback
 		w.Header().Set("Content-Type", "application/octet-stream")
+		// Clients can download ranges of the file in parallel, and verify
+		// it with the digest. HEAD requests wait for the digest
+		w.Header().Set("Accept-Ranges", "bytes")
+		if digest := app.digest.header(r.Method == http.MethodHead); digest != "" {
+			w.Header().Set("Repr-Digest", digest)
+		}
+		offset, length, partial, err := parseRange(r.Header.Get("Range"), total)
+		if err != nil {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", total))
+			http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+		if r.Method == http.MethodHead {
+			return
+		}
 
 		f, err := os.Open(app.body.Path)
 		if err != nil {
@@ -257,6 +589,17 @@ func New(cfg *config.Config) (*Server, error) {
 			return
 		}
 		defer f.Close()
+		if partial {
+			if _, err := f.Seek(offset, io.SeekStart); err != nil {
+				http.Error(w, "Unable to read file", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, total))
+			w.WriteHeader(http.StatusPartialContent)
+		}
+		reader := io.LimitReader(f, length)
+		progress.begin()
+		defer progress.end()
 
 		// Start progress tracking
 		progressBar := pb.New64(total)
@@ -265,26 +608,23 @@ func New(cfg *config.Config) (*Server, error) {
 		// don't call Start() to avoid automatic terminal output; internal
 		// counters are still updated via progressBar.Add.
 
-		var bytesSent int64
 		done := make(chan struct{})
 		go func() {
 			// use short filename as prefix for the progress bar
 			prefix := filepath.Base(app.body.Filename)
-			start := time.Now()
 			ticker := time.NewTicker(300 * time.Millisecond)
 			defer ticker.Stop()
 			for {
 				select {
 				case <-ticker.C:
-					cur := int(atomic.LoadInt64(&bytesSent))
-					elapsed := time.Since(start)
+					cur, elapsed := progress.stats()
 					// rate in bytes/sec
 					var rate float64
 					if elapsed > 0 {
 						rate = float64(cur) / elapsed.Seconds()
 					}
-					bar := style.AnimatedProgressBarWithStats(int64(cur), total, prefix, rate, elapsed)
-					plain := style.AnimatedPlainProgressBarWithStats(int64(cur), total, prefix, rate, elapsed)
+					bar := style.AnimatedProgressBarWithStats(cur, total, prefix, rate, elapsed)
+					plain := style.AnimatedPlainProgressBarWithStats(cur, total, prefix, rate, elapsed)
 					func() {
 						defer func() {
 							if rec := recover(); rec != nil {
@@ -306,10 +646,12 @@ func New(cfg *config.Config) (*Server, error) {
 		}()
 
 		buf := make([]byte, 32*1024)
+		// Hash what is sent so that it can be recorded in the audit log
+		hash := sha256.New()
 		// last immediate-log time to avoid spamming logs on every chunk
 		lastLog := time.Now().Add(-time.Second)
 		for {
-			n, rerr := f.Read(buf)
+			n, rerr := reader.Read(buf)
 			if n > 0 {
 				wn, werr := w.Write(buf[:n])
 				if werr != nil {
@@ -317,17 +659,17 @@ func New(cfg *config.Config) (*Server, error) {
 					break
 				}
 				if wn > 0 {
-					atomic.AddInt64(&bytesSent, int64(wn))
+					hash.Write(buf[:wn])
+					progress.add(wn)
 					progressBar.Add(wn)
 					// throttle immediate plain logging to ~500ms
 					if time.Since(lastLog) > 500*time.Millisecond {
-						cur := int(atomic.LoadInt64(&bytesSent))
-						elapsed := time.Since(start)
+						cur, elapsed := progress.stats()
 						var rate float64
 						if elapsed > 0 {
 							rate = float64(cur) / elapsed.Seconds()
 						}
-						colored := style.AnimatedProgressBarWithStats(int64(cur), total, filepath.Base(app.body.Filename), rate, elapsed)
+						colored := style.AnimatedProgressBarWithStats(cur, total, filepath.Base(app.body.Filename), rate, elapsed)
 						log.Printf("Send progress (immediate): %s", colored)
 						lastLog = time.Now()
 					}
@@ -338,6 +680,12 @@ func New(cfg *config.Config) (*Server, error) {
 			}
 			if rerr != nil {
 				if rerr == io.EOF {
+					// The hash of a range is not the one of the file
+					file := audit.File{Name: app.body.Filename, Size: length}
+					if !partial {
+						file.SHA256 = hex.EncodeToString(hash.Sum(nil))
+					}
+					audit.AddFile(r.Context(), file)
 					break
 				}
 				log.Printf("Error reading file: %v", rerr)
@@ -348,160 +696,289 @@ func New(cfg *config.Config) (*Server, error) {
 		// Do not call progressBar.FinishPrint — it prints the pb library's
 		// own progress UI (the '=' bar). We use our custom progress output.
 		close(done)
-	})
-	// Upload handler (serves the upload page)
-	http.HandleFunc("/receive/"+path, func(w http.ResponseWriter, r *http.Request) {
-		htmlVariables := struct {
-			Route string
-			File  string
-		}{}
-		htmlVariables.Route = "/receive/" + path
-		switch r.Method {
-		case "POST":
-			filenames := util.ReadFilenames(app.outputDir)
-			reader, err := r.MultipartReader()
-			if err != nil {
-				fmt.Fprintf(w, "Upload error: %v\n", err)
-				log.Printf("Upload error: %v\n", err)
-				app.stopChannel <- true
-				return
-			}
-			// Log that we received a POST upload request and are ready to receive parts
-			log.Printf("Upload request received — waiting to receive file parts from client %s", r.RemoteAddr)
-			transferredFiles := []string{}
-			progressBar := pb.New64(r.ContentLength)
-			progressBar.ShowCounters = false
-
-			// Non-invasive progress renderer: track bytes transferred using an atomic counter
-			var bytesTransferred int64 = 0
-			var progressPrefix string
-			doneRendering := make(chan struct{})
-			// last immediate-log time to avoid spamming logs on every chunk
-			lastLog := time.Now().Add(-time.Second)
-			start := time.Now()
-			go func() {
-				ticker := time.NewTicker(300 * time.Millisecond)
-				defer ticker.Stop()
-				for {
-					select {
-					case <-ticker.C:
-						// Build a single-line progress string and attempt an in-place update
-						current := int(atomic.LoadInt64(&bytesTransferred))
-						total := int(r.ContentLength)
-						elapsed := time.Since(start)
-						var rate float64
-						if elapsed > 0 {
-							rate = float64(current) / elapsed.Seconds()
-						}
-						bar := style.AnimatedProgressBarWithStats(int64(current), int64(total), progressPrefix, rate, elapsed)
-						// Update the reserved single progress line below the QR; also log as fallback
-						func() {
-							defer func() {
-								if rec := recover(); rec != nil {
-									// fallback to logging when ANSI escapes are not supported
-									log.Printf("Progress: %s", bar)
-								}
-							}()
-							qr.UpdateProgressLine(bar)
-							// Also log so progress is visible even if cursor updates fail
-							log.Printf("Progress: %s", bar)
-							// Log a plain(no-ANSI) version for terminals that don't render colors
-							plain := style.AnimatedPlainProgressBarWithStats(int64(current), int64(total), progressPrefix, rate, elapsed)
-							log.Printf("Progress (plain): %s", plain)
-						}()
-					case <-doneRendering:
-						return
+	}))))
+	// Upload handler (serves the upload page). Capability tokens reuse it
+	// on their own route, without ending the session
+	app.receive = func(route string, outputDir string, keepAlive bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			htmlVariables := struct {
+				Route       string
+				File        string
+				E2E         bool
+				Scanned     bool
+				Quarantined []audit.File
+			}{}
+			htmlVariables.Route = route
+			htmlVariables.E2E = app.e2eKey != nil
+			htmlVariables.Scanned = app.scanner != nil
+			switch r.Method {
+			case "POST":
+				// fail answers a failed upload. Sessions kept alive, such as
+				// the drop server of accounts, go on serving the others
+				fail := func(status int, message string, err error) {
+					log.Printf("%s: %v", message, err)
+					http.Error(w, message, status)
+					if !keepAlive {
+						app.stopChannel <- true
 					}
 				}
-			}()
-			for {
-				part, err := reader.NextPart()
-				if err == io.EOF {
-					break
-				}
-				// iIf part.FileName() is empty, skip this iteration.
-				if part.FileName() == "" {
-					continue
-				}
-				// Prepare the destination
-				fileName := getFileName(filepath.Base(part.FileName()), filenames)
-				out, err := os.Create(filepath.Join(app.outputDir, fileName))
+				filenames := util.ReadFilenames(outputDir)
+				reader, err := r.MultipartReader()
 				if err != nil {
-					// Output to server
-					fmt.Fprintf(w, "Unable to create the file for writing: %s\n", err)
-					// Output to console
-					log.Printf("Unable to create the file for writing: %s\n", err)
-					// Send signal to server to shutdown
-					app.stopChannel <- true
+					fail(http.StatusBadRequest, "Upload error", err)
 					return
 				}
-				defer out.Close()
-				// Add name of new file
-				filenames = append(filenames, fileName)
-				// set prefix for progress rendering
-				progressPrefix = fileName
-				// Write the content from POSTed file to the out
-				// Use log.Printf so it appears on stderr and is more reliably visible
-				log.Printf("Transferring file: %s", out.Name())
-				progressBar.Prefix(out.Name())
-				// Do not call Start() to avoid automatic terminal rendering by the pb library.
-				buf := make([]byte, 1024)
+				// Log that we received a POST upload request and are ready to receive parts
+				log.Printf("Upload request received — waiting to receive file parts from client %s", r.RemoteAddr)
+				transferredFiles := []string{}
+				// Names taken in each folder, and the files received
+				dirFilenames := map[string][]string{}
+				var receipt struct {
+					Files []audit.File `json:"files"`
+				}
+				progressBar := pb.New64(r.ContentLength)
+				progressBar.ShowCounters = false
+
+				// Non-invasive progress renderer: track bytes transferred using an atomic counter
+				var bytesTransferred int64 = 0
+				var progressPrefix string
+				doneRendering := make(chan struct{})
+				stopRendering := sync.OnceFunc(func() { close(doneRendering) })
+				defer stopRendering()
+				// last immediate-log time to avoid spamming logs on every chunk
+				lastLog := time.Now().Add(-time.Second)
+				start := time.Now()
+				go func() {
+					ticker := time.NewTicker(300 * time.Millisecond)
+					defer ticker.Stop()
+					for {
+						select {
+						case <-ticker.C:
+							// Build a single-line progress string and attempt an in-place update
+							current := int(atomic.LoadInt64(&bytesTransferred))
+							total := int(r.ContentLength)
+							elapsed := time.Since(start)
+							var rate float64
+							if elapsed > 0 {
+								rate = float64(current) / elapsed.Seconds()
+							}
+							bar := style.AnimatedProgressBarWithStats(int64(current), int64(total), progressPrefix, rate, elapsed)
+							// Update the reserved single progress line below the QR; also log as fallback
+							func() {
+								defer func() {
+									if rec := recover(); rec != nil {
+										// fallback to logging when ANSI escapes are not supported
+										log.Printf("Progress: %s", bar)
+									}
+								}()
+								qr.UpdateProgressLine(bar)
+								// Also log so progress is visible even if cursor updates fail
+								log.Printf("Progress: %s", bar)
+								// Log a plain(no-ANSI) version for terminals that don't render colors
+								plain := style.AnimatedPlainProgressBarWithStats(int64(current), int64(total), progressPrefix, rate, elapsed)
+								log.Printf("Progress (plain): %s", plain)
+							}()
+						case <-doneRendering:
+							return
+						}
+					}
+				}()
+				// Abort the upload when the client stops sending data, so a
+				// stalled client can't hold the session open forever
+				stall := orDefault(cfg.UploadStallTimeout, defaultUploadStallTimeout)
+				controller := http.NewResponseController(w)
+				extendDeadline := func() {
+					if stall > 0 {
+						controller.SetReadDeadline(time.Now().Add(stall))
+					}
+				}
 				for {
-					// Read a chunk
-					n, err := part.Read(buf)
-					if err != nil && err != io.EOF {
-						// Output to server
-						fmt.Fprintf(w, "Unable to write file to disk: %v", err)
-						// Output to console
-						fmt.Printf("Unable to write file to disk: %v", err)
-						// Send signal to server to shutdown
-						app.stopChannel <- true
+					extendDeadline()
+					part, err := reader.NextPart()
+					if err == io.EOF {
+						break
+					}
+					if err != nil {
+						fail(http.StatusRequestTimeout, "Upload aborted", err)
 						return
 					}
-					if n == 0 {
-						break
+					// iIf part.FileName() is empty, skip this iteration.
+					if part.FileName() == "" {
+						continue
 					}
-					// Write a chunk
-					if _, err := out.Write(buf[:n]); err != nil {
-						// Output to server
-						fmt.Fprintf(w, "Unable to write file to disk: %v", err)
-						// Output to console
-						log.Printf("Unable to write file to disk: %v", err)
-						// Send signal to server to shutdown
-						app.stopChannel <- true
+					// Prepare the destination. Uploaded folders keep their
+					// structure below the output directory
+					dir, base := uploadPath(part)
+					if dir != "" {
+						if err := os.MkdirAll(filepath.Join(outputDir, dir), 0755); err != nil {
+							fail(http.StatusInternalServerError, "Unable to create the folder", err)
+							return
+						}
+						if _, ok := dirFilenames[dir]; !ok {
+							dirFilenames[dir] = util.ReadFilenames(filepath.Join(outputDir, dir))
+						}
+					} else {
+						dirFilenames[dir] = filenames
+					}
+					fileName := filepath.Join(dir, getFileName(base, dirFilenames[dir]))
+					// Encrypted files are stored as name.age
+					if app.recipients != nil {
+						fileName = filepath.Join(dir, getSuffixedFileName(base, e2e.AgeExtension, dirFilenames[dir]))
+					}
+					out, err := os.Create(filepath.Join(outputDir, fileName))
+					if err != nil {
+						fail(http.StatusInternalServerError, "Unable to create the file for writing", err)
 						return
 					}
-					// Update progress counters
-					progressBar.Add(n)
-					atomic.AddInt64(&bytesTransferred, int64(n))
-					// immediate plain logging (throttled)
-					// Use a per-upload lastLog variable stored in closure
-					if time.Since(lastLog) > 500*time.Millisecond {
-						cur := int(atomic.LoadInt64(&bytesTransferred))
-						elapsed := time.Since(start)
-						var rate float64
-						if elapsed > 0 {
-							rate = float64(cur) / elapsed.Seconds()
+					defer out.Close()
+					// discard fails the upload without leaving the partial
+					// file behind
+					discard := func(status int, message string, err error) {
+						out.Close()
+						os.Remove(out.Name())
+						fail(status, message, err)
+					}
+					var writer io.Writer = out
+					var encrypted io.WriteCloser
+					if app.recipients != nil {
+						encrypted, err = age.Encrypt(out, app.recipients...)
+						if err != nil {
+							discard(http.StatusInternalServerError, "Unable to encrypt the file", err)
+							return
 						}
-						colored := style.AnimatedProgressBarWithStats(int64(cur), int64(r.ContentLength), progressPrefix, rate, elapsed)
-						log.Printf("Progress (immediate): %s", colored)
-						lastLog = time.Now()
+						writer = encrypted
+					}
+					// The scanner sees the plaintext, before any encryption
+					scanning := app.startScan()
+					if scanning != nil {
+						defer scanning.Close()
+						writer = io.MultiWriter(writer, scanning)
+					}
+					file := audit.File{Name: filepath.ToSlash(fileName)}
+					if app.recipients != nil {
+						file.Name = filepath.ToSlash(filepath.Join(dir, base))
+						file.Stored = filepath.ToSlash(fileName)
+					}
+					// Add name of new file
+					dirFilenames[dir] = append(dirFilenames[dir], filepath.Base(fileName))
+					filenames = dirFilenames[""]
+					// set prefix for progress rendering
+					progressPrefix = fileName
+					// Write the content from POSTed file to the out
+					// Use log.Printf so it appears on stderr and is more reliably visible
+					log.Printf("Transferring file: %s", out.Name())
+					progressBar.Prefix(out.Name())
+					// Do not call Start() to avoid automatic terminal rendering by the pb library.
+					buf := make([]byte, 1024)
+					hash := sha256.New()
+					var size int64
+					for {
+						// Read a chunk
+						extendDeadline()
+						n, err := part.Read(buf)
+						if errors.Is(err, os.ErrDeadlineExceeded) {
+							discard(http.StatusRequestTimeout, "Upload stalled", fmt.Errorf("no data from the client for %s", stall))
+							return
+						}
+						if err != nil && err != io.EOF {
+							// The client aborted the upload
+							discard(http.StatusBadRequest, "Unable to read the upload", err)
+							return
+						}
+						if n == 0 {
+							break
+						}
+						// Write a chunk
+						if _, err := writer.Write(buf[:n]); err != nil {
+							discard(http.StatusInternalServerError, "Unable to write file to disk", err)
+							return
+						}
+						hash.Write(buf[:n])
+						size += int64(n)
+						// Update progress counters
+						progressBar.Add(n)
+						atomic.AddInt64(&bytesTransferred, int64(n))
+						// immediate plain logging (throttled)
+						// Use a per-upload lastLog variable stored in closure
+						if time.Since(lastLog) > 500*time.Millisecond {
+							cur := int(atomic.LoadInt64(&bytesTransferred))
+							elapsed := time.Since(start)
+							var rate float64
+							if elapsed > 0 {
+								rate = float64(cur) / elapsed.Seconds()
+							}
+							colored := style.AnimatedProgressBarWithStats(int64(cur), int64(r.ContentLength), progressPrefix, rate, elapsed)
+							log.Printf("Progress (immediate): %s", colored)
+							lastLog = time.Now()
+						}
+					}
+					// Closing the encryption writes the last chunk
+					if encrypted != nil {
+						if err := encrypted.Close(); err != nil {
+							discard(http.StatusInternalServerError, "Unable to write file to disk", err)
+							return
+						}
+					}
+					file.Size = size
+					file.SHA256 = hex.EncodeToString(hash.Sum(nil))
+					// The file is closed before it may be quarantined
+					if err := out.Close(); err != nil {
+						discard(http.StatusInternalServerError, "Unable to write file to disk", err)
+						return
+					}
+					if scanning != nil && app.finishScan(scanning, out.Name(), &file) {
+						htmlVariables.Quarantined = append(htmlVariables.Quarantined, file)
+					} else {
+						transferredFiles = append(transferredFiles, file.String())
 					}
+					audit.AddFile(r.Context(), file)
+					receipt.Files = append(receipt.Files, file)
 				}
+				// Do not call progressBar.FinishPrint for the same reason as above.
+				// Stop the QR+progress renderer
+				stopRendering()
+				// Set the value of the variable to the actually transferred files
+				htmlVariables.File = strings.Join(transferredFiles, ", ")
+				// Command line clients get the receipt instead of the page
+				if strings.Contains(r.Header.Get("Accept"), "application/json") {
+					w.Header().Set("Content-Type", "application/json")
+					json.NewEncoder(w).Encode(receipt)
+				} else {
+					serveTemplate("done", pages.Done, w, htmlVariables)
+				}
+				if !keepAlive {
+					app.stopChannel <- true
+				}
+			case "GET":
+				serveTemplate("upload", pages.Upload, w, htmlVariables)
 			}
-			// Do not call progressBar.FinishPrint for the same reason as above.
-			// Stop the QR+progress renderer
-			close(doneRendering)
-			// Set the value of the variable to the actually transferred files
-			htmlVariables.File = strings.Join(transferredFiles, ", ")
-			serveTemplate("done", pages.Done, w, htmlVariables)
-			if !cfg.KeepAlive {
-				app.stopChannel <- true
-			}
-		case "GET":
-			serveTemplate("upload", pages.Upload, w, htmlVariables)
 		}
-	})
+	}
+	// The folder is read on each request, as it is set after the server is
+	// created
+	transfer.HandleFunc(basePath+"/receive/"+path, app.audit.Handler(path, app.rotation.Handler(app.approvals.Handler(func(w http.ResponseWriter, r *http.Request) {
+		if app.outputDir == "" {
+			http.NotFound(w, r)
+			return
+		}
+		app.receive(basePath+"/receive/"+path, app.outputDir, cfg.KeepAlive)(w, r)
+	}))))
+	// Signature of the file sent, and the public key that made it
+	transfer.HandleFunc(basePath+"/send/"+path+"/signature", app.audit.Handler(path, app.rotation.Handler(app.approvals.Handler(app.serveSignature))))
+	transfer.HandleFunc(basePath+"/send/"+path+"/key", app.audit.Handler(path, app.rotation.Handler(app.approvals.Handler(app.servePublicKey))))
+	// Landing page, tells the client which address it reached
+	if len(app.Endpoints) > 0 {
+		transfer.HandleFunc(basePath+"/start/"+path, app.audit.Handler(path, app.rotation.Handler(func(w http.ResponseWriter, r *http.Request) {
+			app.serveLanding(w, r, path)
+		})))
+	}
+	// Rotating QR codes lead to the join route, which starts the session of
+	// the client
+	if app.rotation != nil {
+		http.HandleFunc(basePath+"/join/", app.audit.Handler(path, func(w http.ResponseWriter, r *http.Request) {
+			app.serveJoin(w, r, path)
+		}))
+	}
 	// Wait for all wg to be done, then send shutdown signal
 	go func() {
 		waitgroup.Wait()
@@ -511,18 +988,23 @@ func New(cfg *config.Config) (*Server, error) {
 		app.stopChannel <- true
 	}()
 
-	go func() {
-		netListener := tcpKeepAliveListener{listener.(*net.TCPListener)}
-		if cfg.Secure {
-			if err := httpserver.ServeTLS(netListener, cfg.TlsCert, cfg.TlsKey); err != http.ErrServerClosed {
-				log.Fatalln("error starting the server:", err)
-			}
-		} else {
-			if err := httpserver.Serve(netListener); err != http.ErrServerClosed {
-				log.Fatalln("error starting the server", err)
+	limits := newConnLimiter(cfg)
+	for _, listener := range listeners {
+		go func(listener net.Listener) {
+			netListener := wrapListener(listener, filter, limits, app.proxies, cfg.ProxyProtocol)
+			if cfg.Secure {
+				// Answer plain HTTP on the same port with a redirect
+				netListener = redirectListener{netListener, hostname}
+				if err := httpserver.ServeTLS(netListener, cfg.TlsCert, cfg.TlsKey); err != http.ErrServerClosed {
+					log.Fatalln("error starting the server:", err)
+				}
+			} else {
+				if err := httpserver.Serve(netListener); err != http.ErrServerClosed {
+					log.Fatalln("error starting the server", err)
+				}
 			}
-		}
-	}()
+		}(listener)
+	}
 
 	app.instance = httpserver
 	return app, nil
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/claudiodangelis/qrcp/body"
+	"github.com/claudiodangelis/qrcp/identity"
+)
+
+// bodySignature is the signed manifest of the file sent. It is computed in
+// the background, like the digest, as hashing a large file takes a while
+type bodySignature struct {
+	done chan struct{}
+	// name is the name of the file as signed, before any encryption
+	name      string
+	signature []byte
+	err       error
+}
+
+// signBody signs the manifest of the body with id
+func signBody(p body.Body, id *identity.Identity) *bodySignature {
+	s := &bodySignature{done: make(chan struct{}), name: p.Filename}
+	go func() {
+		defer close(s.done)
+		file, err := identity.HashFile(p.Path, p.Filename)
+		if err != nil {
+			s.err = err
+			return
+		}
+		signature, err := id.Sign(identity.Manifest{Created: time.Now().UTC(), Files: []identity.File{file}})
+		if err != nil {
+			s.err = err
+			return
+		}
+		s.signature, s.err = json.MarshalIndent(signature, "", "  ")
+	}()
+	return s
+}
+
+// SignWith makes the server sign the manifest of the files it sends with
+// id, so that the recipients can verify who sent them
+func (s *Server) SignWith(id *identity.Identity) {
+	s.identity = id
+}
+
+// serveSignature serves the signature of the file sent, to be saved next to
+// the file for mocp verify
+func (s *Server) serveSignature(w http.ResponseWriter, r *http.Request) {
+	if s.signature == nil {
+		http.NotFound(w, r)
+		return
+	}
+	<-s.signature.done
+	if s.signature.err != nil {
+		http.Error(w, "Unable to sign the file", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": s.signature.name + identity.SignatureExtension,
+	}))
+	w.Write(s.signature.signature)
+}
+
+// servePublicKey serves the public key signing the file sent
+func (s *Server) servePublicKey(w http.ResponseWriter, r *http.Request) {
+	if s.identity == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(identity.EncodePublicKey(s.identity.PublicKey()) + "\n"))
+}
+
+// signatureVariables are the fields of the pages telling how to verify the
+// file sent
+type signatureVariables struct {
+	Fingerprint string
+	Signature   string
+	PublicKey   string
+	Name        string
+}
+
+// signatureInfo returns the fields of the pages about the signature of the
+// file sent, empty when it is not signed
+func (s *Server) signatureInfo(path string) signatureVariables {
+	if s.signature == nil || s.outputDir != "" {
+		return signatureVariables{}
+	}
+	route := s.basePath + "/send/" + path
+	return signatureVariables{
+		Fingerprint: identity.Fingerprint(s.identity.PublicKey()),
+		Signature:   route + "/signature",
+		PublicKey:   route + "/key",
+		Name:        s.signature.name,
+	}
+}
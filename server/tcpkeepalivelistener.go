@@ -36,19 +36,50 @@ import (
 // tcpKeepAliveListener applies TCP keepalives to the listener
 type tcpKeepAliveListener struct {
 	*net.TCPListener
+	filter *connFilter
+	limits *connLimiter
+	// proxies are the trusted reverse proxies, proxyProtocol is set when
+	// connections start with a PROXY protocol header
+	proxies       *proxies
+	proxyProtocol bool
 }
 
 // Accept accepts TCP
 func (ln tcpKeepAliveListener) Accept() (net.Conn, error) {
-	tc, err := ln.AcceptTCP()
-	if err != nil {
-		return nil, err
+	for {
+		tc, err := ln.AcceptTCP()
+		if err != nil {
+			return nil, err
+		}
+		// Drop connections refused by the allow and deny lists, or over the
+		// connection caps, before they reach the HTTP server
+		// Behind a proxy, the address of the client is only known once the
+		// request is read, and connections are not capped per address
+		proxied := ln.proxies.trusts(tc.RemoteAddr().String())
+		if ln.proxyProtocol {
+			// Only trusted proxies may set the address of the client
+			if !proxied {
+				ln.filter.reject(tc)
+				continue
+			}
+		} else if !ln.filter.accepts(tc.RemoteAddr()) {
+			ln.filter.reject(tc)
+			continue
+		}
+		conn := ln.limits.track(tc, !proxied)
+		if conn == nil {
+			tc.Close()
+			continue
+		}
+		if err := tc.SetKeepAlive(true); err != nil {
+			panic(err)
+		}
+		if err := tc.SetKeepAlivePeriod(3 * time.Minute); err != nil {
+			panic(err)
+		}
+		if ln.proxyProtocol {
+			return newProxyConn(conn, ln.filter), nil
+		}
+		return conn, nil
 	}
-	if err := tc.SetKeepAlive(true); err != nil {
-		panic(err)
-	}
-	if err := tc.SetKeepAlivePeriod(3 * time.Minute); err != nil {
-		panic(err)
-	}
-	return tc, nil
 }
@@ -0,0 +1,593 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/claudiodangelis/qrcp/audit"
+	"github.com/claudiodangelis/qrcp/pages"
+	"github.com/claudiodangelis/qrcp/style"
+	"github.com/claudiodangelis/qrcp/util"
+)
+
+// Scope is the set of rights granted by a capability token
+type Scope uint8
+
+const (
+	// ScopeDownload allows downloading files
+	ScopeDownload Scope = 1 << iota
+	// ScopeUpload allows uploading files to the folder of a receive session
+	ScopeUpload
+	// ScopeList allows listing the files
+	ScopeList
+	// ScopeDelete allows deleting files from the folder of a receive session
+	ScopeDelete
+)
+
+// scopeNames are the names of the scopes, in the order they are printed
+var scopeNames = []struct {
+	scope Scope
+	name  string
+}{
+	{ScopeDownload, "download"},
+	{ScopeUpload, "upload"},
+	{ScopeList, "list"},
+	{ScopeDelete, "delete"},
+}
+
+// scopePresets are the common sets of scopes
+var scopePresets = map[string]Scope{
+	"read-only":   ScopeDownload | ScopeList,
+	"upload-only": ScopeUpload,
+	"read-write":  ScopeDownload | ScopeUpload | ScopeList | ScopeDelete,
+}
+
+// maxListedFiles caps the files listed on the page of a token
+const maxListedFiles = 1000
+
+// ParseScope parses a preset, read-only, upload-only or read-write, or a
+// comma separated list of scopes, e.g. download,list
+func ParseScope(s string) (Scope, error) {
+	if scope, ok := scopePresets[s]; ok {
+		return scope, nil
+	}
+	var scope Scope
+	for _, name := range strings.Split(s, ",") {
+		found := false
+		for _, n := range scopeNames {
+			if strings.TrimSpace(name) == n.name {
+				scope |= n.scope
+				found = true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("unknown scope %q, use download, upload, list, delete or read-only, upload-only, read-write", name)
+		}
+	}
+	return scope, nil
+}
+
+func (s Scope) String() string {
+	names := []string{}
+	for _, n := range scopeNames {
+		if s&n.scope != 0 {
+			names = append(names, n.name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// TokenSpec describes a token to mint
+type TokenSpec struct {
+	Scope Scope
+	// TTL is how long the token is valid, forever if 0
+	TTL time.Duration
+	// Uses is how many transfers the token allows, unlimited if 0
+	Uses int
+}
+
+// ParseTokenSpec parses scopes[:ttl[:uses]], e.g. upload-only:1h or
+// download,list:30m:5
+func ParseTokenSpec(s string) (TokenSpec, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 {
+		return TokenSpec{}, fmt.Errorf("invalid token %q, use scopes[:ttl[:uses]]", s)
+	}
+	scope, err := ParseScope(parts[0])
+	if err != nil {
+		return TokenSpec{}, err
+	}
+	spec := TokenSpec{Scope: scope}
+	if len(parts) > 1 && parts[1] != "" {
+		if spec.TTL, err = time.ParseDuration(parts[1]); err != nil || spec.TTL < 0 {
+			return TokenSpec{}, fmt.Errorf("invalid expiry %q of token %q", parts[1], s)
+		}
+	}
+	if len(parts) > 2 {
+		if spec.Uses, err = strconv.Atoi(parts[2]); err != nil || spec.Uses < 0 {
+			return TokenSpec{}, fmt.Errorf("invalid use count %q of token %q", parts[2], s)
+		}
+	}
+	return spec, nil
+}
+
+// Token grants the rights of its scope through its own URL
+type Token struct {
+	// ID is the number the operator refers to the token with
+	ID    int
+	Scope Scope
+	// Expires is zero for tokens that don't expire
+	Expires time.Time
+	// MaxUses is zero for tokens with unlimited uses
+	MaxUses int
+	Uses    int
+	Revoked bool
+	URL     string
+	secret  string
+}
+
+// errTokenGone tells why a token can't be used anymore
+type errTokenGone string
+
+func (e errTokenGone) Error() string {
+	return string(e)
+}
+
+// errUsedUp tells that all the uses of a token were counted
+const errUsedUp errTokenGone = "This link has been used up."
+
+// valid returns why the token can't be used anymore, if so
+func (t *Token) valid(now time.Time) error {
+	switch {
+	case t.Revoked:
+		return errTokenGone("This link was revoked.")
+	case !t.Expires.IsZero() && now.After(t.Expires):
+		return errTokenGone("This link has expired.")
+	case t.MaxUses > 0 && t.Uses >= t.MaxUses:
+		return errUsedUp
+	}
+	return nil
+}
+
+// Describe returns the scope, expiry and uses of the token
+func (t Token) Describe() string {
+	s := t.Scope.String()
+	if !t.Expires.IsZero() {
+		s += ", expires " + t.Expires.Local().Format("15:04:05")
+	}
+	if t.MaxUses > 0 {
+		s += fmt.Sprintf(", %d/%d uses", t.Uses, t.MaxUses)
+	}
+	if t.Revoked {
+		s += ", revoked"
+	}
+	return s
+}
+
+// tokens are the capability tokens minted for a session
+type tokens struct {
+	mu       sync.Mutex
+	bits     int
+	alphabet string
+	list     []*Token
+	// offsets are where the interrupted downloads of tokens with limited
+	// uses stopped, so that resuming them isn't counted as another use
+	offsets map[download]int64
+}
+
+// download identifies the download of a file by a client with a token
+type download struct {
+	token *Token
+	ip    string
+	name  string
+}
+
+// resumes reports whether a download starting at start picks up exactly
+// where the previous one of the same client stopped
+func (ts *tokens) resumes(d download, start int64) bool {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	next, ok := ts.offsets[d]
+	return ok && start > 0 && start == next
+}
+
+// served records that a download stopped at next, out of size bytes.
+// Complete downloads are forgotten, fetching the file again is another use
+func (ts *tokens) served(d download, next int64, size int64) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if next >= size {
+		delete(ts.offsets, d)
+		return
+	}
+	if ts.offsets == nil {
+		ts.offsets = make(map[download]int64)
+	}
+	ts.offsets[d] = next
+}
+
+// rangeStart returns the first byte of the Range header, or -1 when it
+// doesn't start at a given byte. Several ranges are refused, as they could
+// fetch the whole file without starting at the first byte
+func rangeStart(header string) (int64, error) {
+	if header == "" {
+		return 0, nil
+	}
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok {
+		return -1, nil
+	}
+	if strings.Contains(spec, ",") {
+		return 0, errors.New("several ranges are not supported")
+	}
+	first, _, _ := strings.Cut(spec, "-")
+	start, err := strconv.ParseInt(strings.TrimSpace(first), 10, 64)
+	if err != nil {
+		return -1, nil
+	}
+	return start, nil
+}
+
+// countingWriter counts the bytes of the body of a response
+type countingWriter struct {
+	http.ResponseWriter
+	status int
+	n      int64
+}
+
+func (w *countingWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// use checks that the token grants scope and is still valid. When consume
+// is set, a use is counted
+func (ts *tokens) use(t *Token, scope Scope, consume bool) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if err := t.valid(time.Now()); err != nil {
+		return err
+	}
+	if t.Scope&scope != scope {
+		return fmt.Errorf("this link doesn't grant the %s right", scope)
+	}
+	if consume {
+		t.Uses++
+	}
+	return nil
+}
+
+// MintToken creates a token with its own URL. Upload and delete rights need
+// a receive session, which has a folder
+func (s *Server) MintToken(spec TokenSpec) (Token, error) {
+	if spec.Scope == 0 {
+		return Token{}, errors.New("a token needs at least one scope")
+	}
+	if s.e2eKey != nil {
+		return Token{}, errors.New("tokens can't be used with --e2e")
+	}
+	if s.outputDir == "" && spec.Scope&(ScopeUpload|ScopeDelete) != 0 {
+		return Token{}, errors.New("upload and delete need a receive session")
+	}
+	secret, err := util.GetRandomURLPath(s.tokens.bits, s.tokens.alphabet)
+	if err != nil {
+		return Token{}, err
+	}
+	s.tokens.mu.Lock()
+	t := &Token{
+		ID:      len(s.tokens.list) + 1,
+		Scope:   spec.Scope,
+		MaxUses: spec.Uses,
+		URL:     s.BaseURL + "/t/" + secret + "/",
+		secret:  secret,
+	}
+	if spec.TTL > 0 {
+		t.Expires = time.Now().Add(spec.TTL)
+	}
+	s.tokens.list = append(s.tokens.list, t)
+	minted := *t
+	s.tokens.mu.Unlock()
+	// Revoked tokens keep their route, so that they get a page telling so
+	// while unknown ones are tarpitted
+	http.HandleFunc(s.basePath+"/t/"+secret+"/", s.audit.Handler(secret, s.approvals.Handler(s.serveToken(t))))
+	log.Printf("Minted token %d (%s)", t.ID, t.Describe())
+	return minted, nil
+}
+
+// RevokeToken revokes the token with id
+func (s *Server) RevokeToken(id int) error {
+	s.tokens.mu.Lock()
+	defer s.tokens.mu.Unlock()
+	if id < 1 || id > len(s.tokens.list) {
+		return fmt.Errorf("no token %d", id)
+	}
+	s.tokens.list[id-1].Revoked = true
+	log.Printf("Revoked token %d", id)
+	return nil
+}
+
+// Tokens returns the tokens minted so far
+func (s *Server) Tokens() []Token {
+	s.tokens.mu.Lock()
+	defer s.tokens.mu.Unlock()
+	list := []Token{}
+	for _, t := range s.tokens.list {
+		list = append(list, *t)
+	}
+	return list
+}
+
+// listedFile is a file listed on the page of a token or of an account
+type listedFile struct {
+	Name  string
+	Size  string
+	Route string
+}
+
+// tokenFiles lists the files a token gives access to: the folder of a
+// receive session, or the file sent
+func (s *Server) tokenFiles(route string) ([]listedFile, error) {
+	if s.outputDir == "" {
+		info, err := os.Stat(s.body.Path)
+		if err != nil {
+			return nil, err
+		}
+		return []listedFile{{s.body.Filename, style.FormatSize(info.Size()), route + "files/" + escapePath(s.body.Filename)}}, nil
+	}
+	return listFolder(s.outputDir, route+"files/")
+}
+
+// listFolder lists the regular files below dir, with their route below
+// route
+func listFolder(dir string, route string) ([]listedFile, error) {
+	files := []listedFile{}
+	err := filepath.WalkDir(dir, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() || len(files) >= maxListedFiles {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		files = append(files, listedFile{name, style.FormatSize(info.Size()), route + escapePath(name)})
+		return nil
+	})
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files, err
+}
+
+// escapePath escapes the segments of a slash separated path for a URL
+func escapePath(name string) string {
+	return (&url.URL{Path: name}).EscapedPath()
+}
+
+// tokenPath returns the path of the file named name for a token. Only
+// regular files below the folder of the session are served
+func (s *Server) tokenPath(name string) (string, error) {
+	if s.outputDir == "" {
+		if name != s.body.Filename {
+			return "", fs.ErrNotExist
+		}
+		return s.body.Path, nil
+	}
+	return folderPath(s.outputDir, name)
+}
+
+// folderPath returns the path of the regular file named name below dir,
+// which can't be escaped, not even through symbolic links
+func folderPath(dir string, name string) (string, error) {
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", err
+	}
+	file, err := filepath.EvalSymlinks(filepath.Join(root, filepath.FromSlash(path.Clean("/"+name))))
+	if err != nil {
+		return "", err
+	}
+	if rel, err := filepath.Rel(root, file); err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fs.ErrNotExist
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() {
+		return "", fs.ErrNotExist
+	}
+	return file, nil
+}
+
+// denyToken answers a request the token doesn't allow
+func denyToken(w http.ResponseWriter, err error) {
+	var gone errTokenGone
+	if errors.As(err, &gone) {
+		w.WriteHeader(http.StatusGone)
+		serveTemplate("gone", pages.Gone, w, struct{ Message string }{gone.Error()})
+		return
+	}
+	http.Error(w, err.Error(), http.StatusForbidden)
+}
+
+// serveToken serves the routes of a token: its page, the files, the upload
+// page and the deletion of files
+func (s *Server) serveToken(t *Token) http.HandlerFunc {
+	route := s.basePath + "/t/" + t.secret + "/"
+	return func(w http.ResponseWriter, r *http.Request) {
+		action := strings.TrimPrefix(r.URL.Path, route)
+		var scope Scope
+		var d download
+		var start int64
+		consume, resumed := false, false
+		switch {
+		case action == "":
+		case action == "upload":
+			scope, consume = ScopeUpload, r.Method == http.MethodPost
+		case action == "delete":
+			scope, consume = ScopeDelete, r.Method == http.MethodPost
+		case strings.HasPrefix(action, "files/"):
+			// Resuming a download where it stopped doesn't count as a use,
+			// any other range does
+			var err error
+			if start, err = rangeStart(r.Header.Get("Range")); err != nil {
+				http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+			// The range is always honored, so that it can't turn into the
+			// whole file
+			r.Header.Del("If-Range")
+			d = download{token: t, ip: remoteIP(r), name: strings.TrimPrefix(action, "files/")}
+			resumed = r.Method == http.MethodGet && s.tokens.resumes(d, start)
+			scope, consume = ScopeDownload, r.Method == http.MethodGet && !resumed
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		// The use of a resumed download was counted when it started
+		if err := s.tokens.use(t, scope, false); err != nil && !(resumed && err == errUsedUp) {
+			denyToken(w, err)
+			return
+		}
+		// A use is only counted once the request is about to be served
+		spend := func() bool {
+			if !consume {
+				return true
+			}
+			if err := s.tokens.use(t, scope, true); err != nil {
+				denyToken(w, err)
+				return false
+			}
+			return true
+		}
+		switch {
+		case action == "":
+			s.serveTokenPage(w, t, route)
+		case action == "upload":
+			if spend() {
+				s.receive(route+"upload", s.outputDir, true)(w, r)
+			}
+		case action == "delete":
+			if r.Method != http.MethodPost {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			name := r.FormValue("name")
+			file, err := s.tokenPath(name)
+			if err != nil {
+				http.NotFound(w, r)
+				return
+			}
+			if !spend() {
+				return
+			}
+			if err := os.Remove(file); err != nil {
+				http.Error(w, "Unable to delete the file", http.StatusInternalServerError)
+				return
+			}
+			log.Printf("Deleted %s with token %d", name, t.ID)
+			http.Redirect(w, r, route, http.StatusSeeOther)
+		default:
+			name := strings.TrimPrefix(action, "files/")
+			file, err := s.tokenPath(name)
+			if err != nil {
+				http.NotFound(w, r)
+				return
+			}
+			f, err := os.Open(file)
+			if err != nil {
+				http.NotFound(w, r)
+				return
+			}
+			defer f.Close()
+			info, err := f.Stat()
+			if err != nil {
+				http.Error(w, "Unable to open the file", http.StatusInternalServerError)
+				return
+			}
+			if !spend() {
+				return
+			}
+			w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(name)}))
+			if r.Method == http.MethodGet {
+				audit.AddFile(r.Context(), audit.File{Name: name, Size: info.Size()})
+			}
+			counter := &countingWriter{ResponseWriter: w}
+			http.ServeContent(counter, r, path.Base(name), info.ModTime(), f)
+			if r.Method == http.MethodGet && t.MaxUses > 0 {
+				switch counter.status {
+				case http.StatusOK:
+					s.tokens.served(d, counter.n, info.Size())
+				case http.StatusPartialContent:
+					if start < 0 {
+						break
+					}
+					s.tokens.served(d, start+counter.n, info.Size())
+				}
+			}
+		}
+	}
+}
+
+// serveTokenPage serves the page of a token, showing what its scope allows
+func (s *Server) serveTokenPage(w http.ResponseWriter, t *Token, route string) {
+	s.tokens.mu.Lock()
+	token := *t
+	s.tokens.mu.Unlock()
+	htmlVariables := struct {
+		Download bool
+		Upload   bool
+		List     bool
+		Delete   bool
+		Files    []listedFile
+		Route    string
+		Limits   string
+	}{
+		Download: token.Scope&ScopeDownload != 0,
+		Upload:   token.Scope&ScopeUpload != 0,
+		List:     token.Scope&ScopeList != 0,
+		Delete:   token.Scope&ScopeDelete != 0,
+		Route:    route,
+	}
+	if !token.Expires.IsZero() {
+		htmlVariables.Limits = "This link expires at " + token.Expires.Format(time.RFC1123) + "."
+	}
+	if token.MaxUses > 0 {
+		htmlVariables.Limits += fmt.Sprintf(" %d of its %d uses left.", token.MaxUses-token.Uses, token.MaxUses)
+	}
+	// The file sent is shown to download-only tokens too, there is nothing
+	// else to guess
+	if htmlVariables.List || (htmlVariables.Download && s.outputDir == "") {
+		files, err := s.tokenFiles(route)
+		if err != nil {
+			log.Printf("Unable to list the files: %v", err)
+		}
+		htmlVariables.Files = files
+	}
+	serveTemplate("token", pages.Token, w, htmlVariables)
+}
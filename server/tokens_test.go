@@ -0,0 +1,162 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTokenSpec(t *testing.T) {
+	tests := []struct {
+		spec string
+		want TokenSpec
+		err  bool
+	}{
+		{"read-only", TokenSpec{Scope: ScopeDownload | ScopeList}, false},
+		{"upload-only:1h", TokenSpec{Scope: ScopeUpload, TTL: time.Hour}, false},
+		{"download,list:30m:5", TokenSpec{Scope: ScopeDownload | ScopeList, TTL: 30 * time.Minute, Uses: 5}, false},
+		{"read-write::3", TokenSpec{Scope: ScopeDownload | ScopeUpload | ScopeList | ScopeDelete, Uses: 3}, false},
+		{"write", TokenSpec{}, true},
+		{"read-only:soon", TokenSpec{}, true},
+		{"read-only:1h:-1", TokenSpec{}, true},
+		{"read-only:1h:1:1", TokenSpec{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseTokenSpec(tt.spec)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("ParseTokenSpec(%q) = %+v, %v, want %+v", tt.spec, got, err, tt.want)
+		}
+	}
+}
+
+func TestFolderPath(t *testing.T) {
+	dir := t.TempDir()
+	outside := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("notes"), 0644)
+	os.Mkdir(filepath.Join(dir, "photos"), 0755)
+	os.WriteFile(filepath.Join(dir, "photos", "cat.jpg"), []byte("cat"), 0644)
+	os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0644)
+	if err := os.Symlink(outside, filepath.Join(dir, "escape")); err != nil {
+		t.Skipf("no symbolic links: %v", err)
+	}
+	os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(dir, "secret.txt"))
+	os.Symlink(filepath.Join(dir, "notes.txt"), filepath.Join(dir, "link.txt"))
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"notes.txt", true},
+		{"photos/cat.jpg", true},
+		{"link.txt", true},
+		{"../" + filepath.Base(outside) + "/secret.txt", false},
+		{"escape/secret.txt", false},
+		{"secret.txt", false},
+		{"photos", false},
+		{"", false},
+		{"missing.txt", false},
+	}
+	for _, tt := range tests {
+		if _, err := folderPath(dir, tt.name); (err == nil) != tt.ok {
+			t.Errorf("folderPath(%q) error = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestServeToken(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("notes"), 0644)
+	os.Mkdir(filepath.Join(dir, "photos"), 0755)
+	os.WriteFile(filepath.Join(dir, "photos", "cat one.jpg"), []byte("cat"), 0644)
+	s := &Server{outputDir: dir, tokens: &tokens{}}
+	get := func(token *Token, method string, route string, form url.Values) *httptest.ResponseRecorder {
+		var r *http.Request
+		if form != nil {
+			r = httptest.NewRequest(method, "/t/"+token.secret+"/"+route, strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			r = httptest.NewRequest(method, "/t/"+token.secret+"/"+route, nil)
+		}
+		w := httptest.NewRecorder()
+		s.serveToken(token)(w, r)
+		return w
+	}
+	readOnly := &Token{Scope: ScopeDownload | ScopeList, MaxUses: 2, secret: "ro"}
+	s.tokens.list = append(s.tokens.list, readOnly)
+	if w := get(readOnly, "GET", "", nil); !strings.Contains(w.Body.String(), "photos/cat%20one.jpg") || strings.Contains(w.Body.String(), "Delete") {
+		t.Fatalf("page of a read-only token:\n%s", w.Body)
+	}
+	if w := get(readOnly, "GET", "files/photos/cat%20one.jpg", nil); w.Code != http.StatusOK || w.Body.String() != "cat" {
+		t.Fatalf("download = %d %q", w.Code, w.Body)
+	}
+	if w := get(readOnly, "GET", "files/../../etc/passwd", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("download outside the folder = %d", w.Code)
+	}
+	if w := get(readOnly, "POST", "delete", url.Values{"name": {"notes.txt"}}); w.Code != http.StatusForbidden {
+		t.Fatalf("delete with a read-only token = %d", w.Code)
+	}
+	// The second download uses the token up
+	get(readOnly, "GET", "files/notes.txt", nil)
+	if w := get(readOnly, "GET", "", nil); w.Code != http.StatusGone {
+		t.Fatalf("page of a used up token = %d", w.Code)
+	}
+	readWrite := &Token{Scope: ScopeDownload | ScopeUpload | ScopeList | ScopeDelete, secret: "rw"}
+	s.tokens.list = append(s.tokens.list, readWrite)
+	if w := get(readWrite, "POST", "delete", url.Values{"name": {"notes.txt"}}); w.Code != http.StatusSeeOther {
+		t.Fatalf("delete = %d", w.Code)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "notes.txt")); !os.IsNotExist(err) {
+		t.Fatal("the file was not deleted")
+	}
+	if err := s.RevokeToken(2); err != nil {
+		t.Fatal(err)
+	}
+	if w := get(readWrite, "GET", "", nil); w.Code != http.StatusGone || !strings.Contains(w.Body.String(), "revoked") {
+		t.Fatalf("page of a revoked token = %d", w.Code)
+	}
+	expired := &Token{Scope: ScopeDownload, Expires: time.Now().Add(-time.Second), secret: "old"}
+	if w := get(expired, "GET", "files/photos/cat%20one.jpg", nil); w.Code != http.StatusGone {
+		t.Fatalf("download with an expired token = %d", w.Code)
+	}
+}
+
+func TestServeTokenRanges(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("0123456789"), 0644)
+	s := &Server{outputDir: dir, tokens: &tokens{}}
+	token := &Token{Scope: ScopeDownload, MaxUses: 2, secret: "once"}
+	get := func(byteRange string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/t/once/files/notes.txt", nil)
+		if byteRange != "" {
+			r.Header.Set("Range", byteRange)
+		}
+		w := httptest.NewRecorder()
+		s.serveToken(token)(w, r)
+		return w
+	}
+	// Several ranges could fetch the whole file for free
+	if w := get("bytes=1-,0-0"); w.Code != http.StatusRequestedRangeNotSatisfiable || token.Uses != 0 {
+		t.Fatalf("several ranges = %d, %d uses", w.Code, token.Uses)
+	}
+	// A range not resuming a download is a use, even if it skips the
+	// first byte
+	if w := get("bytes=1-"); w.Code != http.StatusPartialContent || token.Uses != 1 {
+		t.Fatalf("range = %d, %d uses", w.Code, token.Uses)
+	}
+	// Resuming an interrupted download is not
+	get("bytes=0-3")
+	if token.Uses != 2 {
+		t.Fatalf("%d uses, want 2", token.Uses)
+	}
+	if w := get("bytes=4-"); w.Code != http.StatusPartialContent || w.Body.String() != "456789" || token.Uses != 2 {
+		t.Fatalf("resume = %d %q, %d uses", w.Code, w.Body, token.Uses)
+	}
+	// The file was fetched twice, the token is used up
+	if w := get("bytes=0-0"); w.Code != http.StatusGone {
+		t.Fatalf("download with a used up token = %d", w.Code)
+	}
+}
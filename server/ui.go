@@ -2,8 +2,11 @@ package server
 
 import (
 	"fmt"
-	"github.com/claudiodangelis/qrcp/style"
+	"strings"
 	"time"
+
+	"github.com/claudiodangelis/qrcp/audit"
+	"github.com/claudiodangelis/qrcp/style"
 )
 
 // ShowStartupBanner displays the initial MOCP banner and info
@@ -46,6 +49,17 @@ func ShowError(err error) {
 	fmt.Println(style.ErrorMessage(err.Error()))
 }
 
+// ShowWarning displays a warning message with styling
+func ShowWarning(msg string) {
+	fmt.Printf("%s⚠ %s%s\n", style.BrightYellow, msg, style.Reset)
+}
+
+// ShowApprovalPrompt asks the operator whether to accept a new device
+func ShowApprovalPrompt(ip, description string) {
+	fmt.Printf("\n%s❓ Accept connection from %s%s%s (%s)? [y/N]%s\n",
+		style.BrightYellow, style.BrightWhite, ip, style.BrightYellow, description, style.Reset)
+}
+
 // ShowInterfaceSelection shows available network interfaces
 func ShowInterfaceSelection(interfaces map[string]string) {
 	fmt.Println(style.InfoBox("Network Interfaces", "Choose an interface to use for transfer:"))
@@ -61,6 +75,54 @@ func ShowInterfaceSelection(interfaces map[string]string) {
 	}
 }
 
+// ShowClientSummary displays a table of the clients that connected to the
+// server, with the amount of data they transferred
+func ShowClientSummary(clients []audit.Client) {
+	if len(clients) == 0 {
+		return
+	}
+	fmt.Println(style.InfoBox("Clients", fmt.Sprintf("%d client(s) connected", len(clients))))
+	fmt.Printf("%s%-40s %-24s %8s %12s %12s%s\n", style.Bold,
+		"Client", "User agent", "Requests", "Uploaded", "Downloaded", style.Reset)
+	for _, c := range clients {
+		userAgent := c.UserAgent
+		if len(userAgent) > 24 {
+			userAgent = userAgent[:21] + "..."
+		}
+		fmt.Printf("%-40s %-24s %8d %12s %12s\n", c.RemoteIP, userAgent, c.Requests,
+			style.FormatSize(c.BytesIn), style.FormatSize(c.BytesOut))
+		if len(c.Files) > 0 {
+			fmt.Printf("  %s↳ %s%s\n", style.BrightBlack, strings.Join(c.Files, ", "), style.Reset)
+		}
+	}
+}
+
+// ShowDecryptHint tells how to decrypt the files received with end-to-end
+// encryption
+func ShowDecryptHint(key string, paths []string) {
+	if len(paths) == 0 {
+		return
+	}
+	fmt.Println(style.InfoBox("Encrypted", "The received files are stored encrypted, decrypt them with:"))
+	fmt.Printf("    %smocp decrypt --key %s %s%s\n", style.BrightYellow, key, strings.Join(paths, " "), style.Reset)
+}
+
+// ShowArchivePassword reminds that the archive is encrypted, with the
+// password when it was generated
+func ShowArchivePassword(generated string) {
+	msg := "The archive is encrypted with AES-256. Share the password separately, it is not in the QR code"
+	if generated != "" {
+		msg = fmt.Sprintf("Password: %s%s%s\n%s", style.BrightYellow, generated, style.Reset, msg)
+	}
+	fmt.Println(style.InfoBox("Encrypted archive", msg))
+}
+
+// ShowSigned tells that the file sent is signed, with the fingerprint the
+// recipients compare
+func ShowSigned(fingerprint string) {
+	fmt.Println(style.InfoBox("Signed", "Recipients can verify the file with mocp verify, the fingerprint of your identity is:\n"+fingerprint))
+}
+
 // ShowFileInfo displays information about the file being transferred
 func ShowFileInfo(filename string, size int64) {
 	info := fmt.Sprintf("File: %s\nSize: %s", filename, style.FormatSize(size))
@@ -4,6 +4,11 @@ import (
 	"fmt"
 	"html/template"
 	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"path"
 	"path/filepath"
 	"strings"
 )
@@ -36,3 +41,83 @@ func getFileName(newFilename string, fileNamesInTargetDir []string) string {
 	}
 	return newFilename
 }
+
+// getSuffixedFileName works like getFileName for a file stored with suffix
+// appended to its name, numbering the name before the suffix so that it
+// survives removing the suffix
+func getSuffixedFileName(newFilename string, suffix string, fileNamesInTargetDir []string) string {
+	taken := []string{}
+	for _, name := range fileNamesInTargetDir {
+		if strings.HasSuffix(name, suffix) {
+			taken = append(taken, strings.TrimSuffix(name, suffix))
+		}
+	}
+	return getFileName(newFilename, taken) + suffix
+}
+
+// uploadPath returns the folder and the name of an uploaded file. The
+// filename of the part may be a relative path, which is kept as long as it
+// stays below the output directory
+func uploadPath(part *multipart.Part) (dir string, name string) {
+	name = part.FileName()
+	_, params, err := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+	if err != nil {
+		return "", name
+	}
+	rel := path.Clean("/" + strings.ReplaceAll(params["filename"], "\\", "/"))
+	name = path.Base(rel)
+	dir = strings.TrimPrefix(path.Dir(rel), "/")
+	if dir == "" || dir == "." {
+		return "", name
+	}
+	return filepath.FromSlash(dir), name
+}
+
+// remoteIP returns the IP address of the client that sent r
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// describeUserAgent returns a short description of a user agent, such as
+// "Android Chrome"
+func describeUserAgent(agent string) string {
+	var platform, browser string
+	switch {
+	case strings.Contains(agent, "Android"):
+		platform = "Android"
+	case strings.Contains(agent, "iPhone"):
+		platform = "iPhone"
+	case strings.Contains(agent, "iPad"):
+		platform = "iPad"
+	case strings.Contains(agent, "Windows"):
+		platform = "Windows"
+	case strings.Contains(agent, "Mac OS"):
+		platform = "macOS"
+	case strings.Contains(agent, "Linux"):
+		platform = "Linux"
+	}
+	switch {
+	case strings.Contains(agent, "Edg/"):
+		browser = "Edge"
+	case strings.Contains(agent, "Firefox/"), strings.Contains(agent, "FxiOS/"):
+		browser = "Firefox"
+	case strings.Contains(agent, "Chrome/"), strings.Contains(agent, "CriOS/"):
+		browser = "Chrome"
+	case strings.Contains(agent, "Safari/"):
+		browser = "Safari"
+	}
+	if platform == "" && browser == "" {
+		if agent == "" {
+			return "unknown client"
+		}
+		if len(agent) > 40 {
+			return agent[:37] + "..."
+		}
+		return agent
+	}
+	return strings.TrimSpace(platform + " " + browser)
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"bytes"
+	"mime/multipart"
+	"path/filepath"
+	"testing"
+)
+
+func TestUploadPath(t *testing.T) {
+	tests := []struct {
+		filename string
+		dir      string
+		name     string
+	}{
+		{"file.txt", "", "file.txt"},
+		{"photos/2024/file.jpg", filepath.FromSlash("photos/2024"), "file.jpg"},
+		{"../../etc/passwd", "etc", "passwd"},
+		{"/abs/file.txt", "abs", "file.txt"},
+		{`win\dir\file.txt`, "win" + string(filepath.Separator) + "dir", "file.txt"},
+	}
+	for _, tt := range tests {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		mw.CreateFormFile("files", tt.filename)
+		mw.Close()
+		part, err := multipart.NewReader(&body, mw.Boundary()).NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		dir, name := uploadPath(part)
+		if dir != tt.dir || name != tt.name {
+			t.Errorf("uploadPath(%q) = %q, %q, want %q, %q", tt.filename, dir, name, tt.dir, tt.name)
+		}
+	}
+}
+
+func TestGetSuffixedFileName(t *testing.T) {
+	names := []string{"notes.txt", "report.pdf.age", "report(1).pdf.age"}
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"notes.txt", "notes.txt.age"},
+		{"report.pdf", "report(2).pdf.age"},
+		{"new.pdf", "new.pdf.age"},
+	}
+	for _, tt := range tests {
+		if got := getSuffixedFileName(tt.name, ".age", names); got != tt.want {
+			t.Errorf("getSuffixedFileName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
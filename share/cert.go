@@ -0,0 +1,87 @@
+package share
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// certValidity is how long generated certificates are valid, as printed QR
+// codes are meant to last
+const certValidity = 10 * 365 * 24 * time.Hour
+
+// GenerateCertificate writes a self-signed certificate for hosts, and its
+// key, to the folder of the share. Devices that trust it once keep trusting
+// it across restarts. The key is RSA, as the server only offers RSA cipher
+// suites to TLS 1.2 clients
+func (s *Share) GenerateCertificate(hosts []string) error {
+	key, err := rsa.GenerateKey(rand.Reader, 3072)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "mocp share " + s.Name},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(certValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			if !ip.IsUnspecified() {
+				template.IPAddresses = append(template.IPAddresses, ip)
+			}
+		} else if h != "" {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := writePEM(filepath.Join(s.dir, KeyFile), "PRIVATE KEY", keyDER, 0600); err != nil {
+		return err
+	}
+	return writePEM(filepath.Join(s.dir, CertFile), "CERTIFICATE", der, 0644)
+}
+
+// CopyCertificate copies the certificate and the key at the paths to the
+// folder of the share
+func (s *Share) CopyCertificate(certPath string, keyPath string) error {
+	for _, f := range []struct {
+		from string
+		to   string
+		perm os.FileMode
+	}{{certPath, CertFile, 0644}, {keyPath, KeyFile, 0600}} {
+		b, err := os.ReadFile(f.from)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(s.dir, f.to), b, f.perm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writePEM(path string, blockType string, der []byte, perm os.FileMode) error {
+	return os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), perm)
+}
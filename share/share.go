@@ -0,0 +1,218 @@
+package share
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+
+	"github.com/adrg/xdg"
+	"github.com/claudiodangelis/qrcp/application"
+	"gopkg.in/yaml.v2"
+)
+
+// fileName is the name of the settings of a share in its folder
+const fileName = "share.yml"
+
+// Names of the TLS material of a share in its folder
+const (
+	CertFile = "cert.pem"
+	KeyFile  = "key.pem"
+)
+
+// validName restricts share names to what is safe as a folder name
+var validName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]*$`)
+
+// Share is a named share kept in the configuration folder, so that it comes
+// back up at the exact same URL each time it is started
+type Share struct {
+	Name string `yaml:"name"`
+	// Receive is the folder receiving the files, Send the files sent
+	Receive string   `yaml:"receive,omitempty"`
+	Send    []string `yaml:"send,omitempty"`
+	// Path is the random token of the URL
+	Path      string `yaml:"path"`
+	Interface string `yaml:"interface,omitempty"`
+	Bind      string `yaml:"bind,omitempty"`
+	FQDN      string `yaml:"fqdn,omitempty"`
+	Port      int    `yaml:"port"`
+	Secure    bool   `yaml:"secure,omitempty"`
+	// Policy
+	Allow      []string `yaml:"allow,omitempty"`
+	Deny       []string `yaml:"deny,omitempty"`
+	LanOnly    bool     `yaml:"lan-only,omitempty"`
+	RateLimit  int      `yaml:"rate-limit,omitempty"`
+	Confirm    bool     `yaml:"confirm,omitempty"`
+	AuditLog   string   `yaml:"audit-log,omitempty"`
+	EncryptTo  string   `yaml:"encrypt-to,omitempty"`
+	Scan       string   `yaml:"scan,omitempty"`
+	Quarantine string   `yaml:"quarantine,omitempty"`
+	// dir is the folder of the share
+	dir string
+}
+
+// Dir returns where the shares of the application name are stored, in its
+// folder below the XDG configuration folder
+func Dir(name string) string {
+	return filepath.Join(xdg.ConfigHome, name, "shares")
+}
+
+// ValidateName returns an error if name can't name a share
+func ValidateName(name string) error {
+	if !validName.MatchString(name) {
+		return fmt.Errorf("invalid share name %q, use letters, digits, - and _", name)
+	}
+	return nil
+}
+
+// New returns an empty share named name, stored below dir
+func New(dir string, name string) (*Share, error) {
+	if err := ValidateName(name); err != nil {
+		return nil, err
+	}
+	return &Share{Name: name, dir: filepath.Join(dir, name)}, nil
+}
+
+// Load reads the share named name below dir
+func Load(dir string, name string) (*Share, error) {
+	if err := ValidateName(name); err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(filepath.Join(dir, name, fileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("there is no share named %q, create it with `share create`", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s := &Share{}
+	if err := yaml.Unmarshal(b, s); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	s.Name = name
+	s.dir = filepath.Join(dir, name)
+	return s, nil
+}
+
+// List returns the shares below dir, sorted by name
+func List(dir string) ([]*Share, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	shares := []*Share{}
+	for _, e := range entries {
+		if !e.IsDir() || ValidateName(e.Name()) != nil {
+			continue
+		}
+		s, err := Load(dir, e.Name())
+		if err != nil {
+			return nil, err
+		}
+		shares = append(shares, s)
+	}
+	sort.Slice(shares, func(i, j int) bool { return shares[i].Name < shares[j].Name })
+	return shares, nil
+}
+
+// Delete removes the share named name below dir, along with its TLS
+// material
+func Delete(dir string, name string) error {
+	if _, err := Load(dir, name); err != nil {
+		return err
+	}
+	return os.RemoveAll(filepath.Join(dir, name))
+}
+
+// Dir returns the folder of the share
+func (s *Share) Dir() string {
+	return s.dir
+}
+
+// Create makes the folder of the share. It fails if the share exists
+func (s *Share) Create() error {
+	if err := os.MkdirAll(filepath.Dir(s.dir), 0700); err != nil {
+		return err
+	}
+	if err := os.Mkdir(s.dir, 0700); err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("the share %q already exists", s.Name)
+		}
+		return err
+	}
+	return nil
+}
+
+// Save writes the settings of the share to its folder
+func (s *Share) Save() error {
+	b, err := yaml.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(s.dir, fileName), b, 0600)
+}
+
+// Mode returns whether the share receives or sends files
+func (s *Share) Mode() string {
+	if s.Receive != "" {
+		return "receive"
+	}
+	return "send"
+}
+
+// Apply sets flags to the settings of the share, so that the server starts
+// at the same URL and with the same policy as the last time
+func (s *Share) Apply(flags *application.Flags) {
+	flags.Path = s.Path
+	flags.Interface = s.Interface
+	flags.Bind = s.Bind
+	flags.FQDN = s.FQDN
+	flags.Port = s.Port
+	flags.KeepAlive = true
+	if s.Receive != "" {
+		flags.Output = s.Receive
+	}
+	if s.Secure {
+		flags.Secure = true
+		flags.TlsCert = filepath.Join(s.dir, CertFile)
+		flags.TlsKey = filepath.Join(s.dir, KeyFile)
+	}
+	if len(s.Allow) > 0 {
+		flags.Allow = s.Allow
+	}
+	if len(s.Deny) > 0 {
+		flags.Deny = s.Deny
+	}
+	if s.RateLimit != 0 {
+		flags.RateLimit = s.RateLimit
+	}
+	flags.LanOnly = flags.LanOnly || s.LanOnly
+	flags.Confirm = flags.Confirm || s.Confirm
+	if s.AuditLog != "" {
+		flags.AuditLog = s.AuditLog
+	}
+	if s.EncryptTo != "" {
+		flags.EncryptTo = s.EncryptTo
+	}
+	if s.Scan != "" {
+		flags.Scan = s.Scan
+		flags.Quarantine = s.Quarantine
+	}
+}
+
+// FreePort returns a port free on bind, to be kept by a share
+func FreePort(bind string) (int, error) {
+	listener, err := net.Listen("tcp", net.JoinHostPort(bind, "0"))
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
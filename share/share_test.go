@@ -0,0 +1,88 @@
+package share
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/claudiodangelis/qrcp/application"
+)
+
+func TestSaveLoad(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := New(dir, "../inbox"); err == nil {
+		t.Fatal("a name with a slash should be refused")
+	}
+	s, err := New(dir, "inbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Receive = "/srv/inbox"
+	s.Path = "k3jd8s0a"
+	s.Interface = "eth0"
+	s.Port = 8443
+	s.Secure = true
+	s.Allow = []string{"10.0.0.0/8"}
+	if err := s.Create(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Create(); err == nil {
+		t.Fatal("creating a share twice should fail")
+	}
+	loaded, err := Load(dir, "inbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, s) {
+		t.Fatalf("loaded %+v, want %+v", loaded, s)
+	}
+	flags := application.Flags{Port: 1}
+	loaded.Apply(&flags)
+	if flags.Path != s.Path || flags.Port != 8443 || flags.Output != "/srv/inbox" || !flags.KeepAlive ||
+		flags.TlsCert != filepath.Join(dir, "inbox", CertFile) || !reflect.DeepEqual(flags.Allow, s.Allow) {
+		t.Fatalf("flags = %+v", flags)
+	}
+	if shares, err := List(dir); err != nil || len(shares) != 1 || shares[0].Name != "inbox" {
+		t.Fatalf("List = %v, %v", shares, err)
+	}
+	if err := Delete(dir, "inbox"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(dir, "inbox"); err == nil {
+		t.Fatal("the share was not deleted")
+	}
+}
+
+func TestGenerateCertificate(t *testing.T) {
+	s, err := New(t.TempDir(), "bench")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Create(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.GenerateCertificate([]string{"", "files.lab", "192.168.1.20", "0.0.0.0"}); err != nil {
+		t.Fatal(err)
+	}
+	pair, err := tls.LoadX509KeyPair(filepath.Join(s.Dir(), CertFile), filepath.Join(s.Dir(), KeyFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, host := range []string{"files.lab", "192.168.1.20"} {
+		if err := cert.VerifyHostname(host); err != nil {
+			t.Error(err)
+		}
+	}
+	if len(cert.IPAddresses) != 1 {
+		t.Errorf("IP addresses = %v", cert.IPAddresses)
+	}
+}
@@ -0,0 +1,276 @@
+package users
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v2"
+)
+
+// Cost is the bcrypt cost of the hashed passwords
+const Cost = 12
+
+// MinPasswordLength is the length below which passwords are refused
+const MinPasswordLength = 8
+
+// validName restricts user names to what is safe as a folder name
+var validName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+
+// ErrUnknownUser is returned when a user doesn't exist
+var ErrUnknownUser = errors.New("there is no such user")
+
+// dummyHash is compared with the passwords of unknown users, so that they
+// take as long to refuse as wrong passwords. It is computed on first use
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("mocp"), Cost)
+	return hash
+})
+
+// file is the YAML form of the users file
+type file struct {
+	Users map[string]string `yaml:"users"`
+}
+
+// Store holds the accounts of the users file, a YAML file mapping names to
+// bcrypt hashes, or an htpasswd file with bcrypt hashes (htpasswd -B). The
+// file is read again when it changes, so that accounts edited while the
+// server runs apply right away
+type Store struct {
+	path     string
+	htpasswd bool
+	mu       sync.Mutex
+	modified time.Time
+	hashes   map[string]string
+}
+
+// Load reads the users file at path. Files ending in .yml or .yaml are YAML,
+// others htpasswd. A missing file is an empty store, created on Save
+func Load(path string) (*Store, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	s := &Store{path: path, htpasswd: ext != ".yml" && ext != ".yaml", hashes: map[string]string{}}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reload(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return s, nil
+}
+
+// reload reads the file again if it changed since it was last read
+func (s *Store) reload() error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(s.modified) {
+		return nil
+	}
+	b, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	hashes, err := parse(b, s.htpasswd)
+	if err != nil {
+		return fmt.Errorf("%s: %w", s.path, err)
+	}
+	s.hashes = hashes
+	s.modified = info.ModTime()
+	return nil
+}
+
+// parse reads the users and their hashes from b
+func parse(b []byte, htpasswd bool) (map[string]string, error) {
+	hashes := map[string]string{}
+	if !htpasswd {
+		f := file{}
+		if err := yaml.Unmarshal(b, &f); err != nil {
+			return nil, err
+		}
+		for name, hash := range f.Users {
+			hashes[name] = hash
+		}
+	} else {
+		scanner := bufio.NewScanner(bytes.NewReader(b))
+		for line := 1; scanner.Scan(); line++ {
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" || strings.HasPrefix(text, "#") {
+				continue
+			}
+			name, hash, ok := strings.Cut(text, ":")
+			if !ok {
+				return nil, fmt.Errorf("line %d: expected name:hash", line)
+			}
+			hashes[name] = hash
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	for name, hash := range hashes {
+		if err := ValidateName(name); err != nil {
+			return nil, err
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("the password of %s is not a bcrypt hash, only bcrypt is supported", name)
+		}
+	}
+	return hashes, nil
+}
+
+// ValidateName returns an error if name can't name a user
+func ValidateName(name string) error {
+	if !validName.MatchString(name) {
+		return fmt.Errorf("invalid user name %q, use letters, digits, ., - and _", name)
+	}
+	return nil
+}
+
+// Authenticate checks the password of the user name, and returns the hash
+// it matched, which changes with the password
+func (s *Store) Authenticate(name string, password string) (string, bool) {
+	s.mu.Lock()
+	if err := s.reload(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		s.mu.Unlock()
+		return "", false
+	}
+	hash, ok := s.hashes[name]
+	s.mu.Unlock()
+	if !ok {
+		bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
+		return "", false
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return "", false
+	}
+	return hash, true
+}
+
+// Current reports whether the user name still exists with the password
+// hash, that is whether a session opened with it is still valid
+func (s *Store) Current(name string, hash string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reload(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return false
+	}
+	current, ok := s.hashes[name]
+	return ok && current == hash
+}
+
+// Exists reports whether the user name exists
+func (s *Store) Exists(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reload()
+	_, ok := s.hashes[name]
+	return ok
+}
+
+// Names returns the names of the users, sorted
+func (s *Store) Names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reload()
+	names := make([]string, 0, len(s.hashes))
+	for name := range s.hashes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Add creates the user name with password
+func (s *Store) Add(name string, password string) error {
+	if err := ValidateName(name); err != nil {
+		return err
+	}
+	if s.Exists(name) {
+		return fmt.Errorf("the user %s already exists", name)
+	}
+	return s.set(name, password)
+}
+
+// SetPassword changes the password of the user name, which closes the
+// sessions opened with the old one
+func (s *Store) SetPassword(name string, password string) error {
+	if !s.Exists(name) {
+		return ErrUnknownUser
+	}
+	return s.set(name, password)
+}
+
+func (s *Store) set(name string, password string) error {
+	if len(password) < MinPasswordLength {
+		return fmt.Errorf("the password must have at least %d characters", MinPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), Cost)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.hashes[name] = string(hash)
+	s.mu.Unlock()
+	return s.Save()
+}
+
+// Delete removes the user name. Their files are kept
+func (s *Store) Delete(name string) error {
+	s.mu.Lock()
+	_, exists := s.hashes[name]
+	delete(s.hashes, name)
+	s.mu.Unlock()
+	if !exists {
+		return ErrUnknownUser
+	}
+	return s.Save()
+}
+
+// Save writes the users to the file, in its format. The file is replaced
+// at once, so that a running server never reads it half written
+func (s *Store) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var b []byte
+	if s.htpasswd {
+		names := make([]string, 0, len(s.hashes))
+		for name := range s.hashes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var buf bytes.Buffer
+		for _, name := range names {
+			fmt.Fprintf(&buf, "%s:%s\n", name, s.hashes[name])
+		}
+		b = buf.Bytes()
+	} else {
+		var err error
+		if b, err = yaml.Marshal(file{Users: s.hashes}); err != nil {
+			return err
+		}
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	s.modified = info.ModTime()
+	return nil
+}
@@ -0,0 +1,89 @@
+package users
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestStore(t *testing.T) {
+	for _, name := range []string{"users.yml", "htpasswd"} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			s, err := Load(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Add("alice", "short"); err == nil {
+				t.Fatal("a short password should be refused")
+			}
+			if err := s.Add("../alice", "correct horse"); err == nil {
+				t.Fatal("a name with a slash should be refused")
+			}
+			if err := s.Add("alice", "correct horse"); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Add("alice", "correct horse"); err == nil {
+				t.Fatal("adding a user twice should fail")
+			}
+			loaded, err := Load(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			hash, ok := loaded.Authenticate("alice", "correct horse")
+			if !ok {
+				t.Fatal("the password of alice was refused")
+			}
+			if _, ok := loaded.Authenticate("alice", "wrong horse"); ok {
+				t.Fatal("a wrong password was accepted")
+			}
+			if _, ok := loaded.Authenticate("bob", "correct horse"); ok {
+				t.Fatal("an unknown user was accepted")
+			}
+			// Changes made by another store apply to the loaded one
+			if err := s.SetPassword("alice", "battery staple"); err != nil {
+				t.Fatal(err)
+			}
+			os.Chtimes(path, time.Now(), time.Now().Add(time.Second))
+			if loaded.Current("alice", hash) {
+				t.Fatal("the session survived the change of password")
+			}
+			if err := s.Delete("alice"); err != nil {
+				t.Fatal(err)
+			}
+			os.Chtimes(path, time.Now(), time.Now().Add(2*time.Second))
+			if loaded.Exists("alice") || loaded.Current("alice", "") {
+				t.Fatal("alice was not deleted")
+			}
+			if err := s.Delete("alice"); err != ErrUnknownUser {
+				t.Fatalf("deleting an unknown user = %v", err)
+			}
+		})
+	}
+}
+
+func TestLoadHtpasswd(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// htpasswd -B writes $2y$ hashes
+	y := strings.Replace(string(hash), "$2a$", "$2y$", 1)
+	path := filepath.Join(t.TempDir(), ".htpasswd")
+	os.WriteFile(path, []byte("# team\nalice:"+y+"\n"), 0600)
+	s, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Authenticate("alice", "correct horse"); !ok {
+		t.Fatal("the $2y$ hash was refused")
+	}
+	os.WriteFile(path, []byte("bob:$apr1$abc$def\n"), 0600)
+	if _, err := Load(path); err == nil {
+		t.Fatal("an MD5 hash should be refused")
+	}
+}
@@ -1,8 +1,11 @@
 package util
 
 import (
+	"errors"
 	"net"
 	"regexp"
+	"sort"
+	"strings"
 
 	externalip "github.com/glendc/go-external-ip"
 )
@@ -26,14 +29,47 @@ func Interfaces(listAll bool) (map[string]string, error) {
 		if err != nil {
 			continue
 		}
+		// Interfaces with a link-local address only are rarely reachable
+		if !listAll && strings.Contains(ip, "%") {
+			continue
+		}
 		names[iface.Name] = ip
 	}
 	return names, nil
 }
 
-// GetExternalIP of this host
-func GetExternalIP() (net.IP, error) {
+// AllLAN is the interface name used to listen on every suitable interface
+const AllLAN = "all-lan"
+
+// AllLANAddresses returns the names and the addresses of all the suitable
+// interfaces, sorted by name
+func AllLANAddresses() ([]string, []string, error) {
+	interfaces, err := Interfaces(false)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(interfaces) == 0 {
+		return nil, nil, errors.New("no interfaces found")
+	}
+	names := make([]string, 0, len(interfaces))
+	for name := range interfaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	addrs := make([]string, 0, len(names))
+	for _, name := range names {
+		addrs = append(addrs, interfaces[name])
+	}
+	return names, addrs, nil
+}
+
+// GetExternalIP of this host. If version is 4 or 6, only addresses of
+// that IP version are considered
+func GetExternalIP(version int) (net.IP, error) {
 	consensus := externalip.DefaultConsensus(nil, nil)
+	if err := consensus.UseIPProtocol(uint(version)); err != nil {
+		return nil, err
+	}
 	// Get your IP, which is never <nil> when err is <nil>
 	ip, err := consensus.ExternalIP()
 	if err != nil {
@@ -41,3 +77,42 @@ func GetExternalIP() (net.IP, error) {
 	}
 	return ip, nil
 }
+
+// InterfaceNetworks returns the networks the named interface is attached to.
+// If the interface is "any", the networks of all the suitable interfaces are
+// returned
+func InterfaceNetworks(ifaceString string) ([]*net.IPNet, error) {
+	var ifaces []net.Interface
+	if ifaceString == "any" || ifaceString == AllLAN {
+		names, err := Interfaces(false)
+		if err != nil {
+			return nil, err
+		}
+		for name := range names {
+			iface, err := net.InterfaceByName(name)
+			if err != nil {
+				return nil, err
+			}
+			ifaces = append(ifaces, *iface)
+		}
+	} else {
+		iface, err := net.InterfaceByName(ifaceString)
+		if err != nil {
+			return nil, err
+		}
+		ifaces = append(ifaces, *iface)
+	}
+	networks := []*net.IPNet{}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok {
+				networks = append(networks, ipnet)
+			}
+		}
+	}
+	return networks, nil
+}
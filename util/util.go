@@ -4,7 +4,10 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"errors"
+	"fmt"
 	"io"
+	"math"
+	"math/big"
 	"net"
 	"os"
 	"os/user"
@@ -12,7 +15,6 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/jhoonb/archivex"
 )
@@ -82,11 +84,101 @@ func ZipFiles(files []string) (string, error) {
 	return zip.Name, nil
 }
 
-// GetRandomURLPath returns a random string of 4 alphanumeric characters
-func GetRandomURLPath() string {
-	timeNum := time.Now().UTC().UnixNano()
-	alphaString := strconv.FormatInt(timeNum, 36)
-	return alphaString[len(alphaString)-4:]
+// DefaultTokenBits is the entropy of generated URL tokens when not configured
+const DefaultTokenBits = 128
+
+// MinTokenBits is the entropy below which a URL token is considered weak
+const MinTokenBits = 64
+
+// DefaultTokenAlphabet is used to encode URL tokens. Lowercase letters and
+// digits survive being read aloud, typed on a phone and case-folded by proxies
+const DefaultTokenAlphabet = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+// GetRandomURLPath returns a random token carrying at least `bits` bits of
+// entropy, drawn from a CSPRNG and encoded with the given alphabet.
+// Zero values fall back to DefaultTokenBits and DefaultTokenAlphabet
+func GetRandomURLPath(bits int, alphabet string) (string, error) {
+	if bits <= 0 {
+		bits = DefaultTokenBits
+	}
+	if alphabet == "" {
+		alphabet = DefaultTokenAlphabet
+	}
+	symbols, err := tokenSymbols(alphabet)
+	if err != nil {
+		return "", err
+	}
+	length := int(math.Ceil(float64(bits) / math.Log2(float64(len(symbols)))))
+	max := big.NewInt(int64(len(symbols)))
+	token := make([]rune, length)
+	for i := range token {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		token[i] = symbols[n.Int64()]
+	}
+	return string(token), nil
+}
+
+// tokenSymbols splits alphabet into its symbols, refusing repeated symbols,
+// which would bias the draw, and characters that are not left alone in a
+// URL path (RFC 3986 unreserved characters only)
+func tokenSymbols(alphabet string) ([]rune, error) {
+	seen := make(map[rune]bool)
+	var symbols []rune
+	for _, c := range alphabet {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '.', c == '_', c == '~':
+		default:
+			return nil, fmt.Errorf("token alphabet: %q is not allowed in a URL path", c)
+		}
+		if seen[c] {
+			return nil, fmt.Errorf("token alphabet: %q is repeated", c)
+		}
+		seen[c] = true
+		symbols = append(symbols, c)
+	}
+	if len(symbols) < 2 {
+		return nil, errors.New("token alphabet must have at least 2 symbols")
+	}
+	return symbols, nil
+}
+
+// TokenEntropy estimates the entropy in bits of a user-chosen token by
+// assuming each character was drawn from the character classes it uses
+func TokenEntropy(token string) float64 {
+	var lower, upper, digit, other bool
+	for _, c := range token {
+		switch {
+		case c >= 'a' && c <= 'z':
+			lower = true
+		case c >= 'A' && c <= 'Z':
+			upper = true
+		case c >= '0' && c <= '9':
+			digit = true
+		default:
+			other = true
+		}
+	}
+	pool := 0
+	if lower {
+		pool += 26
+	}
+	if upper {
+		pool += 26
+	}
+	if digit {
+		pool += 10
+	}
+	if other {
+		pool += 32
+	}
+	if pool < 2 {
+		return 0
+	}
+	return float64(len([]rune(token))) * math.Log2(float64(pool))
 }
 
 // GetSessionID returns a base64 encoded string of 40 random characters
@@ -99,60 +191,116 @@ func GetSessionID() (string, error) {
 }
 
 // GetInterfaceAddress returns the address of the network interface to
-// bind the server to. If the interface is "any", it will return 0.0.0.0.
+// bind the server to, preferring the given IP version (4 or 6, 0 means 4).
+// If the interface is "any", it will return the unspecified address.
 // If no interface is found with that name, an error is returned
-func GetInterfaceAddress(ifaceString string) (string, error) {
+func GetInterfaceAddress(ifaceString string, version int) (string, error) {
 	if ifaceString == "any" {
+		if version == 6 {
+			return "::", nil
+		}
 		return "0.0.0.0", nil
 	}
-	ifaces, err := net.Interfaces()
+	iface, err := net.InterfaceByName(ifaceString)
 	if err != nil {
-		return "", err
+		return "", errors.New("unable to find interface")
 	}
-	var candidateInterface *net.Interface
-	for _, iface := range ifaces {
-		if iface.Name == ifaceString {
-			candidateInterface = &iface
-			break
-		}
+	return FindIPVersion(*iface, version)
+}
+
+// GetInterfaceAddresses returns the IPv4 and the IPv6 addresses of the
+// network interface, the preferred IP version first
+func GetInterfaceAddresses(ifaceString string, version int) ([]string, error) {
+	iface, err := net.InterfaceByName(ifaceString)
+	if err != nil {
+		return nil, errors.New("unable to find interface")
 	}
-	if candidateInterface != nil {
-		ip, err := FindIP(*candidateInterface)
-		if err != nil {
-			return "", err
+	ipv4, ipv6, err := interfaceIPs(*iface)
+	if err != nil {
+		return nil, err
+	}
+	addrs := []string{}
+	for _, ip := range []string{ipv4, ipv6} {
+		if ip != "" {
+			addrs = append(addrs, ip)
 		}
-		return ip, nil
 	}
-	return "", errors.New("unable to find interface")
+	if len(addrs) == 0 {
+		return nil, errors.New("unable to find an IP for this interface")
+	}
+	if version == 6 && ipv4 != "" && ipv6 != "" {
+		addrs[0], addrs[1] = addrs[1], addrs[0]
+	}
+	return addrs, nil
 }
 
-// FindIP returns the IP address of the passed interface, and an error
+// FindIP returns the IP address of the passed interface, and an error.
+// IPv4 addresses are preferred over IPv6 ones
 func FindIP(iface net.Interface) (string, error) {
-	var ip string
-	addrs, err := iface.Addrs()
+	return FindIPVersion(iface, 4)
+}
+
+// FindIPVersion returns the IP address of the passed interface, preferring
+// the given IP version and falling back to the other one
+func FindIPVersion(iface net.Interface, version int) (string, error) {
+	ipv4, ipv6, err := interfaceIPs(iface)
 	if err != nil {
 		return "", err
 	}
+	preferred, fallback := ipv4, ipv6
+	if version == 6 {
+		preferred, fallback = ipv6, ipv4
+	}
+	if preferred != "" {
+		return preferred, nil
+	}
+	if fallback != "" {
+		return fallback, nil
+	}
+	return "", errors.New("unable to find an IP for this interface")
+}
+
+// interfaceIPs returns the IPv4 and IPv6 addresses of iface. Addresses are
+// returned without brackets. A link-local IPv6 address is only returned when
+// no other IPv6 address is found, and carries the interface name as zone
+func interfaceIPs(iface net.Interface) (ipv4 string, ipv6 string, err error) {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", "", err
+	}
+	var linkLocal string
 	for _, addr := range addrs {
-		if ipnet, ok := addr.(*net.IPNet); ok {
-			if ipnet.IP.IsLinkLocalUnicast() {
-				continue
-			}
-			if ipnet.IP.To4() != nil {
-				ip = ipnet.IP.String()
-				continue
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if ipnet.IP.To4() != nil {
+			if ipv4 == "" && !ipnet.IP.IsLinkLocalUnicast() {
+				ipv4 = ipnet.IP.String()
 			}
-			// Use IPv6 only if an IPv4 hasn't been found yet.
-			// This is eventually overwritten with an IPv4, if found (see above)
-			if ip == "" {
-				ip = "[" + ipnet.IP.String() + "]"
+			continue
+		}
+		if ipnet.IP.IsLinkLocalUnicast() {
+			if linkLocal == "" {
+				linkLocal = ipnet.IP.String() + "%" + iface.Name
 			}
+			continue
+		}
+		if ipv6 == "" {
+			ipv6 = ipnet.IP.String()
 		}
 	}
-	if ip == "" {
-		return "", errors.New("unable to find an IP for this interface")
+	if ipv6 == "" {
+		ipv6 = linkLocal
 	}
-	return ip, nil
+	return ipv4, ipv6, nil
+}
+
+// URLHost joins host and port into the host part of a URL, wrapping IPv6
+// addresses in brackets and escaping their zone
+func URLHost(host string, port int) string {
+	hostport := net.JoinHostPort(host, strconv.Itoa(port))
+	return strings.Replace(hostport, "%", "%25", 1)
 }
 
 // ReadFilenames from dir
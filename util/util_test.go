@@ -0,0 +1,142 @@
+package util
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGetRandomURLPath(t *testing.T) {
+	tests := []struct {
+		name     string
+		bits     int
+		alphabet string
+		length   int
+	}{
+		{"defaults", 0, "", 25},
+		{"hex", 64, "0123456789abcdef", 16},
+		{"binary", 8, "01", 8},
+		{"unreserved", 8, "-._~", 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GetRandomURLPath(tt.bits, tt.alphabet)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != tt.length {
+				t.Errorf("GetRandomURLPath() = %q, want length %d", got, tt.length)
+			}
+			alphabet := tt.alphabet
+			if alphabet == "" {
+				alphabet = DefaultTokenAlphabet
+			}
+			for _, c := range got {
+				if !strings.ContainsRune(alphabet, c) {
+					t.Errorf("GetRandomURLPath() = %q, %q is not in the alphabet", got, c)
+				}
+			}
+		})
+	}
+	for _, alphabet := range []string{"a", "aab", "ab/", "ab?", "ab#", "ab%", "ab ", "abé"} {
+		if _, err := GetRandomURLPath(128, alphabet); err == nil {
+			t.Errorf("GetRandomURLPath() with the alphabet %q should fail", alphabet)
+		}
+	}
+}
+
+func TestTokenEntropy(t *testing.T) {
+	tests := []struct {
+		token string
+		weak  bool
+	}{
+		{"abcd", true},
+		{"myshare", true},
+		{"0123456789", true},
+		{"k3v9x0q2m8z7w1r4t6y5", false},
+	}
+	for _, tt := range tests {
+		if weak := TokenEntropy(tt.token) < MinTokenBits; weak != tt.weak {
+			t.Errorf("TokenEntropy(%q) weak = %v, want %v", tt.token, weak, tt.weak)
+		}
+	}
+}
+
+func TestURLHost(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"192.168.1.2", "192.168.1.2:8080"},
+		{"fd00::2", "[fd00::2]:8080"},
+		{"fe80::1%eth0", "[fe80::1%25eth0]:8080"},
+		{"mylan.com", "mylan.com:8080"},
+	}
+	for _, tt := range tests {
+		if got := URLHost(tt.host, 8080); got != tt.want {
+			t.Errorf("URLHost(%q) = %q, want %q", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestEncryptedZipFiles(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "folder")
+	os.MkdirAll(filepath.Join(dir, "sub"), 0755)
+	content := bytes.Repeat([]byte("encrypted zip "), 10000)
+	os.WriteFile(filepath.Join(dir, "sub", "file.txt"), content, 0644)
+	name, err := EncryptedZipFiles([]string{dir}, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(name)
+	r, err := zip.OpenReader(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var names []string
+	for _, f := range r.File {
+		names = append(names, f.Name)
+	}
+	if got := strings.Join(names, ","); got != "folder/,folder/sub/,folder/sub/file.txt" {
+		t.Fatalf("entries = %s", got)
+	}
+	f := r.File[2]
+	if f.Method != methodWinZipAES || f.Flags&0x1 == 0 || !bytes.Equal(f.Extra, aesExtra(zip.Deflate)) {
+		t.Fatalf("the entry is not encrypted with WinZip AES")
+	}
+	raw, _ := f.OpenRaw()
+	data, _ := io.ReadAll(raw)
+	if int64(len(data)) != int64(f.CompressedSize64) || f.UncompressedSize64 != uint64(len(content)) {
+		t.Fatalf("sizes = %d, %d, want %d, %d", f.CompressedSize64, f.UncompressedSize64, len(data), len(content))
+	}
+	salt, verifier := data[:aesSaltSize], data[aesSaltSize:aesSaltSize+2]
+	ciphertext, mac := data[aesSaltSize+2:len(data)-aesMACSize], data[len(data)-aesMACSize:]
+	if _, _, v := winZipAESKeys("wrong", salt); bytes.Equal(v, verifier) {
+		t.Error("the verifier matches the wrong password")
+	}
+	encryption, authentication, v := winZipAESKeys("password", salt)
+	if !bytes.Equal(v, verifier) {
+		t.Fatal("the verifier doesn't match the password")
+	}
+	h := hmac.New(sha1.New, authentication)
+	h.Write(ciphertext)
+	if !bytes.Equal(h.Sum(nil)[:aesMACSize], mac) {
+		t.Fatal("authentication code mismatch")
+	}
+	block, _ := aes.NewCipher(encryption)
+	compressed := make([]byte, len(ciphertext))
+	newWinZipCTR(block).XORKeyStream(compressed, ciphertext)
+	got, err := io.ReadAll(flate.NewReader(bytes.NewReader(compressed)))
+	if err != nil || !bytes.Equal(got, content) {
+		t.Errorf("decrypted %d bytes, not matching the content: %v", len(got), err)
+	}
+}
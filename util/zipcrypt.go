@@ -0,0 +1,229 @@
+package util
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Entries are encrypted with WinZip AES, which 7-Zip, WinZip and most archive
+// managers open. AE-2 leaves the CRC out, which would tell about the content
+const (
+	methodWinZipAES  = 99
+	aesExtraID       = 0x9901
+	aesVendorVersion = 2
+	aesStrength256   = 3
+	aesSaltSize      = 16
+	aesKeySize       = 32
+	aesMACSize       = 10
+	aesIterations    = 1000
+	// zipVersionAES is the version needed to extract WinZip AES entries,
+	// and zipVersionStore the one of folders
+	zipVersionAES   = 51
+	zipVersionStore = 20
+)
+
+// winZipCTR is AES in counter mode with the little-endian counter, starting
+// at 1, of WinZip
+type winZipCTR struct {
+	block   cipher.Block
+	counter [aes.BlockSize]byte
+	stream  [aes.BlockSize]byte
+	used    int
+}
+
+func newWinZipCTR(block cipher.Block) *winZipCTR {
+	return &winZipCTR{block: block, used: aes.BlockSize}
+}
+
+func (c *winZipCTR) XORKeyStream(dst, src []byte) {
+	for i := range src {
+		if c.used == aes.BlockSize {
+			for j := range c.counter {
+				c.counter[j]++
+				if c.counter[j] != 0 {
+					break
+				}
+			}
+			c.block.Encrypt(c.stream[:], c.counter[:])
+			c.used = 0
+		}
+		dst[i] = src[i] ^ c.stream[c.used]
+		c.used++
+	}
+}
+
+// aesWriter encrypts and authenticates what is written to it
+type aesWriter struct {
+	w      io.Writer
+	stream cipher.Stream
+	mac    hash.Hash
+	n      int64
+}
+
+func (a *aesWriter) Write(p []byte) (int, error) {
+	buf := make([]byte, len(p))
+	a.stream.XORKeyStream(buf, p)
+	a.mac.Write(buf)
+	n, err := a.w.Write(buf)
+	a.n += int64(n)
+	return n, err
+}
+
+// winZipAESKeys derives the encryption key, the authentication key and the
+// password verifier from password and salt
+func winZipAESKeys(password string, salt []byte) (encryption, authentication, verifier []byte) {
+	keys := pbkdf2.Key([]byte(password), salt, aesIterations, 2*aesKeySize+2, sha1.New)
+	return keys[:aesKeySize], keys[aesKeySize : 2*aesKeySize], keys[2*aesKeySize:]
+}
+
+// aesExtra returns the extra field of an entry encrypted with WinZip AES,
+// which records the actual compression method
+func aesExtra(method uint16) []byte {
+	b := make([]byte, 11)
+	binary.LittleEndian.PutUint16(b[0:], aesExtraID)
+	binary.LittleEndian.PutUint16(b[2:], 7)
+	binary.LittleEndian.PutUint16(b[4:], aesVendorVersion)
+	copy(b[6:], "AE")
+	b[8] = aesStrength256
+	binary.LittleEndian.PutUint16(b[9:], method)
+	return b
+}
+
+// msDosTime converts t to the date and time fields of a zip header
+func msDosTime(t time.Time) (date uint16, clock uint16) {
+	date = uint16(t.Day() + int(t.Month())<<5 + (t.Year()-1980)<<9)
+	clock = uint16(t.Second()/2 + t.Minute()<<5 + t.Hour()<<11)
+	return date, clock
+}
+
+// addEncrypted adds the file or folder at path to zw as name, encrypting
+// the content of files with password
+func addEncrypted(zw *zip.Writer, path string, name string, info os.FileInfo, password string) error {
+	fh, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	fh.Name = name
+	fh.ModifiedDate, fh.ModifiedTime = msDosTime(info.ModTime())
+	// Names that are not ASCII are flagged as UTF-8
+	for _, r := range name {
+		if r >= utf8.RuneSelf {
+			fh.Flags |= 0x800
+			break
+		}
+	}
+	if info.IsDir() {
+		fh.Name += "/"
+		fh.Method = zip.Store
+		fh.UncompressedSize64 = 0
+		fh.UncompressedSize = 0
+		fh.ReaderVersion = zipVersionStore
+		fh.CreatorVersion = fh.CreatorVersion&0xff00 | zipVersionStore
+		_, err := zw.CreateRaw(fh)
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// The sizes are only known once the file is written, they follow it in
+	// a data descriptor
+	fh.Method = methodWinZipAES
+	fh.Flags |= 0x1 | 0x8
+	fh.Extra = aesExtra(zip.Deflate)
+	fh.ReaderVersion = zipVersionAES
+	fh.CreatorVersion = fh.CreatorVersion&0xff00 | zipVersionAES
+	w, err := zw.CreateRaw(fh)
+	if err != nil {
+		return err
+	}
+	salt := make([]byte, aesSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	encryption, authentication, verifier := winZipAESKeys(password, salt)
+	if _, err := w.Write(append(salt, verifier...)); err != nil {
+		return err
+	}
+	block, err := aes.NewCipher(encryption)
+	if err != nil {
+		return err
+	}
+	encrypted := &aesWriter{w: w, stream: newWinZipCTR(block), mac: hmac.New(sha1.New, authentication)}
+	deflater, err := flate.NewWriter(encrypted, flate.DefaultCompression)
+	if err != nil {
+		return err
+	}
+	size, err := io.Copy(deflater, f)
+	if err != nil {
+		return err
+	}
+	if err := deflater.Close(); err != nil {
+		return err
+	}
+	if _, err := w.Write(encrypted.mac.Sum(nil)[:aesMACSize]); err != nil {
+		return err
+	}
+	fh.UncompressedSize64 = uint64(size)
+	fh.CompressedSize64 = uint64(aesSaltSize+len(verifier)+aesMACSize) + uint64(encrypted.n)
+	fh.UncompressedSize = uint32(min(fh.UncompressedSize64, 0xffffffff))
+	fh.CompressedSize = uint32(min(fh.CompressedSize64, 0xffffffff))
+	return nil
+}
+
+// EncryptedZipFiles zips the files and folders like ZipFiles, encrypting
+// their content with AES-256 and password, and returns the resulting zip's
+// filename. The names of the files are not encrypted
+func EncryptedZipFiles(files []string, password string) (string, error) {
+	tmpfile, err := os.CreateTemp("", "qrcp*.zip")
+	if err != nil {
+		return "", err
+	}
+	zw := zip.NewWriter(tmpfile)
+	for _, filename := range files {
+		root := filepath.Dir(filepath.Clean(filename))
+		err = filepath.Walk(filename, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			// Links and special files are left out
+			if !info.IsDir() && !info.Mode().IsRegular() {
+				return nil
+			}
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			return addEncrypted(zw, path, filepath.ToSlash(rel), info, password)
+		})
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if cerr := tmpfile.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmpfile.Name())
+		return "", err
+	}
+	return tmpfile.Name(), nil
+}
@@ -0,0 +1,84 @@
+package wormhole
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// maxNameplate bounds the number at the start of the codes
+const maxNameplate = 99
+
+// codeWords is the number of words of a code after the nameplate
+const codeWords = 2
+
+// NewCode returns a random code such as "7-crossbow-lantern". The number,
+// called the nameplate, is public and used to find the sender, while the
+// words are the secret of the key exchange
+func NewCode() (string, error) {
+	nameplate, err := rand.Int(rand.Reader, big.NewInt(maxNameplate))
+	if err != nil {
+		return "", err
+	}
+	parts := []string{strconv.FormatInt(nameplate.Int64()+1, 10)}
+	for i := 0; i < codeWords; i++ {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(words))))
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, words[n.Int64()])
+	}
+	return strings.Join(parts, "-"), nil
+}
+
+// ParseCode normalizes code and returns its nameplate
+func ParseCode(code string) (normalized string, nameplate string, err error) {
+	normalized = strings.ToLower(strings.TrimSpace(code))
+	nameplate, secret, found := strings.Cut(normalized, "-")
+	if _, err := strconv.Atoi(nameplate); err != nil || !found || secret == "" || strings.ContainsAny(secret, " \t") {
+		return "", "", fmt.Errorf("invalid code %q, it looks like 7-crossbow-lantern", code)
+	}
+	return normalized, nameplate, nil
+}
+
+// words are the words of the codes, 8 bits each
+var words = [256]string{
+	"acid", "acorn", "actor", "adobe", "agent", "alarm", "album", "alley",
+	"amber", "angle", "ankle", "apple", "apron", "arena", "armor", "arrow",
+	"atlas", "attic", "autumn", "axis", "bacon", "badge", "bagel", "baker",
+	"bamboo", "banjo", "barrel", "basil", "basin", "beacon", "beard",
+	"beetle", "bell", "bench", "berry", "bison", "blade", "blanket",
+	"blossom", "boat", "bonnet", "border", "bottle", "boulder", "bracket",
+	"branch", "bread", "breeze", "brick", "bridge", "bronze", "brook",
+	"broom", "bubble", "bucket", "buffalo", "bugle", "bundle", "butter",
+	"cabin", "cactus", "camel", "camera", "candle", "canoe", "canyon",
+	"carpet", "castle", "cedar", "cellar", "chalk", "cherry", "chimney",
+	"circle", "citrus", "clover", "cobalt", "comet", "copper", "coral",
+	"cotton", "cradle", "crater", "crayon", "cricket", "crossbow", "crown",
+	"crystal", "cupboard", "curtain", "cushion", "dagger", "daisy", "delta",
+	"desert", "diamond", "dolphin", "domino", "donkey", "dragon", "drum",
+	"eagle", "easel", "echo", "eclipse", "elbow", "ember", "emerald",
+	"engine", "falcon", "feather", "fennel", "ferry", "fiddle", "fig",
+	"flame", "flute", "forest", "fossil", "fountain", "fox", "galaxy",
+	"garden", "garlic", "gazelle", "geyser", "ginger", "glacier", "globe",
+	"goblet", "gondola", "granite", "grape", "gravel", "guitar", "hammer",
+	"harbor", "harp", "hazel", "helmet", "heron", "hickory", "honey",
+	"horizon", "hornet", "iceberg", "igloo", "island", "ivory", "jacket",
+	"jaguar", "jasmine", "jelly", "jewel", "jungle", "kayak", "kettle",
+	"kiwi", "koala", "ladder", "lagoon", "lantern", "lemon", "lentil", "lily",
+	"linen", "lizard", "lobster", "locket", "lotus", "magnet", "mango",
+	"maple", "marble", "meadow", "melon", "meteor", "mirror", "mitten",
+	"monsoon", "mosaic", "mustard", "nectar", "needle", "nickel", "noodle",
+	"nutmeg", "oasis", "ocean", "olive", "onion", "orbit", "orchid", "otter",
+	"owl", "paddle", "palace", "panda", "paprika", "parrot", "pebble",
+	"pepper", "pigeon", "pillow", "pilot", "pine", "planet", "plum", "pocket",
+	"pollen", "poppy", "prairie", "prism", "pumpkin", "puzzle", "quartz",
+	"quill", "rabbit", "radar", "raft", "rainbow", "raven", "reef", "ribbon",
+	"river", "robin", "rocket", "saddle", "saffron", "sapphire", "satchel",
+	"scarf", "shadow", "shell", "silver", "sketch", "sparrow", "spider",
+	"spruce", "squirrel", "summit", "sunset", "tablet", "tango", "teapot",
+	"thimble", "thistle", "thunder", "tiger", "timber", "tomato", "topaz",
+	"torch", "tulip", "tundra", "turtle",
+}
@@ -0,0 +1,91 @@
+package wormhole
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+
+	"github.com/gtank/ristretto255"
+)
+
+// elementSize is the size of an encoded group element
+const elementSize = 32
+
+// ErrWrongCode is returned when the other side used a different code
+var ErrWrongCode = errors.New("wrong code, or the exchange was tampered with")
+
+// pake is one side of a CPace key exchange over ristretto255. Both sides
+// derive the generator from the code, so only a peer knowing the code
+// computes the same key, and an attacker gets a single guess per exchange
+type pake struct {
+	sid    string
+	secret *ristretto255.Scalar
+	// message is the element sent to the other side
+	message []byte
+}
+
+// hashParts hashes length-prefixed parts, so that they can't be confused
+func hashParts(parts ...[]byte) []byte {
+	hash := sha512.New()
+	for _, part := range parts {
+		hash.Write([]byte{byte(len(part) >> 8), byte(len(part))})
+		hash.Write(part)
+	}
+	return hash.Sum(nil)
+}
+
+// newPake starts an exchange for the code in the session sid
+func newPake(code string, sid string) (*pake, error) {
+	generator := ristretto255.NewElement().FromUniformBytes(hashParts([]byte("mocp-cpace"), []byte(code), []byte(sid)))
+	random := make([]byte, 64)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+	secret := ristretto255.NewScalar().FromUniformBytes(random)
+	message := ristretto255.NewElement().ScalarMult(secret, generator).Encode(nil)
+	return &pake{sid: sid, secret: secret, message: message}, nil
+}
+
+// keys are derived from the shared secret of an exchange
+type keys struct {
+	// data encrypts the stream
+	data []byte
+	// sender and receiver confirm that both sides derived the same keys
+	sender   []byte
+	receiver []byte
+}
+
+// finish computes the keys from the message of the other side. The
+// transcript is ordered as receiver message, then sender message
+func (p *pake) finish(peer []byte, receiverMessage []byte, senderMessage []byte) (*keys, error) {
+	element := ristretto255.NewElement()
+	if len(peer) != elementSize || element.Decode(peer) != nil {
+		return nil, ErrWrongCode
+	}
+	shared := ristretto255.NewElement().ScalarMult(p.secret, element)
+	if shared.Equal(ristretto255.NewElement().Zero()) == 1 {
+		return nil, ErrWrongCode
+	}
+	isk := hashParts([]byte("mocp-isk"), []byte(p.sid), shared.Encode(nil), receiverMessage, senderMessage)
+	derive := func(label string) []byte {
+		mac := hmac.New(sha256.New, isk)
+		mac.Write([]byte(label))
+		return mac.Sum(nil)
+	}
+	return &keys{
+		data:     derive("mocp data"),
+		sender:   derive("mocp confirm sender"),
+		receiver: derive("mocp confirm receiver"),
+	}, nil
+}
+
+// confirmation returns the proof that the side owning key derived the same
+// keys, bound to the transcript
+func confirmation(key []byte, receiverMessage []byte, senderMessage []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(receiverMessage)
+	mac.Write(senderMessage)
+	return mac.Sum(nil)
+}
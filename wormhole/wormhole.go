@@ -0,0 +1,398 @@
+package wormhole
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/claudiodangelis/qrcp/discovery"
+	"github.com/claudiodangelis/qrcp/e2e"
+	"github.com/claudiodangelis/qrcp/relay"
+	"github.com/claudiodangelis/qrcp/util"
+)
+
+// confirmHeader carries the key confirmation of the receiver
+const confirmHeader = "Mocp-Confirm"
+
+// confirmTimeout is how long the sender waits for the receiver to confirm
+// the key once exchanged. A receiver with the wrong code gives up without
+// telling the sender
+var confirmTimeout = 30 * time.Second
+
+// browseInterval is how long each look for the sender on the local
+// network lasts
+const browseInterval = 2 * time.Second
+
+// Options configure both sides of a transfer
+type Options struct {
+	// Relay is the URL of a relay to go through instead of the local
+	// network
+	Relay string
+	// Port is the port the sender listens on, random if 0
+	Port int
+	// Timeout bounds the time the receiver looks for the sender
+	Timeout time.Duration
+	// Progress is called as bytes are transferred
+	Progress func(name string, current, total int64)
+}
+
+// header describes the file, it is the first frame of the stream
+type header struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// sid binds the exchange to the nameplate
+func sid(nameplate string) string {
+	return "mocp-transfer/" + nameplate
+}
+
+// progress calls the callback of the options, if any
+func (o Options) progress(name string, current, total int64) {
+	if o.Progress != nil {
+		o.Progress(name, current, total)
+	}
+}
+
+// sender serves one file to the receiver knowing the code
+type sender struct {
+	code    string
+	sid     string
+	path    string
+	name    string
+	opts    Options
+	mutex   sync.Mutex
+	used    bool
+	keys    *keys
+	message []byte
+	peer    []byte
+	// confirm ends the transfer if the receiver doesn't confirm the key
+	confirm *time.Timer
+	done    chan error
+	once    sync.Once
+}
+
+// finish ends the transfer with err
+func (s *sender) finish(err error) {
+	s.once.Do(func() {
+		s.done <- err
+	})
+}
+
+// pake answers the message of the receiver. Only one exchange is allowed,
+// so that an attacker gets a single guess of the code
+func (s *sender) pake(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.used {
+		http.Error(w, "This code has already been used", http.StatusGone)
+		return
+	}
+	s.used = true
+	peer, err := io.ReadAll(io.LimitReader(r.Body, elementSize+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p, err := newPake(s.code, s.sid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		s.finish(err)
+		return
+	}
+	keys, err := p.finish(peer, peer, p.message)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		s.finish(err)
+		return
+	}
+	s.keys, s.message, s.peer = keys, p.message, peer
+	s.confirm = time.AfterFunc(confirmTimeout, func() {
+		s.finish(errors.New("the receiver did not confirm the code"))
+	})
+	w.Write(p.message)
+	w.Write(confirmation(keys.sender, peer, p.message))
+}
+
+// data streams the encrypted file once the receiver proved it knows the
+// code
+func (s *sender) data(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	keys, confirm := s.keys, s.confirm
+	s.keys = nil
+	s.mutex.Unlock()
+	if keys == nil {
+		http.Error(w, "No key exchange", http.StatusForbidden)
+		return
+	}
+	proof, err := hex.DecodeString(r.Header.Get(confirmHeader))
+	if err != nil || !hmac.Equal(proof, confirmation(keys.receiver, s.peer, s.message)) {
+		http.Error(w, "Wrong code", http.StatusForbidden)
+		s.finish(ErrWrongCode)
+		return
+	}
+	// The transfer may take longer than the confirmation
+	confirm.Stop()
+	f, err := os.Open(s.path)
+	if err != nil {
+		http.Error(w, "Unable to open file", http.StatusInternalServerError)
+		s.finish(err)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, "Unable to open file", http.StatusInternalServerError)
+		s.finish(err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	stream, err := e2e.NewWriter(w, keys.data)
+	if err != nil {
+		s.finish(err)
+		return
+	}
+	b, _ := json.Marshal(header{Name: s.name, Size: info.Size()})
+	stream.Write(b)
+	if err := stream.Flush(); err != nil {
+		s.finish(err)
+		return
+	}
+	counter := &progressCounter{name: s.name, total: info.Size(), opts: s.opts}
+	if _, err := io.Copy(stream, io.TeeReader(f, counter)); err != nil {
+		s.finish(err)
+		return
+	}
+	s.finish(stream.Close())
+}
+
+// Send waits for the receiver with code and sends it the file at path,
+// under name
+func Send(code string, path string, name string, opts Options) error {
+	code, nameplate, err := ParseCode(code)
+	if err != nil {
+		return err
+	}
+	s := &sender{code: code, sid: sid(nameplate), path: path, name: name, opts: opts, done: make(chan error, 1)}
+	var listener net.Listener
+	prefix := ""
+	if opts.Relay != "" {
+		ln, err := relay.ListenNameplate(opts.Relay, nameplate)
+		if err != nil {
+			return err
+		}
+		listener, prefix = ln, "/"+ln.Session
+	} else {
+		listener, err = net.Listen("tcp", net.JoinHostPort("", strconv.Itoa(opts.Port)))
+		if err != nil {
+			return err
+		}
+		port := listener.Addr().(*net.TCPAddr).Port
+		ips := []net.IP{}
+		interfaces, err := util.Interfaces(false)
+		if err != nil {
+			listener.Close()
+			return err
+		}
+		for _, address := range interfaces {
+			if ip := net.ParseIP(address); ip != nil {
+				ips = append(ips, ip)
+			}
+		}
+		hostname, _ := discovery.LocalHostname()
+		advertiser, err := discovery.Advertise(discovery.Share{
+			Instance:  fmt.Sprintf("mocp code %s on %s", nameplate, strings.TrimSuffix(hostname, ".local")),
+			Mode:      "code",
+			Nameplate: nameplate,
+			Port:      port,
+		}, ips)
+		if err != nil {
+			listener.Close()
+			return err
+		}
+		defer advertiser.Close()
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(prefix+"/pake", s.pake)
+	mux.HandleFunc(prefix+"/data", s.data)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go server.Serve(listener)
+	err = <-s.done
+	server.Shutdown(context.Background())
+	return err
+}
+
+// find returns the base URLs the sender with nameplate may be reached at
+func find(nameplate string, opts Options) ([]string, error) {
+	if opts.Relay != "" {
+		sessionURL, err := relay.Lookup(opts.Relay, nameplate)
+		if err != nil {
+			return nil, err
+		}
+		return []string{sessionURL}, nil
+	}
+	deadline := time.Now().Add(opts.Timeout)
+	for {
+		shares, err := discovery.Browse(browseInterval)
+		if err != nil {
+			return nil, err
+		}
+		for _, share := range shares {
+			if share.Mode != "code" || share.Nameplate != nameplate {
+				continue
+			}
+			urls := []string{}
+			for _, ip := range share.IPs {
+				urls = append(urls, "http://"+util.URLHost(ip.String(), share.Port))
+			}
+			if len(urls) > 0 {
+				return urls, nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("no sender found with the nameplate %s on the local network", nameplate)
+		}
+	}
+}
+
+// exchange runs the key exchange with the sender at base
+func exchange(base string, code string, nameplate string) (*keys, []byte, []byte, error) {
+	p, err := newPake(code, sid(nameplate))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	resp, err := http.Post(base+"/pake", "application/octet-stream", bytes.NewReader(p.message))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusGone {
+		return nil, nil, nil, errors.New("this code has already been used, ask the sender for a new one")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, nil, fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+	reply, err := io.ReadAll(io.LimitReader(resp.Body, 2*elementSize+1))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(reply) < elementSize {
+		return nil, nil, nil, ErrWrongCode
+	}
+	peer, proof := reply[:elementSize], reply[elementSize:]
+	keys, err := p.finish(peer, p.message, peer)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if !hmac.Equal(proof, confirmation(keys.sender, p.message, peer)) {
+		return nil, nil, nil, ErrWrongCode
+	}
+	return keys, p.message, peer, nil
+}
+
+// Receive finds the sender with code, and saves its file in dir. It returns
+// the path of the received file
+func Receive(code string, dir string, opts Options) (string, error) {
+	code, nameplate, err := ParseCode(code)
+	if err != nil {
+		return "", err
+	}
+	urls, err := find(nameplate, opts)
+	if err != nil {
+		return "", err
+	}
+	var keys *keys
+	var message, peer []byte
+	var base string
+	for _, base = range urls {
+		keys, message, peer, err = exchange(base, code, nameplate)
+		var netErr net.Error
+		if err == nil || !errors.As(err, &netErr) {
+			break
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodGet, base+"/data", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set(confirmHeader, hex.EncodeToString(confirmation(keys.receiver, message, peer)))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusForbidden {
+		return "", ErrWrongCode
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+	stream, err := e2e.NewReader(resp.Body, keys.data)
+	if err != nil {
+		return "", err
+	}
+	frame, err := stream.Next()
+	if err != nil {
+		return "", err
+	}
+	var h header
+	if err := json.Unmarshal(frame, &h); err != nil {
+		return "", err
+	}
+	name := filepath.Base(filepath.Clean("/" + h.Name))
+	if name == "/" || name == "." {
+		return "", errors.New("invalid file name")
+	}
+	target := filepath.Join(dir, name)
+	if _, err := os.Stat(target); err == nil {
+		return "", fmt.Errorf("%s already exists", target)
+	}
+	out, err := os.Create(target)
+	if err != nil {
+		return "", err
+	}
+	counter := &progressCounter{name: name, total: h.Size, opts: opts}
+	if _, err := io.Copy(io.MultiWriter(out, counter), stream); err != nil {
+		out.Close()
+		os.Remove(target)
+		return "", err
+	}
+	if err := out.Close(); err != nil {
+		return "", err
+	}
+	return target, nil
+}
+
+// progressCounter reports the bytes written to it
+type progressCounter struct {
+	name    string
+	total   int64
+	current int64
+	opts    Options
+}
+
+func (p *progressCounter) Write(b []byte) (int, error) {
+	p.current += int64(len(b))
+	p.opts.progress(p.name, p.current, p.total)
+	return len(b), nil
+}
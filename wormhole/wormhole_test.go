@@ -0,0 +1,97 @@
+package wormhole
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/claudiodangelis/qrcp/relay"
+)
+
+func TestTransferThroughRelay(t *testing.T) {
+	relayServer := httptest.NewServer(relay.NewServer(""))
+	defer relayServer.Close()
+	content := bytes.Repeat([]byte("wormhole"), 20000)
+	path := filepath.Join(t.TempDir(), "file.bin")
+	os.WriteFile(path, content, 0644)
+	code, err := NewCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := Options{Relay: relayServer.URL, Timeout: time.Second}
+	sent := make(chan error, 1)
+	go func() {
+		sent <- Send(code, path, "file.bin", opts)
+	}()
+	// Wait for the sender to register with the relay
+	time.Sleep(200 * time.Millisecond)
+	dir := t.TempDir()
+	received, err := Receive(code, dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-sent; err != nil {
+		t.Fatal(err)
+	}
+	got, _ := os.ReadFile(received)
+	if !bytes.Equal(got, content) {
+		t.Errorf("received %d bytes, not matching the content", len(got))
+	}
+}
+
+func TestTransferWrongCode(t *testing.T) {
+	relayServer := httptest.NewServer(relay.NewServer(""))
+	defer relayServer.Close()
+	path := filepath.Join(t.TempDir(), "file.bin")
+	os.WriteFile(path, []byte("secret"), 0644)
+	opts := Options{Relay: relayServer.URL, Timeout: time.Second}
+	go Send("7-crossbow-lantern", path, "file.bin", opts)
+	time.Sleep(200 * time.Millisecond)
+	if _, err := Receive("7-crossbow-lentil", t.TempDir(), opts); !errors.Is(err, ErrWrongCode) {
+		t.Fatalf("err = %v, want %v", err, ErrWrongCode)
+	}
+	// The code is burned after one attempt
+	if _, err := Receive("7-crossbow-lantern", t.TempDir(), opts); err == nil {
+		t.Fatal("expected the code to be used")
+	}
+}
+
+func TestTransferSlowerThanConfirmation(t *testing.T) {
+	defer func(timeout time.Duration) { confirmTimeout = timeout }(confirmTimeout)
+	confirmTimeout = 100 * time.Millisecond
+	relayServer := httptest.NewServer(relay.NewServer(""))
+	defer relayServer.Close()
+	content := bytes.Repeat([]byte("wormhole"), 20000)
+	path := filepath.Join(t.TempDir(), "file.bin")
+	os.WriteFile(path, content, 0644)
+	code, err := NewCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := Options{Relay: relayServer.URL, Timeout: time.Second}
+	// The sender takes a while for each chunk, past the confirmation
+	slow := opts
+	slow.Progress = func(name string, current, total int64) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	sent := make(chan error, 1)
+	go func() {
+		sent <- Send(code, path, "file.bin", slow)
+	}()
+	time.Sleep(200 * time.Millisecond)
+	received, err := Receive(code, t.TempDir(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-sent; err != nil {
+		t.Fatal(err)
+	}
+	got, _ := os.ReadFile(received)
+	if !bytes.Equal(got, content) {
+		t.Errorf("received %d bytes, not matching the content", len(got))
+	}
+}